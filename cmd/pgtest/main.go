@@ -0,0 +1,306 @@
+// Command pgtest lists, inspects and prunes the test databases created by the
+// pgtest package.
+//
+// It is configured the same way as pgtest.NewSupervisor, through the PGTEST_*
+// environment variables (e.g. PGTEST_HOST and PGTEST_DB_PREFIX).
+//
+// Usage:
+//
+//	pgtest [-db-prefix prefix] <command> [arguments]
+//
+// The commands are:
+//
+//	list    list the test databases, with their size, age, owner and status
+//	prune   drop the test databases matching filters
+//	drop    drop a single test database
+//	psql    run psql connected to a test database, such as one kept for a
+//	        failed test
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest"
+)
+
+const usage = `usage: pgtest [-db-prefix prefix] <command> [arguments]
+
+commands:
+  list [-json]
+        list the test databases, with their size, age, owner and status
+  prune [-older-than duration] [-prefix prefix] [-kept any|only|none] [-dry-run]
+        drop the test databases matching the filters
+  drop [-server name] <db>
+        drop a single test database
+  psql [-server name] <db>
+        run psql connected to a test database
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "pgtest: %s\n", err)
+
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		os.Exit(1)
+	}
+}
+
+// errUsage is returned for invalid arguments, after printing the usage.
+var errUsage = errors.New("invalid arguments")
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("pgtest", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), usage) }
+	dbPrefix := fs.String("db-prefix", "", "the prefix of the names of test databases (default: PGTEST_DB_PREFIX or pg_test_)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var opts []pgtest.Option
+	if *dbPrefix != "" {
+		opts = append(opts, pgtest.WithDBNamePrefix(*dbPrefix))
+	}
+
+	command, commandArgs := fs.Arg(0), fs.Args()[1:]
+	switch command {
+	case "list":
+		return runList(ctx, opts, commandArgs, stdout)
+	case "prune":
+		return runPrune(ctx, opts, commandArgs, stdout)
+	case "drop":
+		return runDrop(ctx, opts, commandArgs, stdout)
+	case "psql":
+		return runPsql(ctx, opts, commandArgs)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func runList(ctx context.Context, opts []pgtest.Option, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the test databases as JSON")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	admin, err := pgtest.NewAdmin(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	testDBs, err := admin.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, testDBs)
+	}
+
+	return writeTable(stdout, testDBs, time.Now())
+}
+
+func runPrune(ctx context.Context, opts []pgtest.Option, args []string, stdout io.Writer) error {
+	var filter pgtest.PruneFilter
+
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	fs.DurationVar(&filter.OlderThan, "older-than", 0, "only drop test databases last active at least this long ago")
+	fs.StringVar(&filter.Prefix, "prefix", "", "only drop test databases whose names start with this prefix")
+	kept := fs.String("kept", "any", "whether to drop test databases kept for failed tests: any, only or none")
+	dryRun := fs.Bool("dry-run", false, "only print the test databases which would be dropped")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	var err error
+	filter.Kept, err = parseKeptFilter(*kept)
+	if err != nil {
+		return err
+	}
+
+	admin, err := pgtest.NewAdmin(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	pruned, err := admin.Prune(ctx, filter, *dryRun)
+
+	verb := "dropped"
+	if *dryRun {
+		verb = "would drop"
+	}
+	for _, testDB := range pruned {
+		fmt.Fprintf(stdout, "%s %s\n", verb, testDB.Name)
+	}
+
+	return err
+}
+
+func runDrop(ctx context.Context, opts []pgtest.Option, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("drop", flag.ContinueOnError)
+	server := fs.String("server", "", "the server the test database is on, if there are multiple")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("drop takes exactly one test database, got %d", fs.NArg())
+	}
+
+	admin, err := pgtest.NewAdmin(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	name := fs.Arg(0)
+	if err := admin.Drop(ctx, *server, name); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "dropped %s\n", name)
+	return nil
+}
+
+func runPsql(ctx context.Context, opts []pgtest.Option, args []string) error {
+	fs := flag.NewFlagSet("psql", flag.ContinueOnError)
+	server := fs.String("server", "", "the server the test database is on, if there are multiple")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("psql takes exactly one test database, got %d", fs.NArg())
+	}
+
+	admin, err := pgtest.NewAdmin(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	// psql handles interrupts itself (e.g. to cancel a query), so it
+	// isn't killed when ctx is cancelled.
+	cmd, err := admin.Psql(context.WithoutCancel(ctx), *server, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+func parseKeptFilter(s string) (pgtest.KeptFilter, error) {
+	switch s {
+	case "any":
+		return pgtest.KeptOrNot, nil
+	case "only":
+		return pgtest.KeptOnly, nil
+	case "none":
+		return pgtest.NotKept, nil
+	default:
+		return 0, fmt.Errorf("invalid -kept %q: must be any, only or none", s)
+	}
+}
+
+func writeJSON(w io.Writer, testDBs []pgtest.TestDBInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(testDBs)
+}
+
+func writeTable(w io.Writer, testDBs []pgtest.TestDBInfo, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tNAME\tSIZE\tAGE\tOWNER\tSTATUS\tTEST")
+
+	for _, testDB := range testDBs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			testDB.Server,
+			testDB.Name,
+			formatSize(testDB.Size),
+			formatAge(testDB, now),
+			testDB.Owner,
+			status(testDB),
+			test(testDB),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// formatSize formats a size in bytes in the largest unit it has at least one
+// of (truncated), like pg_size_pretty.
+func formatSize(size int64) string {
+	if size < 0 {
+		return "-"
+	}
+
+	units := []string{"bytes", "kB", "MB", "GB", "TB"}
+
+	unit := 0
+	for size >= 10*1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%d %s", size, units[unit])
+}
+
+// formatAge formats how long ago the test database was last active.
+func formatAge(testDB pgtest.TestDBInfo, now time.Time) string {
+	lastActive, ok := testDB.LastActive()
+	if !ok {
+		return "-"
+	}
+
+	return now.Sub(lastActive).Round(time.Second).String()
+}
+
+func status(testDB pgtest.TestDBInfo) string {
+	switch a := testDB.Annotation; {
+	case a == nil:
+		return "-"
+	case a.Kept:
+		return "kept"
+	case a.Failed:
+		return "failed"
+	default:
+		return "ok"
+	}
+}
+
+func test(testDB pgtest.TestDBInfo) string {
+	a := testDB.Annotation
+	if a == nil {
+		return "-"
+	}
+
+	parts := []string{a.Test}
+	if a.Package != "" {
+		parts = append([]string{a.Package}, parts...)
+	}
+
+	return strings.Join(parts, ".")
+}
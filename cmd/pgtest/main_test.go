@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatSize(t *testing.T) {
+	testCases := map[string]struct {
+		size     int64
+		expected string
+	}{
+		"unknown":   {size: -1, expected: "-"},
+		"bytes":     {size: 8191, expected: "8191 bytes"},
+		"kilobyte":  {size: 10 * 1024, expected: "10 kB"},
+		"truncated": {size: 7654321, expected: "7474 kB"},
+		"gigabyte":  {size: 20 << 30, expected: "20 GB"},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if got := formatSize(testCase.size); got != testCase.expected {
+				t.Errorf("formatSize(%d) = %q; want %q", testCase.size, got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestParseKeptFilter(t *testing.T) {
+	for s, expected := range map[string]pgtest.KeptFilter{
+		"any":  pgtest.KeptOrNot,
+		"only": pgtest.KeptOnly,
+		"none": pgtest.NotKept,
+	} {
+		if got, err := parseKeptFilter(s); err != nil || got != expected {
+			t.Errorf("parseKeptFilter(%q) = %v, %v; want %v, nil", s, got, err, expected)
+		}
+	}
+
+	if _, err := parseKeptFilter("yes"); err == nil {
+		t.Errorf("parseKeptFilter(%q) = nil error; want error", "yes")
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 15, 0, 0, 0, time.UTC)
+
+	testDBs := []pgtest.TestDBInfo{
+		{
+			AnnotatedTestDB: pgtest.AnnotatedTestDB{
+				Server: "default",
+				Name:   "pg_test_1",
+				Annotation: &pgtest.Annotation{
+					Package:    "store",
+					Test:       "TestFoo",
+					AcquiredAt: now.Add(-90 * time.Second),
+					Failed:     true,
+					Kept:       true,
+				},
+			},
+			Owner: "postgres",
+			Size:  8192,
+		},
+		{
+			AnnotatedTestDB: pgtest.AnnotatedTestDB{Server: "default", Name: "pg_test_2"},
+			Owner:           "postgres",
+			Size:            -1,
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, testDBs, now); err != nil {
+		t.Fatalf("unexpected error from writeTable: %s", err)
+	}
+
+	expected := "" +
+		"SERVER   NAME       SIZE        AGE    OWNER     STATUS  TEST\n" +
+		"default  pg_test_1  8192 bytes  1m30s  postgres  kept    store.TestFoo\n" +
+		"default  pg_test_2  -           -      postgres  -       -\n"
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("writeTable wrote unexpected table (-want +got):\n%s", diff)
+	}
+}
@@ -10,11 +10,13 @@ import (
 
 	"github.com/ShawnROGrady/go-pgtest/examples/common/db"
 	"github.com/ShawnROGrady/go-pgtest/pgtest"
+	"github.com/ShawnROGrady/go-pgtest/pgtest/localserver"
 	"github.com/stretchr/testify/require"
 )
 
 var (
 	keepDatabasesForFailed = flag.Bool("keep-databases-for-failed", false, "keep test databases for failed tests")
+	localServer            = flag.Bool("local-server", false, "run tests against a temporary postgres server started from local binaries")
 )
 
 var (
@@ -26,11 +28,27 @@ func initPgTestSupervisor() error {
 	var err error
 
 	pgtestSupervisorInitOnce.Do(func() {
-		pgtestSupervisor, err = pgtest.NewSupervisor(
-			context.Background(),
+		ctx := context.Background()
+		opts := []pgtest.Option{
 			pgtest.WithResetOp(pgtest.TruncateAllTablesExcept("schema_migrations")),
 			pgtest.WithKeepDatabasesForFailed(*keepDatabasesForFailed),
-		)
+		}
+
+		var srv *localserver.Server
+		if *localServer {
+			srv, err = localserver.Start(ctx)
+			if err != nil {
+				return
+			}
+
+			// The server is stopped when the supervisor is shutdown.
+			opts = append(opts, pgtest.WithManagedServer(srv))
+		}
+
+		pgtestSupervisor, err = pgtest.NewSupervisor(ctx, opts...)
+		if err != nil && srv != nil {
+			_ = srv.Stop(ctx)
+		}
 	})
 
 	return err
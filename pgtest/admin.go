@@ -0,0 +1,338 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// An Admin manages the test databases on the servers outside of tests, such
+// as to inspect test databases kept for failed tests, or clean up test
+// databases left behind by test binaries which didn't shutdown cleanly. It is
+// what the pgtest command is built on.
+type Admin struct {
+	factories []*testDBFactory
+}
+
+// NewAdmin returns an Admin for the test databases on the servers, which is
+// configured the same way as NewSupervisor (including by the environment).
+// Only databases whose names start with the test db name prefix (see
+// WithDBNamePrefix) are treated as test databases.
+func NewAdmin(ctx context.Context, opts ...Option) (*Admin, error) {
+	conf, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := new(Admin)
+	for _, server := range conf.servers {
+		rootDBParams := server.paramFactory(defaultRootDBName)
+		if err := rootDBParams.Validate(); err != nil {
+			admin.Close()
+			return nil, err
+		}
+
+		factory, err := openTestDBFactory(ctx, server.name, rootDBParams.URI().String(), server.paramFactory, conf.naming)
+		if err != nil {
+			admin.Close()
+			return nil, err
+		}
+
+		admin.factories = append(admin.factories, factory)
+	}
+
+	return admin, nil
+}
+
+// Close closes the connections to the servers.
+func (a *Admin) Close() {
+	for _, factory := range a.factories {
+		factory.close()
+	}
+}
+
+// A TestDBInfo describes a test database.
+type TestDBInfo struct {
+	AnnotatedTestDB
+
+	// Owner is the name of the role which owns the test database.
+	Owner string `json:"owner"`
+
+	// Size is the size of the test database in bytes, or -1 if it isn't
+	// known because the user can't connect to the test database.
+	Size int64 `json:"size"`
+
+	// Modified is when the test database's directory on the server was
+	// last modified, which happens when it is created and when tables are
+	// created, truncated or dropped in it. It is nil if the user isn't
+	// allowed to run pg_stat_file (e.g. isn't a superuser).
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// LastActive returns roughly when the test database was last used, which is
+// what its age is based on. This is when its directory was last modified (see
+// Modified) if known, otherwise when it was created if it was named by
+// DescriptiveDBName, and only otherwise when a test last got it if it was
+// annotated (see WithAnnotations).
+func (info TestDBInfo) LastActive() (time.Time, bool) {
+	if info.Modified != nil {
+		return *info.Modified, true
+	}
+
+	if created, ok := descriptiveDBNameTime(info.Name); ok {
+		return created, true
+	}
+
+	if info.Annotation != nil {
+		return info.Annotation.AcquiredAt, true
+	}
+
+	return time.Time{}, false
+}
+
+// Kept returns whether the test database was kept for a failed test, which is
+// only known if it was annotated (see WithAnnotations).
+func (info TestDBInfo) Kept() bool {
+	return info.Annotation != nil && info.Annotation.Kept
+}
+
+// List returns the test databases on each server, ordered by server then name.
+func (a *Admin) List(ctx context.Context) ([]TestDBInfo, error) {
+	var testDBs []TestDBInfo
+	for _, factory := range a.factories {
+		serverTestDBs, err := factory.testDBInfo(ctx)
+		if err != nil {
+			return nil, a.serverError(factory, err)
+		}
+
+		testDBs = append(testDBs, serverTestDBs...)
+	}
+
+	return testDBs, nil
+}
+
+// testDBInfo returns the test databases on the server.
+func (s *testDBFactory) testDBInfo(ctx context.Context) ([]TestDBInfo, error) {
+	infos, err := getDatabaseInfo(ctx, s.rootDB.db, s.naming.namePrefix())
+	if err != nil {
+		return nil, fmt.Errorf("get test dbs: %w", err)
+	}
+
+	testDBs := make([]TestDBInfo, 0, len(infos))
+	for _, info := range infos {
+		testDBs = append(testDBs, TestDBInfo{
+			AnnotatedTestDB: AnnotatedTestDB{
+				Server:     s.server,
+				Name:       info.name,
+				Annotation: parseAnnotation(info.comment),
+			},
+			Owner:    info.owner,
+			Size:     info.size,
+			Modified: info.modified,
+		})
+	}
+
+	return testDBs, nil
+}
+
+// A KeptFilter chooses test databases by whether they were kept for failed
+// tests.
+type KeptFilter int
+
+const (
+	// KeptOrNot chooses test databases whether or not they were kept.
+	KeptOrNot KeptFilter = iota
+
+	// KeptOnly only chooses test databases which were kept.
+	KeptOnly
+
+	// NotKept only chooses test databases which weren't kept.
+	NotKept
+)
+
+// A PruneFilter chooses which test databases to drop. The zero value chooses
+// every test database.
+type PruneFilter struct {
+	// OlderThan only chooses test databases which were last active at
+	// least this long ago (see TestDBInfo.LastActive). Test databases for
+	// which this isn't known are never chosen if this is set.
+	OlderThan time.Duration
+
+	// Prefix only chooses test databases whose names start with Prefix,
+	// including the test db name prefix (e.g. "pg_test_store_" for the
+	// test databases of the store package named by DescriptiveDBName).
+	Prefix string
+
+	// Kept chooses test databases by whether they were kept for failed
+	// tests. Test databases without an annotation are treated as not
+	// kept.
+	Kept KeptFilter
+}
+
+func (f PruneFilter) matches(info TestDBInfo, now time.Time) bool {
+	if !strings.HasPrefix(info.Name, f.Prefix) {
+		return false
+	}
+
+	switch f.Kept {
+	case KeptOnly:
+		if !info.Kept() {
+			return false
+		}
+	case NotKept:
+		if info.Kept() {
+			return false
+		}
+	}
+
+	if f.OlderThan > 0 {
+		lastActive, ok := info.LastActive()
+		if !ok || now.Sub(lastActive) < f.OlderThan {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Prune drops the test databases chosen by filter, and returns them. If dryRun
+// is set, the test databases are only returned.
+//
+// Prune doesn't know whether a test database is being used by a test binary
+// which is still running, so filter should exclude any test databases which
+// may be (e.g. with OlderThan).
+func (a *Admin) Prune(ctx context.Context, filter PruneFilter, dryRun bool) ([]TestDBInfo, error) {
+	now := time.Now()
+
+	var (
+		pruned []TestDBInfo
+		errs   []error
+	)
+
+	for _, factory := range a.factories {
+		testDBs, err := factory.testDBInfo(ctx)
+		if err != nil {
+			errs = append(errs, a.serverError(factory, err))
+			continue
+		}
+
+		var toDrop []TestDBInfo
+		for _, testDB := range testDBs {
+			if filter.matches(testDB, now) {
+				toDrop = append(toDrop, testDB)
+			}
+		}
+
+		if dryRun || len(toDrop) == 0 {
+			pruned = append(pruned, toDrop...)
+			continue
+		}
+
+		force, err := factory.supportsDropForce(ctx)
+		if err != nil {
+			errs = append(errs, a.serverError(factory, err))
+			continue
+		}
+
+		for _, testDB := range toDrop {
+			if err := factory.rootDB.dropDatabase(ctx, testDB.Name, force); err != nil {
+				errs = append(errs, a.serverError(factory, fmt.Errorf("drop %s: %w", testDB.Name, err)))
+				continue
+			}
+
+			pruned = append(pruned, testDB)
+		}
+	}
+
+	return pruned, errors.Join(errs...)
+}
+
+// Drop drops the named test database on the named server. The server can be
+// left empty if there is only one.
+func (a *Admin) Drop(ctx context.Context, server, name string) error {
+	factory, err := a.testDBFactory(server, name)
+	if err != nil {
+		return err
+	}
+
+	force, err := factory.supportsDropForce(ctx)
+	if err != nil {
+		return a.serverError(factory, err)
+	}
+
+	if err := factory.rootDB.dropDatabase(ctx, name, force); err != nil {
+		return a.serverError(factory, fmt.Errorf("drop %s: %w", name, err))
+	}
+
+	return nil
+}
+
+// Psql returns a command which runs psql connected to the named test database
+// on the named server, such as one kept for a failed test. The server can be
+// left empty if there is only one. The password, if any, is passed to psql
+// through PGPASSWORD.
+func (a *Admin) Psql(ctx context.Context, server, name string) (*exec.Cmd, error) {
+	factory, err := a.testDBFactory(server, name)
+	if err != nil {
+		return nil, err
+	}
+
+	args, env := factory.paramFactory(name).PsqlArgs()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd, nil
+}
+
+// testDBFactory returns the factory for the named server, checking that name
+// is the name of a test database.
+func (a *Admin) testDBFactory(server, name string) (*testDBFactory, error) {
+	var factory *testDBFactory
+	switch {
+	case server == "" && len(a.factories) == 1:
+		factory = a.factories[0]
+	case server == "":
+		return nil, errors.New("a server must be specified when there are multiple servers")
+	default:
+		for _, f := range a.factories {
+			if f.server == server {
+				factory = f
+				break
+			}
+		}
+		if factory == nil {
+			return nil, fmt.Errorf("unknown server %q", server)
+		}
+	}
+
+	// Other databases on the server are never touched.
+	if !factory.naming.isTestDBName(name) {
+		return nil, fmt.Errorf("%q isn't a test database, since it doesn't start with %q", name, factory.naming.namePrefix())
+	}
+
+	return factory, nil
+}
+
+// supportsDropForce returns whether the server supports DROP DATABASE ... WITH
+// (FORCE).
+func (s *testDBFactory) supportsDropForce(ctx context.Context) (bool, error) {
+	v, err := getServerVersion(ctx, s.rootDB.db)
+	if err != nil {
+		return false, fmt.Errorf("get server version: %w", err)
+	}
+
+	return v.num >= dropForceMinServerVersionNum, nil
+}
+
+// serverError adds the server to err if there are multiple servers.
+func (a *Admin) serverError(factory *testDBFactory, err error) error {
+	if len(a.factories) == 1 {
+		return err
+	}
+
+	return fmt.Errorf("server %s: %w", factory.server, err)
+}
@@ -0,0 +1,279 @@
+package pgtest
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+const getDatabaseInfoQuery = `SELECT datname, pg_get_userbyid(datdba)`
+
+// newMockAdmin returns an Admin for a mock server named "a".
+func newMockAdmin(t *testing.T) (*Admin, pgxmock.PgxPoolIface) {
+	t.Helper()
+
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+
+	admin := &Admin{factories: []*testDBFactory{{
+		server: "a",
+		rootDB: &rootDB{db: mockPool},
+		rng:    rand.New(new(sequentialRandSource)),
+	}}}
+	t.Cleanup(func() {
+		admin.Close()
+
+		if err := mockPool.ExpectationsWereMet(); err != nil {
+			t.Errorf("mock pool has unfulfilled expectations: %s", err)
+		}
+	})
+
+	return admin, mockPool
+}
+
+func expectServerVersion(mockPool pgxmock.PgxPoolIface, num int) {
+	mockPool.
+		ExpectQuery(regexp.QuoteMeta(`SELECT current_setting('server_version'), current_setting('server_version_num')::int;`)).
+		WillReturnRows(pgxmock.NewRows([]string{"server_version", "server_version_num"}).AddRow("x", num))
+}
+
+func mustAnnotationComment(t *testing.T, a Annotation) string {
+	t.Helper()
+
+	comment, err := a.comment()
+	if err != nil {
+		t.Fatalf("unexpected error from annotation.comment(): %s", err)
+	}
+	return comment
+}
+
+func TestAdminList(t *testing.T) {
+	admin, mockPool := newMockAdmin(t)
+
+	annotation := Annotation{Test: "TestFoo", PID: 1234, Failed: true, Kept: true}
+	modified := time.Date(2026, time.October, 18, 15, 0, 0, 0, time.UTC)
+	mockPool.
+		ExpectQuery(regexp.QuoteMeta(getDatabaseInfoQuery)).
+		WithArgs("pg_test_").
+		WillReturnRows(pgxmock.NewRows([]string{"datname", "owner", "size", "comment", "modified"}).
+			AddRow("pg_test_1", "foo", int64(8192), mustAnnotationComment(t, annotation), &modified).
+			AddRow("pg_test_2", "foo", int64(-1), "", (*time.Time)(nil)),
+		).
+		RowsWillBeClosed()
+
+	testDBs, err := admin.List(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from admin.List: %s", err)
+	}
+
+	expected := []TestDBInfo{
+		{
+			AnnotatedTestDB: AnnotatedTestDB{Server: "a", Name: "pg_test_1", Annotation: &annotation},
+			Owner:           "foo",
+			Size:            8192,
+			Modified:        &modified,
+		},
+		{
+			AnnotatedTestDB: AnnotatedTestDB{Server: "a", Name: "pg_test_2"},
+			Owner:           "foo",
+			Size:            -1,
+		},
+	}
+	if diff := cmp.Diff(expected, testDBs); diff != "" {
+		t.Errorf("admin.List returned unexpected test dbs (-want +got):\n%s", diff)
+	}
+}
+
+func TestPruneFilterMatches(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 15, 0, 0, 0, time.UTC)
+
+	var (
+		unannotated = TestDBInfo{AnnotatedTestDB: AnnotatedTestDB{Name: "pg_test_1"}}
+		recent      = TestDBInfo{AnnotatedTestDB: AnnotatedTestDB{
+			Name:       "pg_test_store_testfoo_1",
+			Annotation: &Annotation{AcquiredAt: now.Add(-time.Minute)},
+		}}
+		oldKept = TestDBInfo{AnnotatedTestDB: AnnotatedTestDB{
+			Name:       "pg_test_users_testbar_1",
+			Annotation: &Annotation{AcquiredAt: now.Add(-2 * time.Hour), Failed: true, Kept: true},
+		}}
+		modified    = now.Add(-2 * time.Hour)
+		oldModified = TestDBInfo{
+			AnnotatedTestDB: AnnotatedTestDB{Name: "pg_test_2"},
+			Modified:        &modified,
+		}
+		oldNamed = TestDBInfo{AnnotatedTestDB: AnnotatedTestDB{
+			Name: DescriptiveDBName(DBNameHint{Prefix: "pg_test_", Package: "orders", Time: now.Add(-2 * time.Hour).Local(), Random: 1}),
+		}}
+		all = []TestDBInfo{unannotated, recent, oldKept, oldModified, oldNamed}
+	)
+
+	testCases := map[string]struct {
+		filter   PruneFilter
+		expected []TestDBInfo
+	}{
+		"everything": {
+			expected: all,
+		},
+		"older_than": {
+			filter:   PruneFilter{OlderThan: time.Hour},
+			expected: []TestDBInfo{oldKept, oldModified, oldNamed},
+		},
+		"prefix": {
+			filter:   PruneFilter{Prefix: "pg_test_store_"},
+			expected: []TestDBInfo{recent},
+		},
+		"kept_only": {
+			filter:   PruneFilter{Kept: KeptOnly},
+			expected: []TestDBInfo{oldKept},
+		},
+		"not_kept": {
+			filter:   PruneFilter{Kept: NotKept},
+			expected: []TestDBInfo{unannotated, recent, oldModified, oldNamed},
+		},
+		"combined": {
+			filter:   PruneFilter{OlderThan: time.Hour, Kept: NotKept},
+			expected: []TestDBInfo{oldModified, oldNamed},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			var matched []TestDBInfo
+			for _, testDB := range all {
+				if testCase.filter.matches(testDB, now) {
+					matched = append(matched, testDB)
+				}
+			}
+
+			if diff := cmp.Diff(testCase.expected, matched); diff != "" {
+				t.Errorf("unexpected test dbs matched by %+v (-want +got):\n%s", testCase.filter, diff)
+			}
+		})
+	}
+}
+
+func TestAdminPrune(t *testing.T) {
+	testCases := map[string]struct {
+		dryRun bool
+	}{
+		"drop": {},
+		"dry_run": {
+			dryRun: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			admin, mockPool := newMockAdmin(t)
+
+			kept := Annotation{Test: "TestFoo", Failed: true, Kept: true}
+			mockPool.
+				ExpectQuery(regexp.QuoteMeta(getDatabaseInfoQuery)).
+				WithArgs("pg_test_").
+				WillReturnRows(pgxmock.NewRows([]string{"datname", "owner", "size", "comment", "modified"}).
+					AddRow("pg_test_1", "foo", int64(8192), mustAnnotationComment(t, kept), (*time.Time)(nil)).
+					AddRow("pg_test_2", "foo", int64(8192), "", (*time.Time)(nil)),
+				).
+				RowsWillBeClosed()
+
+			if !testCase.dryRun {
+				expectServerVersion(mockPool, 150000)
+				mockPool.
+					ExpectExec(regexp.QuoteMeta(`DROP DATABASE "pg_test_1" WITH (FORCE);`)).
+					WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+			}
+
+			pruned, err := admin.Prune(context.Background(), PruneFilter{Kept: KeptOnly}, testCase.dryRun)
+			if err != nil {
+				t.Fatalf("unexpected error from admin.Prune: %s", err)
+			}
+
+			if len(pruned) != 1 || pruned[0].Name != "pg_test_1" {
+				t.Errorf("admin.Prune returned %+v; want just pg_test_1", pruned)
+			}
+		})
+	}
+}
+
+func TestAdminDrop(t *testing.T) {
+	testCases := map[string]struct {
+		server        string
+		name          string
+		expectDrop    string
+		expectedError string
+	}{
+		"default_server": {
+			name:       "pg_test_1",
+			expectDrop: `DROP DATABASE "pg_test_1";`,
+		},
+		"named_server": {
+			server:     "a",
+			name:       "pg_test_1",
+			expectDrop: `DROP DATABASE "pg_test_1";`,
+		},
+		"unknown_server": {
+			server:        "b",
+			name:          "pg_test_1",
+			expectedError: `unknown server "b"`,
+		},
+		"not_test_db": {
+			name:          "app",
+			expectedError: `"app" isn't a test database`,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			admin, mockPool := newMockAdmin(t)
+
+			if testCase.expectDrop != "" {
+				expectServerVersion(mockPool, 120000)
+				mockPool.
+					ExpectExec(regexp.QuoteMeta(testCase.expectDrop)).
+					WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+			}
+
+			err := admin.Drop(context.Background(), testCase.server, testCase.name)
+			if testCase.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error from admin.Drop: %s", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), testCase.expectedError) {
+				t.Fatalf("admin.Drop() = %v; want error containing %q", err, testCase.expectedError)
+			}
+		})
+	}
+}
+
+func TestAdminPsql(t *testing.T) {
+	admin, _ := newMockAdmin(t)
+	admin.factories[0].paramFactory = func(dbName string) connparams.ConnectionParams {
+		return connparams.New(dbName, connparams.WithHost("localhost"), connparams.WithPassword("s3cr3t"))
+	}
+
+	cmd, err := admin.Psql(context.Background(), "", "pg_test_1")
+	if err != nil {
+		t.Fatalf("unexpected error from admin.Psql: %s", err)
+	}
+
+	if diff := cmp.Diff([]string{"psql", "dbname=pg_test_1 host=localhost"}, cmd.Args); diff != "" {
+		t.Errorf("unexpected psql args (-want +got):\n%s", diff)
+	}
+	if !slices.Contains(cmd.Env, "PGPASSWORD=s3cr3t") {
+		t.Errorf("psql env doesn't contain the password")
+	}
+}
@@ -0,0 +1,201 @@
+package pgtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// An Annotation describes the test which is using, or last used, a test
+// database. With WithAnnotations, it is written as JSON to the comment on the
+// test database (see COMMENT ON DATABASE), so test databases can be identified
+// after the fact, such as ones kept by KeepDatabasesForFailed.
+type Annotation struct {
+	// Test is the name of the test.
+	Test string `json:"test"`
+
+	// Package is the name of the test binary's package, if known.
+	Package string `json:"package,omitempty"`
+
+	// PID is the process ID of the test binary.
+	PID int `json:"pid"`
+
+	// Commit is the git commit being tested, if known.
+	Commit string `json:"commit,omitempty"`
+
+	// AcquiredAt is when the test got the test database.
+	AcquiredAt time.Time `json:"acquired_at"`
+
+	// Failed is whether the test failed.
+	Failed bool `json:"failed"`
+
+	// Kept is whether the test database was kept for the failed test by
+	// KeepDatabasesForFailed, rather than re-used.
+	Kept bool `json:"kept"`
+}
+
+// annotationComment is the JSON written to the comment on a test database,
+// which nests the Annotation so it can be told apart from other comments.
+type annotationComment struct {
+	Pgtest *Annotation `json:"pgtest"`
+}
+
+// comment returns the comment on a test database for a.
+func (a Annotation) comment() (string, error) {
+	b, err := json.Marshal(annotationComment{Pgtest: &a})
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// parseAnnotation parses the comment on a database. It returns nil if the
+// comment isn't an Annotation, such as if it is empty.
+func parseAnnotation(comment string) *Annotation {
+	var c annotationComment
+	if err := json.Unmarshal([]byte(comment), &c); err != nil {
+		return nil
+	}
+
+	return c.Pgtest
+}
+
+// annotateTestDB writes a to the comment on db.
+func annotateTestDB(ctx context.Context, db TestDB, a Annotation) error {
+	comment, err := a.comment()
+	if err != nil {
+		return fmt.Errorf("marshal annotation: %w", err)
+	}
+
+	return withTestDBConn(ctx, db, func(conn *pgx.Conn) error {
+		return commentOnDatabase(ctx, conn, db.Name(), comment)
+	})
+}
+
+// newAnnotation returns the Annotation for a test which just got a test
+// database.
+func newAnnotation(testName string) Annotation {
+	return Annotation{
+		Test:       testName,
+		Package:    testBinaryPackage(),
+		PID:        os.Getpid(),
+		Commit:     gitCommit(),
+		AcquiredAt: time.Now().UTC(),
+	}
+}
+
+// gitCommit returns the git commit being tested, if it can be found. Test
+// binaries don't have the commit in their build info, so it is also taken from
+// the environment variables set by common CI systems, or from git itself.
+var gitCommit = sync.OnceValue(func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+
+	for _, env := range []string{"PGTEST_GIT_COMMIT", "GITHUB_SHA", "CI_COMMIT_SHA", "GIT_COMMIT"} {
+		if commit := os.Getenv(env); commit != "" {
+			return commit
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+})
+
+// An AnnotatedTestDB is a test database along with its Annotation.
+type AnnotatedTestDB struct {
+	// Server is the name of the server the test database is on.
+	Server string `json:"server"`
+
+	// Name is the name of the test database.
+	Name string `json:"name"`
+
+	// Annotation describes the test which is using, or last used, the test
+	// database. It is nil if the test database wasn't annotated, such as
+	// if it was created without WithAnnotations.
+	Annotation *Annotation `json:"annotation,omitempty"`
+}
+
+// ReadAnnotations returns the test databases on each server, along with their
+// annotations. It is configured the same way as NewSupervisor, including the
+// test db name prefix, although it doesn't wait for the servers to be ready.
+func ReadAnnotations(ctx context.Context, opts ...Option) ([]AnnotatedTestDB, error) {
+	conf, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var testDBs []AnnotatedTestDB
+	for _, server := range conf.servers {
+		serverTestDBs, err := readServerAnnotations(ctx, server, conf.naming)
+		if err != nil {
+			if len(conf.servers) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("server %s: %w", server.name, err)
+		}
+
+		testDBs = append(testDBs, serverTestDBs...)
+	}
+
+	return testDBs, nil
+}
+
+func readServerAnnotations(ctx context.Context, server serverConfig, naming dbNaming) ([]AnnotatedTestDB, error) {
+	rootDBParams := server.paramFactory(defaultRootDBName)
+	if err := rootDBParams.Validate(); err != nil {
+		return nil, err
+	}
+
+	factory, err := openTestDBFactory(ctx, server.name, rootDBParams.URI().String(), server.paramFactory, naming)
+	if err != nil {
+		return nil, err
+	}
+	defer factory.close()
+
+	return factory.annotatedTestDBs(ctx)
+}
+
+// annotatedTestDBs returns the test databases on the server, along with their
+// annotations.
+func (s *testDBFactory) annotatedTestDBs(ctx context.Context) ([]AnnotatedTestDB, error) {
+	comments, err := getAllDatabaseComments(ctx, s.rootDB.db)
+	if err != nil {
+		return nil, fmt.Errorf("get database comments: %w", err)
+	}
+
+	var testDBs []AnnotatedTestDB
+	for _, c := range comments {
+		if !s.naming.isTestDBName(c.name) {
+			continue
+		}
+
+		testDBs = append(testDBs, AnnotatedTestDB{
+			Server:     s.server,
+			Name:       c.name,
+			Annotation: parseAnnotation(c.comment),
+		})
+	}
+
+	return testDBs, nil
+}
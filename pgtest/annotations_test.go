@@ -0,0 +1,127 @@
+package pgtest
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestAnnotationComment(t *testing.T) {
+	annotation := Annotation{
+		Test:       "TestFoo/it's",
+		Package:    "store",
+		PID:        1234,
+		Commit:     "0123456789abcdef",
+		AcquiredAt: time.Date(2026, time.October, 18, 15, 4, 5, 0, time.UTC),
+		Failed:     true,
+		Kept:       true,
+	}
+
+	comment, err := annotation.comment()
+	if err != nil {
+		t.Fatalf("unexpected error from annotation.comment(): %s", err)
+	}
+
+	expected := `{"pgtest":{"test":"TestFoo/it's","package":"store","pid":1234,"commit":"0123456789abcdef","acquired_at":"2026-10-18T15:04:05Z","failed":true,"kept":true}}`
+	if comment != expected {
+		t.Errorf("annotation.comment() = %s; want %s", comment, expected)
+	}
+
+	if diff := cmp.Diff(&annotation, parseAnnotation(comment)); diff != "" {
+		t.Errorf("parseAnnotation(comment) returned unexpected annotation (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseAnnotationNotAnnotation(t *testing.T) {
+	testCases := map[string]string{
+		"empty":      "",
+		"plain_text": "the production database, do not drop",
+		"other_json": `{"owner":"someone"}`,
+	}
+
+	for testName, comment := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if annotation := parseAnnotation(comment); annotation != nil {
+				t.Errorf("parseAnnotation(%q) = %+v; want nil", comment, annotation)
+			}
+		})
+	}
+}
+
+func TestCommentOnDatabase(t *testing.T) {
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+	defer mockPool.Close()
+
+	mockPool.
+		ExpectExec(regexp.QuoteMeta(`COMMENT ON DATABASE "pg_test_1" IS E'{"test":"TestFoo/it''s \\"quoted\\""}';`)).
+		WillReturnResult(pgxmock.NewResult("COMMENT", 0))
+
+	if err := commentOnDatabase(context.Background(), mockPool, "pg_test_1", `{"test":"TestFoo/it's \"quoted\""}`); err != nil {
+		t.Fatalf("unexpected error from commentOnDatabase: %s", err)
+	}
+
+	if err := mockPool.ExpectationsWereMet(); err != nil {
+		t.Errorf("mock pool has unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBFactoryAnnotatedTestDBs(t *testing.T) {
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+
+	factory := &testDBFactory{
+		server: "a",
+		rootDB: &rootDB{db: mockPool},
+		rng:    rand.New(new(sequentialRandSource)),
+	}
+	defer factory.close()
+
+	annotation := Annotation{
+		Test:       "TestFoo",
+		PID:        1234,
+		AcquiredAt: time.Date(2026, time.October, 18, 15, 4, 5, 0, time.UTC),
+		Failed:     true,
+		Kept:       true,
+	}
+	comment, err := annotation.comment()
+	if err != nil {
+		t.Fatalf("unexpected error from annotation.comment(): %s", err)
+	}
+
+	mockPool.
+		ExpectQuery(regexp.QuoteMeta(`SELECT datname, coalesce(shobj_description(oid, 'pg_database'), '') FROM pg_database ORDER BY datname;`)).
+		WillReturnRows(pgxmock.NewRows([]string{"datname", "comment"}).
+			AddRow("app", "").
+			AddRow("pg_test_1", comment).
+			AddRow("pg_test_2", "").
+			AddRow("postgres", "default administrative connection database"),
+		).
+		RowsWillBeClosed()
+
+	testDBs, err := factory.annotatedTestDBs(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from factory.annotatedTestDBs: %s", err)
+	}
+
+	expected := []AnnotatedTestDB{
+		{Server: "a", Name: "pg_test_1", Annotation: &annotation},
+		{Server: "a", Name: "pg_test_2"},
+	}
+	if diff := cmp.Diff(expected, testDBs); diff != "" {
+		t.Errorf("factory.annotatedTestDBs returned unexpected test dbs (-want +got):\n%s", diff)
+	}
+
+	if err := mockPool.ExpectationsWereMet(); err != nil {
+		t.Errorf("mock pool has unfulfilled expectations: %s", err)
+	}
+}
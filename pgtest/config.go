@@ -1,6 +1,10 @@
 package pgtest
 
-import "github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+import (
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+)
 
 // connparamsFactory is used to create the connection parameters for a
 // particular database name. This is to allow us to get common information for
@@ -34,5 +38,79 @@ type config struct {
 	// or if the supervisor didn't shutdown correctly).
 	//keepExistingTestDBs bool
 
+	// managedServers are servers whose lifetime is tied to the
+	// supervisor, and which are stopped when it is shutdown.
+	managedServers []ManagedServer
+
+	// connParamOpts are the options used to construct the connection
+	// params, from both the environment and WithConnParams.
+	connParamOpts []connparams.Option
+
+	// useCoordinator gets test databases from a coordinator process
+	// shared between test binaries, rather than a pool owned by this one.
+	useCoordinator bool
+
+	// coordinatorIdleTimeout is how long the coordinator waits without any
+	// connected test binaries before exiting.
+	coordinatorIdleTimeout time.Duration
+
+	// hooks are run at each stage in the life of a test database.
+	hooks hooks
+
+	// events logs lifecycle events to the logger specified by WithLogger.
+	events *eventLogger
+
+	// idle limits the idle test databases kept by the pool.
+	idle idleConfig
+
+	// naming describes how test databases are named.
+	naming dbNaming
+
+	// annotate writes an Annotation to the comment on each test database
+	// when a test gets it, and again if the test fails.
+	annotate bool
+
+	// testAffinity prefers handing each test the test database last used
+	// by the same test, or failing that the same top-level test.
+	testAffinity bool
+
+	// useReaper starts a reaper process which drops the test databases if
+	// the test binary dies without shutting down the supervisor.
+	useReaper bool
+
+	// ready describes how to wait for each server to be ready.
+	ready readyConfig
+
+	// serverOpts are the servers specified through WithServers.
+	serverOpts []Server
+
+	// placement decides which server new test databases are created on.
+	placement Placement
+
+	// paramFactory is used for the default server, which is used if
+	// WithServers isn't specified.
 	paramFactory connparamsFactory
+
+	// servers are the servers test databases are created on.
+	servers []serverConfig
+}
+
+// idleConfig describes when idle test databases are dropped, rather than
+// being kept for future tests until the supervisor is shutdown.
+type idleConfig struct {
+	// timeout is how long a test database can be idle before it is
+	// dropped. Zero means no timeout.
+	timeout time.Duration
+
+	// max is the most idle test databases to keep. Zero means no maximum.
+	max int
+}
+
+// runConfig describes the configuration for RunMain.
+type runConfig struct {
+	// statsSummary prints a summary of the supervisor's stats.
+	statsSummary bool
+
+	// statsFile is where to write the supervisor's stats as JSON, if set.
+	statsFile string
 }
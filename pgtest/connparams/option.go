@@ -1,5 +1,11 @@
 package connparams
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
 // Option is a parameter to configure the connection.
 type Option interface {
 	apply(*ConnectionParams)
@@ -9,6 +15,28 @@ type optionFunc func(*ConnectionParams)
 
 func (f optionFunc) apply(p *ConnectionParams) { f(p) }
 
+type sourcedOption struct {
+	source string
+	opts   []Option
+}
+
+func (o sourcedOption) apply(p *ConnectionParams) {
+	prevSource := p.curSource
+	p.curSource = o.source
+	defer func() { p.curSource = prevSource }()
+
+	for _, opt := range o.opts {
+		opt.apply(p)
+	}
+}
+
+// FromEnv returns a Option which applies the options, recording that the
+// parameters they set came from the named environment variable. This is
+// used to give more helpful errors from ConnectionParams.Validate.
+func FromEnv(name string, opts ...Option) Option {
+	return sourcedOption{source: "env var " + name, opts: opts}
+}
+
 // WithUser returns a Option specifying the name of the database to
 // connect to.
 func WithUser(user string) Option {
@@ -24,17 +52,35 @@ func WithPassword(password string) Option {
 	})
 }
 
-// WithHost returns a Option specifying Are for unix domain sockets.
+// WithHost returns a Option specifying the host to connect to. If the host
+// is an absolute path it is treated as the directory containing a unix domain
+// socket (e.g. "/var/run/postgresql").
 func WithHost(host string) Option {
+	return WithHosts(host)
+}
+
+// WithHosts returns a Option specifying multiple hosts to connect to. The
+// hosts are tried in order until a connection satisfying the
+// target_session_attrs is established. Like WithHost, any host that is an
+// absolute path is treated as a unix domain socket directory.
+func WithHosts(hosts ...string) Option {
 	return optionFunc(func(p *ConnectionParams) {
-		p.setHost(host)
+		p.setHosts(hosts)
 	})
 }
 
-// WithPort returns a Option specifying the port to bind to.
+// WithPort returns a Option specifying the port to bind to. If multiple hosts
+// are specified, the port is used for each of them.
 func WithPort(port int) Option {
+	return WithPorts(port)
+}
+
+// WithPorts returns a Option specifying the port for each of the hosts. The
+// number of ports should either be one, in which case it is used for every
+// host, or equal to the number of hosts.
+func WithPorts(ports ...int) Option {
 	return optionFunc(func(p *ConnectionParams) {
-		p.setPort(port)
+		p.setPorts(ports)
 	})
 }
 
@@ -47,6 +93,10 @@ func WithSSLMode(sslMode string) Option {
 
 // WithFallbackApplicationName returns a Option specifying an
 // application_name to fall back to if one isn't provided.
+//
+// NOTE: this is only understood by libpq. pgx passes it through to the server
+// as a run-time parameter, which the server rejects, so WithApplicationName
+// should be preferred when connecting with pgx.
 func WithFallbackApplicationName(fallbackApplicationName string) Option {
 	return optionFunc(func(p *ConnectionParams) {
 		p.setFallbackApplicationName(fallbackApplicationName)
@@ -54,7 +104,7 @@ func WithFallbackApplicationName(fallbackApplicationName string) Option {
 }
 
 // WithConnectionTimeout returns a Option specifying maximum wait for
-// connection, in seconds. Zero or not specified means wait indefinitely.
+// connection (the connect_timeout keyword), in seconds. Zero or not specified means wait indefinitely.
 func WithConnectionTimeout(connectionTimeout int) Option {
 	return optionFunc(func(p *ConnectionParams) {
 		p.setConnectionTimeout(connectionTimeout)
@@ -92,3 +142,60 @@ func WithService(service string) Option {
 		p.setService(service)
 	})
 }
+
+// WithTargetSessionAttrs returns a Option specifying which of the hosts is
+// acceptable to connect to when multiple are specified. This should be one
+// of the TargetSessionAttrs* constants.
+func WithTargetSessionAttrs(targetSessionAttrs string) Option {
+	return optionFunc(func(p *ConnectionParams) {
+		p.setTargetSessionAttrs(targetSessionAttrs)
+	})
+}
+
+// WithApplicationName returns a Option specifying the application_name to
+// report to the server, which is visible in pg_stat_activity.
+func WithApplicationName(applicationName string) Option {
+	return optionFunc(func(p *ConnectionParams) {
+		p.setApplicationName(applicationName)
+	})
+}
+
+// WithOptions returns a Option specifying raw command-line options to send to
+// the server at connection start (e.g. "-c geqo=off"). Any runtime params are
+// appended to these options.
+func WithOptions(options string) Option {
+	return optionFunc(func(p *ConnectionParams) {
+		p.setOptions(options)
+	})
+}
+
+// WithRuntimeParam returns a Option specifying a server run-time parameter to
+// set for the session (e.g. "search_path"), equivalent to passing
+// '-c name=value' in the options.
+func WithRuntimeParam(name, value string) Option {
+	return optionFunc(func(p *ConnectionParams) {
+		p.setRuntimeParam(name, value)
+	})
+}
+
+// WithRuntimeParams returns a Option specifying multiple server run-time
+// parameters to set for the session.
+func WithRuntimeParams(params map[string]string) Option {
+	return optionFunc(func(p *ConnectionParams) {
+		for name, value := range params {
+			p.setRuntimeParam(name, value)
+		}
+	})
+}
+
+// WithSearchPath returns a Option specifying the search_path for the session.
+func WithSearchPath(schemas ...string) Option {
+	return WithRuntimeParam("search_path", strings.Join(schemas, ","))
+}
+
+// WithStatementTimeout returns a Option specifying the statement_timeout for
+// the session. The timeout is rounded down to the nearest millisecond, and
+// zero disables the timeout.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return WithRuntimeParam("statement_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+}
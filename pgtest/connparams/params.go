@@ -1,9 +1,10 @@
 package connparams
 
 import (
-	"net/url"
+	"maps"
+	"math/bits"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
 )
 
@@ -12,6 +13,14 @@ const (
 	// No SSL.
 	SSLModeDisable = "disable"
 
+	// First try a non-SSL connection; if that fails, try an SSL
+	// connection.
+	SSLModeAllow = "allow"
+
+	// First try an SSL connection; if that fails, try a non-SSL
+	// connection.
+	SSLModePrefer = "prefer"
+
 	// Always SSL (skip verification).
 	SSLModeRequire = "require"
 
@@ -25,6 +34,29 @@ const (
 	SSLModeVerifyFull = "verify-full"
 )
 
+// The possible values of target_session_attrs, which determine which of the
+// listed hosts is acceptable to connect to when multiple hosts are specified.
+const (
+	// Any successful connection is acceptable.
+	TargetSessionAttrsAny = "any"
+
+	// The session must accept read-write transactions by default.
+	TargetSessionAttrsReadWrite = "read-write"
+
+	// The session must not accept read-write transactions by default.
+	TargetSessionAttrsReadOnly = "read-only"
+
+	// The server must not be in hot standby mode.
+	TargetSessionAttrsPrimary = "primary"
+
+	// The server must be in hot standby mode.
+	TargetSessionAttrsStandby = "standby"
+
+	// First try to find a standby server, but if none of the listed hosts
+	// is a standby server, try again in any mode.
+	TargetSessionAttrsPreferStandby = "prefer-standby"
+)
+
 const (
 	defaultHost    = "localhost"
 	defaultPort    = 5432
@@ -43,14 +75,21 @@ const (
 	sslKeySet
 	sslRootCertSet
 	serviceSet
+	targetSessionAttrsSet
+	applicationNameSet
+	optionsSet
+
+	// numFields is the number of fields tracked by the set bit flags, so
+	// it must stay last.
+	numFields = iota
 )
 
 type ConnectionParams struct {
 	dbName                  string
 	user                    string
 	password                string
-	host                    string
-	port                    int
+	hosts                   []string
+	ports                   []int
 	sslMode                 string
 	fallbackApplicationName string
 	connectionTimeout       int
@@ -58,8 +97,26 @@ type ConnectionParams struct {
 	sslKey                  string
 	sslRootCert             string
 	service                 string
+	targetSessionAttrs      string
+	applicationName         string
+	options                 string
+
+	// runtimeParams are server run-time parameters (e.g. search_path)
+	// which are set for the session when connecting. These are rendered
+	// as part of the 'options' keyword, since libpq doesn't accept them as
+	// keywords of their own.
+	runtimeParams map[string]string
 
 	set uint
+
+	// sources describes where each of the fields was set, indexed by the
+	// position of the field's set bit. An empty source means the field
+	// was set by an option.
+	sources [numFields]string
+
+	// curSource is the source of any fields set by the options currently
+	// being applied.
+	curSource string
 }
 
 // New constructs a new ConnectionParams for the specified dbName.
@@ -76,9 +133,9 @@ type Factory func(dbName string, opts ...Option) ConnectionParams
 
 func DefaultFactory() Factory {
 	return func(dbName string, opts ...Option) ConnectionParams {
-		var p ConnectionParams
-		p.setHost(defaultHost)
-		p.setPort(defaultPort)
+		p := ConnectionParams{curSource: "default"}
+		p.setHosts([]string{defaultHost})
+		p.setPorts([]int{defaultPort})
 		p.setSSLMode(defaultSSLMode)
 
 		if u := os.Getenv("USER"); u != "" {
@@ -86,6 +143,7 @@ func DefaultFactory() Factory {
 		}
 
 		p.dbName = dbName
+		p.curSource = ""
 		for _, opt := range opts {
 			opt.apply(&p)
 		}
@@ -104,182 +162,28 @@ func (p ConnectionParams) DBName() string {
 	return p.dbName
 }
 
-func (p ConnectionParams) URI() *url.URL {
-	uri := &url.URL{
-		Scheme: "postgres",
-		Path:   p.DBName(),
-	}
-
-	if (p.set & hostSet) != 0 {
-		uri.Host = p.host
-		if (p.set & portSet) != 0 {
-			uri.Host = uri.Host + ":" + strconv.Itoa(p.port)
-		}
-	}
-
-	if (p.set & userSet) != 0 {
-		if (p.set & passwordSet) != 0 {
-			uri.User = url.UserPassword(p.user, p.password)
-		} else {
-			uri.User = url.User(p.user)
-		}
-	}
-
-	q := url.Values{}
-
-	// NOTE: I'm assuming there will be an error actually connecting here,
-	// but since postgres allows us to specify heirarchical uri params in
-	// the query we should at least attempt.
-	if (p.set&portSet) != 0 && (p.set&hostSet) == 0 {
-		q.Set("port", strconv.Itoa(p.port))
-	}
-
-	if (p.set & sslModeSet) != 0 {
-		q.Set("sslmode", p.sslMode)
-	}
-
-	if (p.set & fallbackApplicationNameSet) != 0 {
-		q.Set("fallback_application_name", p.fallbackApplicationName)
-	}
-
-	if (p.set & connectionTimeoutSet) != 0 {
-		q.Set("connection_timeout", strconv.Itoa(p.connectionTimeout))
-	}
-
-	if (p.set & sslCertSet) != 0 {
-		q.Set("sslcert", p.sslCert)
-	}
-
-	if (p.set & sslKeySet) != 0 {
-		q.Set("sslkey", p.sslKey)
-	}
-
-	if (p.set & sslRootCertSet) != 0 {
-		q.Set("sslrootcert", p.sslRootCert)
-	}
-
-	if (p.set & serviceSet) != 0 {
-		q.Set("service", p.service)
-	}
-
-	uri.RawQuery = q.Encode()
-
-	return uri
-}
-
-type KeyValue struct {
-	Key   string
-	Value string
-}
-
-func (kv KeyValue) String() string {
-	return kv.Key + "=" + kv.Value
-}
-
-func normalizeValue(v string) string {
-	if v == "" {
-		return `''`
-	}
-
-	var (
-		b        strings.Builder
-		hasSpace bool
-	)
-
-	b.Grow(len(v))
-
-	for _, r := range v {
-		switch r {
-		case '\\', '\'':
-			b.WriteRune('\\')
-		case ' ':
-			hasSpace = true
-		default:
-		}
-		b.WriteRune(r)
-	}
-
-	if hasSpace {
-		return "'" + b.String() + "'"
-	}
-
-	return b.String()
-}
-
-func keyValue(k, v string) KeyValue {
-	return KeyValue{Key: k, Value: v}
-}
-
-type KeyValues []KeyValue
-
-func (kvs KeyValues) String() string {
-	parts := make([]string, len(kvs))
-	for i, kv := range kvs {
-		parts[i] = kv.String()
+// With returns a copy of the connection params with the additional options
+// applied.
+func (p ConnectionParams) With(opts ...Option) ConnectionParams {
+	for _, opt := range opts {
+		opt.apply(&p)
 	}
 
-	return strings.Join(parts, " ")
+	return p
 }
 
-func (p ConnectionParams) KeyValues() KeyValues {
-	kvs := []KeyValue{{
-		Key:   "dbname",
-		Value: normalizeValue(p.dbName),
-	}}
-
-	if host, ok := p.getHost(); ok {
-		kvs = append(kvs, keyValue("host", normalizeValue(host)))
-	}
-
-	if port, ok := p.getPort(); ok {
-		kvs = append(kvs, keyValue("port", strconv.Itoa(port)))
-	}
-
-	if u, ok := p.getUser(); ok {
-		kvs = append(kvs, keyValue("user", normalizeValue(u)))
-	}
-
-	if pass, ok := p.getPassword(); ok {
-		kvs = append(kvs, keyValue("password", normalizeValue(pass)))
-	}
-
-	if mode, ok := p.getSSLMode(); ok {
-		kvs = append(kvs, keyValue("sslmode", normalizeValue(mode)))
-	}
-
-	if n, ok := p.getFallbackApplicationName(); ok {
-		kvs = append(kvs, keyValue("fallbackApplicationName", normalizeValue(n)))
-	}
-
-	if t, ok := p.getConnectionTimeout(); ok {
-		kvs = append(kvs, keyValue("connection_timeout", strconv.Itoa(t)))
-	}
-
-	if c, ok := p.getSSLCert(); ok {
-		kvs = append(kvs, keyValue("sslcert", normalizeValue(c)))
-	}
-
-	if k, ok := p.getSSLKey(); ok {
-		kvs = append(kvs, keyValue("sslkey", normalizeValue(k)))
-	}
-
-	if c, ok := p.getSSLRootCert(); ok {
-		kvs = append(kvs, keyValue("sslrootcert", normalizeValue(c)))
-	}
-
-	if s, ok := p.getService(); ok {
-		kvs = append(kvs, keyValue("service", normalizeValue(s)))
-	}
-
-	return kvs
+// RuntimeParams returns a copy of the server run-time parameters which are
+// set when connecting.
+func (p ConnectionParams) RuntimeParams() map[string]string {
+	return maps.Clone(p.runtimeParams)
 }
 
-// String returns a string representation of the connection params. Although
-// this can be used as a connection string, it's main purpose is debugging
-// and testing. Either 'p.URI().String()' or 'p.KeyValues().String()' should
-// be used to construct the connection string.
+// String returns a string representation of the connection params, with the
+// password redacted. As a result, this is intended for debugging and logging
+// rather than as a connection string. Either 'p.URI().String()' or
+// 'p.KeyValues().String()' should be used to construct the connection string.
 func (p ConnectionParams) String() string {
-	return p.KeyValues().String()
+	return p.Redacted().KeyValues().String()
 }
 
 func (p ConnectionParams) Equal(other ConnectionParams) bool {
@@ -295,11 +199,11 @@ func (p ConnectionParams) Equal(other ConnectionParams) bool {
 		return false
 	}
 
-	if x, ok := p.getHost(); ok && !other.hasHostEqual(x) {
+	if x, ok := p.getHosts(); ok && !other.hasHostsEqual(x) {
 		return false
 	}
 
-	if x, ok := p.getPort(); ok && !other.hasPortEqual(x) {
+	if x, ok := p.getPorts(); ok && !other.hasPortsEqual(x) {
 		return false
 	}
 
@@ -331,62 +235,119 @@ func (p ConnectionParams) Equal(other ConnectionParams) bool {
 		return false
 	}
 
+	if x, ok := p.getTargetSessionAttrs(); ok && !other.hasTargetSessionAttrsEqual(x) {
+		return false
+	}
+
+	if x, ok := p.getApplicationName(); ok && !other.hasApplicationNameEqual(x) {
+		return false
+	}
+
+	if p.options != other.options {
+		return false
+	}
+
+	if !maps.Equal(p.runtimeParams, other.runtimeParams) {
+		return false
+	}
+
 	return true
 }
 
+func (p *ConnectionParams) markSet(field uint) {
+	p.set |= field
+	p.sources[bits.TrailingZeros(field)] = p.curSource
+}
+
+// source returns where the field was set.
+func (p ConnectionParams) source(field uint) string {
+	if src := p.sources[bits.TrailingZeros(field)]; src != "" {
+		return src
+	}
+
+	return "option"
+}
+
 func (p *ConnectionParams) setUser(user string) {
 	p.user = user
-	p.set |= userSet
+	p.markSet(userSet)
 }
 
 func (p *ConnectionParams) setPassword(password string) {
 	p.password = password
-	p.set |= passwordSet
+	p.markSet(passwordSet)
 }
 
-func (p *ConnectionParams) setHost(host string) {
-	p.host = host
-	p.set |= hostSet
+func (p *ConnectionParams) setHosts(hosts []string) {
+	p.hosts = slices.Clone(hosts)
+	p.markSet(hostSet)
 }
 
-func (p *ConnectionParams) setPort(port int) {
-	p.port = port
-	p.set |= portSet
+func (p *ConnectionParams) setPorts(ports []int) {
+	p.ports = slices.Clone(ports)
+	p.markSet(portSet)
 }
 
 func (p *ConnectionParams) setSSLMode(sslMode string) {
 	p.sslMode = sslMode
-	p.set |= sslModeSet
+	p.markSet(sslModeSet)
 }
 
 func (p *ConnectionParams) setFallbackApplicationName(fallbackApplicationName string) {
 	p.fallbackApplicationName = fallbackApplicationName
-	p.set |= fallbackApplicationNameSet
+	p.markSet(fallbackApplicationNameSet)
 }
 
 func (p *ConnectionParams) setConnectionTimeout(connectionTimeout int) {
 	p.connectionTimeout = connectionTimeout
-	p.set |= connectionTimeoutSet
+	p.markSet(connectionTimeoutSet)
 }
 
 func (p *ConnectionParams) setSSLCert(sslCert string) {
 	p.sslCert = sslCert
-	p.set |= sslCertSet
+	p.markSet(sslCertSet)
 }
 
 func (p *ConnectionParams) setSSLKey(sslKey string) {
 	p.sslKey = sslKey
-	p.set |= sslKeySet
+	p.markSet(sslKeySet)
 }
 
 func (p *ConnectionParams) setSSLRootCert(sslRootCert string) {
 	p.sslRootCert = sslRootCert
-	p.set |= sslRootCertSet
+	p.markSet(sslRootCertSet)
 }
 
 func (p *ConnectionParams) setService(service string) {
 	p.service = service
-	p.set |= serviceSet
+	p.markSet(serviceSet)
+}
+
+func (p *ConnectionParams) setTargetSessionAttrs(targetSessionAttrs string) {
+	p.targetSessionAttrs = targetSessionAttrs
+	p.markSet(targetSessionAttrsSet)
+}
+
+func (p *ConnectionParams) setApplicationName(applicationName string) {
+	p.applicationName = applicationName
+	p.markSet(applicationNameSet)
+}
+
+func (p *ConnectionParams) setOptions(options string) {
+	p.options = options
+	p.markSet(optionsSet)
+}
+
+func (p *ConnectionParams) setRuntimeParam(name, value string) {
+	// Since ConnectionParams is passed around by value, the map is cloned
+	// before being modified so that copies don't share state.
+	runtimeParams := maps.Clone(p.runtimeParams)
+	if runtimeParams == nil {
+		runtimeParams = make(map[string]string, 1)
+	}
+
+	runtimeParams[name] = value
+	p.runtimeParams = runtimeParams
 }
 
 func (p ConnectionParams) getUser() (string, bool) {
@@ -397,12 +358,12 @@ func (p ConnectionParams) getPassword() (string, bool) {
 	return p.password, (p.set & passwordSet) != 0
 }
 
-func (p ConnectionParams) getHost() (string, bool) {
-	return p.host, (p.set & hostSet) != 0
+func (p ConnectionParams) getHosts() ([]string, bool) {
+	return p.hosts, (p.set & hostSet) != 0
 }
 
-func (p ConnectionParams) getPort() (int, bool) {
-	return p.port, (p.set & portSet) != 0
+func (p ConnectionParams) getPorts() ([]int, bool) {
+	return p.ports, (p.set & portSet) != 0
 }
 
 func (p ConnectionParams) getSSLMode() (string, bool) {
@@ -433,6 +394,57 @@ func (p ConnectionParams) getService() (string, bool) {
 	return p.service, (p.set & serviceSet) != 0
 }
 
+func (p ConnectionParams) getTargetSessionAttrs() (string, bool) {
+	return p.targetSessionAttrs, (p.set & targetSessionAttrsSet) != 0
+}
+
+func (p ConnectionParams) getApplicationName() (string, bool) {
+	return p.applicationName, (p.set & applicationNameSet) != 0
+}
+
+// getOptions returns the value of the 'options' keyword, which is made up of
+// the raw options followed by a '-c name=value' for each runtime param.
+func (p ConnectionParams) getOptions() (string, bool) {
+	var parts []string
+	if (p.set&optionsSet) != 0 && p.options != "" {
+		parts = append(parts, p.options)
+	}
+
+	names := make([]string, 0, len(p.runtimeParams))
+	for name := range p.runtimeParams {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		parts = append(parts, "-c "+escapeOption(name+"="+p.runtimeParams[name]))
+	}
+
+	if len(parts) == 0 {
+		return p.options, (p.set & optionsSet) != 0
+	}
+
+	return strings.Join(parts, " "), true
+}
+
+// escapeOption escapes a single command-line option for the 'options'
+// keyword, where whitespace separates options unless escaped by a backslash.
+func escapeOption(v string) string {
+	var b strings.Builder
+	b.Grow(len(v))
+
+	for _, r := range v {
+		switch r {
+		case '\\', ' ', '\t', '\n':
+			b.WriteRune('\\')
+		default:
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
 func (p ConnectionParams) hasUserEqual(user string) bool {
 	return (p.set&userSet) != 0 && p.user == user
 }
@@ -441,12 +453,12 @@ func (p ConnectionParams) hasPasswordEqual(password string) bool {
 	return (p.set&passwordSet) != 0 && p.password == password
 }
 
-func (p ConnectionParams) hasHostEqual(host string) bool {
-	return (p.set&hostSet) != 0 && p.host == host
+func (p ConnectionParams) hasHostsEqual(hosts []string) bool {
+	return (p.set&hostSet) != 0 && slices.Equal(p.hosts, hosts)
 }
 
-func (p ConnectionParams) hasPortEqual(port int) bool {
-	return (p.set&portSet) != 0 && p.port == port
+func (p ConnectionParams) hasPortsEqual(ports []int) bool {
+	return (p.set&portSet) != 0 && slices.Equal(p.ports, ports)
 }
 
 func (p ConnectionParams) hasSSLModeEqual(sslMode string) bool {
@@ -476,3 +488,11 @@ func (p ConnectionParams) hasSSLRootCertEqual(sslRootCert string) bool {
 func (p ConnectionParams) hasServiceEqual(service string) bool {
 	return (p.set&serviceSet) != 0 && p.service == service
 }
+
+func (p ConnectionParams) hasTargetSessionAttrsEqual(targetSessionAttrs string) bool {
+	return (p.set&targetSessionAttrsSet) != 0 && p.targetSessionAttrs == targetSessionAttrs
+}
+
+func (p ConnectionParams) hasApplicationNameEqual(applicationName string) bool {
+	return (p.set&applicationNameSet) != 0 && p.applicationName == applicationName
+}
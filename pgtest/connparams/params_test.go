@@ -0,0 +1,229 @@
+package connparams
+
+import (
+	"math/bits"
+	"testing"
+	"time"
+)
+
+func TestConnectionParamsHosts(t *testing.T) {
+	testCases := map[string]struct {
+		opts              []Option
+		expectedURI       string
+		expectedKeyValues string
+	}{
+		"single_tcp_host": {
+			opts: []Option{
+				WithHost("localhost"),
+				WithPort(5432),
+			},
+			expectedURI:       "postgres://localhost:5432/db",
+			expectedKeyValues: "dbname=db host=localhost port=5432",
+		},
+		"single_tcp_host_no_port": {
+			opts: []Option{
+				WithHost("localhost"),
+			},
+			expectedURI:       "postgres://localhost/db",
+			expectedKeyValues: "dbname=db host=localhost",
+		},
+		"port_no_host": {
+			opts: []Option{
+				WithPort(5400),
+			},
+			expectedURI:       "postgres:///db?port=5400",
+			expectedKeyValues: "dbname=db port=5400",
+		},
+		"ipv6_host": {
+			opts: []Option{
+				WithHost("::1"),
+				WithPort(5432),
+			},
+			expectedURI:       "postgres://[::1]:5432/db",
+			expectedKeyValues: "dbname=db host=::1 port=5432",
+		},
+		"socket_dir": {
+			opts: []Option{
+				WithHost("/var/run/postgresql"),
+				WithPort(5432),
+				WithUser("foo"),
+			},
+			expectedURI:       "postgres://foo@/db?host=%2Fvar%2Frun%2Fpostgresql&port=5432",
+			expectedKeyValues: "dbname=db host=/var/run/postgresql port=5432 user=foo",
+		},
+		"multiple_hosts_single_port": {
+			opts: []Option{
+				WithHosts("primary", "standby"),
+				WithPort(5432),
+				WithTargetSessionAttrs(TargetSessionAttrsReadWrite),
+			},
+			expectedURI:       "postgres://primary:5432,standby:5432/db?target_session_attrs=read-write",
+			expectedKeyValues: "dbname=db host=primary,standby port=5432 target_session_attrs=read-write",
+		},
+		"multiple_hosts_multiple_ports": {
+			opts: []Option{
+				WithHosts("primary", "standby"),
+				WithPorts(5432, 5433),
+			},
+			expectedURI:       "postgres://primary:5432,standby:5433/db",
+			expectedKeyValues: "dbname=db host=primary,standby port=5432,5433",
+		},
+		"multiple_hosts_mismatched_ports": {
+			opts: []Option{
+				WithHosts("a", "b", "c"),
+				WithPorts(5432, 5433),
+			},
+			expectedURI:       "postgres:///db?host=a%2Cb%2Cc&port=5432%2C5433",
+			expectedKeyValues: "dbname=db host=a,b,c port=5432,5433",
+		},
+		"socket_dir_and_tcp_host": {
+			opts: []Option{
+				WithHosts("/tmp", "localhost"),
+				WithPorts(5400, 5432),
+			},
+			expectedURI:       "postgres:///db?host=%2Ftmp%2Clocalhost&port=5400%2C5432",
+			expectedKeyValues: "dbname=db host=/tmp,localhost port=5400,5432",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			p := New("db", testCase.opts...)
+
+			if uri := p.URI().String(); uri != testCase.expectedURI {
+				t.Errorf("p.URI() = %q; want %q", uri, testCase.expectedURI)
+			}
+
+			if kvs := p.KeyValues().String(); kvs != testCase.expectedKeyValues {
+				t.Errorf("p.KeyValues() = %q; want %q", kvs, testCase.expectedKeyValues)
+			}
+		})
+	}
+}
+
+func TestConnectionParamsEqualHosts(t *testing.T) {
+	testCases := map[string]struct {
+		a, b          ConnectionParams
+		expectedEqual bool
+	}{
+		"same_hosts": {
+			a:             New("db", WithHosts("a", "b"), WithPorts(1, 2)),
+			b:             New("db", WithHosts("a", "b"), WithPorts(1, 2)),
+			expectedEqual: true,
+		},
+		"different_host_order": {
+			a:             New("db", WithHosts("a", "b")),
+			b:             New("db", WithHosts("b", "a")),
+			expectedEqual: false,
+		},
+		"different_ports": {
+			a:             New("db", WithHost("a"), WithPorts(1, 2)),
+			b:             New("db", WithHost("a"), WithPort(1)),
+			expectedEqual: false,
+		},
+		"with_host_and_with_hosts": {
+			a:             New("db", WithHost("a")),
+			b:             New("db", WithHosts("a")),
+			expectedEqual: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if equal := testCase.a.Equal(testCase.b); equal != testCase.expectedEqual {
+				t.Errorf("a.Equal(b) = %t; want %t (a=%q, b=%q)", equal, testCase.expectedEqual, testCase.a, testCase.b)
+			}
+		})
+	}
+}
+
+func TestConnectionParamsRuntimeParams(t *testing.T) {
+	testCases := map[string]struct {
+		opts              []Option
+		expectedURI       string
+		expectedKeyValues string
+	}{
+		"application_name": {
+			opts: []Option{
+				WithApplicationName("TestFoo/bar"),
+			},
+			expectedURI:       "postgres:///db?application_name=TestFoo%2Fbar",
+			expectedKeyValues: "dbname=db application_name=TestFoo/bar",
+		},
+		"raw_options": {
+			opts: []Option{
+				WithOptions("-c geqo=off"),
+			},
+			expectedURI:       "postgres:///db?options=-c+geqo%3Doff",
+			expectedKeyValues: "dbname=db options='-c geqo=off'",
+		},
+		"search_path": {
+			opts: []Option{
+				WithSearchPath("app", "public"),
+			},
+			expectedURI:       "postgres:///db?options=-c+search_path%3Dapp%2Cpublic",
+			expectedKeyValues: "dbname=db options='-c search_path=app,public'",
+		},
+		"multiple_runtime_params_sorted": {
+			opts: []Option{
+				WithStatementTimeout(5 * time.Second),
+				WithSearchPath("app"),
+			},
+			expectedURI:       "postgres:///db?options=-c+search_path%3Dapp+-c+statement_timeout%3D5000",
+			expectedKeyValues: "dbname=db options='-c search_path=app -c statement_timeout=5000'",
+		},
+		"raw_options_and_runtime_params": {
+			opts: []Option{
+				WithRuntimeParam("search_path", "app"),
+				WithOptions("-c geqo=off"),
+			},
+			expectedURI:       "postgres:///db?options=-c+geqo%3Doff+-c+search_path%3Dapp",
+			expectedKeyValues: "dbname=db options='-c geqo=off -c search_path=app'",
+		},
+		"runtime_param_with_space": {
+			opts: []Option{
+				WithRuntimeParam("search_path", `"my schema"`),
+			},
+			expectedURI:       "postgres:///db?options=-c+search_path%3D%22my%5C+schema%22",
+			expectedKeyValues: `dbname=db options='-c search_path="my\\ schema"'`,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			p := New("db", testCase.opts...)
+
+			if uri := p.URI().String(); uri != testCase.expectedURI {
+				t.Errorf("p.URI() = %q; want %q", uri, testCase.expectedURI)
+			}
+
+			if kvs := p.KeyValues().String(); kvs != testCase.expectedKeyValues {
+				t.Errorf("p.KeyValues() = %q; want %q", kvs, testCase.expectedKeyValues)
+			}
+		})
+	}
+}
+
+func TestConnectionParamsRuntimeParamsNotShared(t *testing.T) {
+	base := New("db", WithSearchPath("app"))
+	modified := base.With(WithSearchPath("other"), WithStatementTimeout(time.Second))
+
+	if searchPath := base.RuntimeParams()["search_path"]; searchPath != "app" {
+		t.Errorf(`after modifying copy base.RuntimeParams()["search_path"] = %q; want "app"`, searchPath)
+	}
+
+	if base.Equal(modified) {
+		t.Errorf("base.Equal(modified) = true; want false (base=%q, modified=%q)", base, modified)
+	}
+
+	if !modified.Equal(New("db", WithStatementTimeout(time.Second), WithSearchPath("other"))) {
+		t.Errorf("modified.Equal(...) = false; want true (modified=%q)", modified)
+	}
+}
+
+func TestNumFields(t *testing.T) {
+	// Each field's set bit flag indexes the sources.
+	if last := bits.TrailingZeros(optionsSet); last != numFields-1 {
+		t.Errorf("the last set bit flag is bit %d; want numFields-1 = %d", last, numFields-1)
+	}
+}
@@ -0,0 +1,76 @@
+package connparams
+
+import (
+	"log/slog"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// redactedPassword replaces the password in redacted connection params. This
+// matches the placeholder used by (*url.URL).Redacted.
+const redactedPassword = "xxxxx"
+
+// Redacted returns a copy of the connection params with the password, if any,
+// replaced by a placeholder. This is safe to log, but can't be used to connect.
+func (p ConnectionParams) Redacted() ConnectionParams {
+	if (p.set & passwordSet) != 0 {
+		p.password = redactedPassword
+	}
+
+	return p
+}
+
+// WithoutPassword returns a copy of the connection params with the password
+// removed entirely. Unlike Redacted, the result can still be used to connect
+// if the password is supplied some other way (e.g. through PGPASSWORD or a
+// .pgpass file).
+func (p ConnectionParams) WithoutPassword() ConnectionParams {
+	p.password = ""
+	p.set &^= passwordSet
+	p.sources[bits.TrailingZeros(passwordSet)] = ""
+	return p
+}
+
+// GoString returns a Go syntax representation of the connection params, with
+// the password redacted.
+func (p ConnectionParams) GoString() string {
+	return "connparams.ConnectionParams(" + strconv.Quote(p.String()) + ")"
+}
+
+// LogValue implements slog.LogValuer, logging the connection params as a group
+// with the password redacted.
+func (p ConnectionParams) LogValue() slog.Value {
+	kvs := p.Redacted().KeyValues()
+	attrs := make([]slog.Attr, len(kvs))
+	for i, kv := range kvs {
+		attrs[i] = slog.String(kv.Key, kv.Value)
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// PsqlCommand returns a psql command line which can be copy-pasted into a
+// shell to connect using the connection params. The password is left out, so
+// psql will either prompt for it or read it from PGPASSWORD or a .pgpass file.
+func (p ConnectionParams) PsqlCommand() string {
+	return "psql " + shellQuote(p.WithoutPassword().KeyValues().String())
+}
+
+// PsqlArgs returns the arguments to run psql with to connect using the
+// connection params, along with the environment variables to run it with. The
+// password is passed through PGPASSWORD rather than the arguments, so it
+// isn't visible in the process list.
+func (p ConnectionParams) PsqlArgs() (args, env []string) {
+	args = []string{"psql", p.WithoutPassword().KeyValues().String()}
+	if password, ok := p.getPassword(); ok {
+		env = []string{"PGPASSWORD=" + password}
+	}
+
+	return args, env
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,96 @@
+package connparams
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testPassword = "s3cr3t-pa55w.rd"
+
+func TestConnectionParamsRedacted(t *testing.T) {
+	p := New(
+		"db",
+		WithHost("localhost"),
+		WithUser("foo"),
+		WithPassword(testPassword),
+	)
+
+	var logBuf bytes.Buffer
+	slog.New(slog.NewTextHandler(&logBuf, nil)).Info("connecting", "params", p)
+
+	outputs := map[string]string{
+		"String":   p.String(),
+		"GoString": fmt.Sprintf("%#v", p),
+		"LogValue": logBuf.String(),
+		"Psql":     p.PsqlCommand(),
+	}
+
+	for name, output := range outputs {
+		if strings.Contains(output, testPassword) {
+			t.Errorf("%s output contains password: %s", name, output)
+		}
+	}
+
+	if !strings.Contains(logBuf.String(), "params.password=xxxxx") {
+		t.Errorf("log output missing redacted password: %s", logBuf.String())
+	}
+
+	// Connection strings still need the password.
+	if uri := p.URI().String(); !strings.Contains(uri, testPassword) {
+		t.Errorf("p.URI() = %q; want to contain password", uri)
+	}
+
+	if kvs := p.KeyValues().String(); !strings.Contains(kvs, testPassword) {
+		t.Errorf("p.KeyValues() = %q; want to contain password", kvs)
+	}
+}
+
+func TestConnectionParamsPsqlCommand(t *testing.T) {
+	p := New(
+		"pg_test_1",
+		WithHost("/var/run/postgresql"),
+		WithPort(5432),
+		WithUser("foo"),
+		WithPassword(testPassword),
+		WithApplicationName("it's a test"),
+	)
+
+	expected := `psql 'dbname=pg_test_1 host=/var/run/postgresql port=5432 user=foo application_name='\''it\'\''s a test'\'''`
+	if cmd := p.PsqlCommand(); cmd != expected {
+		t.Errorf("p.PsqlCommand() = %s; want %s", cmd, expected)
+	}
+}
+
+func TestConnectionParamsPsqlArgs(t *testing.T) {
+	testCases := map[string]struct {
+		params       ConnectionParams
+		expectedArgs []string
+		expectedEnv  []string
+	}{
+		"with_password": {
+			params:       New("pg_test_1", WithHost("localhost"), WithUser("foo"), WithPassword(testPassword)),
+			expectedArgs: []string{"psql", "dbname=pg_test_1 host=localhost user=foo"},
+			expectedEnv:  []string{"PGPASSWORD=" + testPassword},
+		},
+		"without_password": {
+			params:       New("pg_test_1", WithHost("localhost"), WithUser("foo")),
+			expectedArgs: []string{"psql", "dbname=pg_test_1 host=localhost user=foo"},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			args, env := testCase.params.PsqlArgs()
+			if !slices.Equal(args, testCase.expectedArgs) {
+				t.Errorf("p.PsqlArgs() args = %q; want %q", args, testCase.expectedArgs)
+			}
+			if !slices.Equal(env, testCase.expectedEnv) {
+				t.Errorf("p.PsqlArgs() env = %q; want %q", env, testCase.expectedEnv)
+			}
+		})
+	}
+}
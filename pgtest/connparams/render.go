@@ -0,0 +1,234 @@
+package connparams
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A keyword describes how a field of the connection params is rendered as a
+// libpq connection keyword. Both URI and KeyValues are rendered from the same
+// table, so the two formats can't disagree on the name of a keyword.
+//
+// See https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-PARAMKEYWORDS
+type keyword struct {
+	name string
+	get  func(p ConnectionParams) (string, bool)
+
+	// inURIAuthority indicates that the keyword is normally rendered
+	// as part of the authority of the URI rather than in the query.
+	inURIAuthority bool
+}
+
+var keywords = []keyword{
+	{
+		name: "host",
+		get: func(p ConnectionParams) (string, bool) {
+			hosts, ok := p.getHosts()
+			return strings.Join(hosts, ","), ok
+		},
+		inURIAuthority: true,
+	},
+	{
+		name: "port",
+		get: func(p ConnectionParams) (string, bool) {
+			ports, ok := p.getPorts()
+			return joinPorts(ports), ok
+		},
+		inURIAuthority: true,
+	},
+	{name: "user", get: ConnectionParams.getUser, inURIAuthority: true},
+	{name: "password", get: ConnectionParams.getPassword, inURIAuthority: true},
+	{name: "sslmode", get: ConnectionParams.getSSLMode},
+	{name: "fallback_application_name", get: ConnectionParams.getFallbackApplicationName},
+	{
+		name: "connect_timeout",
+		get: func(p ConnectionParams) (string, bool) {
+			t, ok := p.getConnectionTimeout()
+			return strconv.Itoa(t), ok
+		},
+	},
+	{name: "sslcert", get: ConnectionParams.getSSLCert},
+	{name: "sslkey", get: ConnectionParams.getSSLKey},
+	{name: "sslrootcert", get: ConnectionParams.getSSLRootCert},
+	{name: "service", get: ConnectionParams.getService},
+	{name: "target_session_attrs", get: ConnectionParams.getTargetSessionAttrs},
+	{name: "application_name", get: ConnectionParams.getApplicationName},
+	{name: "options", get: ConnectionParams.getOptions},
+}
+
+// URI returns the connection params as a postgres connection URI.
+func (p ConnectionParams) URI() *url.URL {
+	uri := &url.URL{
+		Scheme: "postgres",
+		Path:   "/" + p.DBName(),
+	}
+
+	if u, ok := p.getUser(); ok {
+		if pass, ok := p.getPassword(); ok {
+			uri.User = url.UserPassword(u, pass)
+		} else {
+			uri.User = url.User(u)
+		}
+	}
+
+	// Unix domain socket directories can only be represented in the host
+	// portion of the URI by percent-encoding them, which Go's url.Parse
+	// (and therefore pgx) rejects, so in that case the hosts are specified
+	// using query parameters instead. The same is true when the number of
+	// ports doesn't line up with the number of hosts.
+	q := url.Values{}
+
+	hosts, hasHosts := p.getHosts()
+	ports, hasPorts := p.getPorts()
+	if hasHosts && uriHostsRepresentable(hosts, ports) {
+		uri.Host = uriHosts(hosts, ports)
+	} else {
+		if hasHosts {
+			q.Set("host", strings.Join(hosts, ","))
+		}
+
+		if hasPorts {
+			q.Set("port", joinPorts(ports))
+		}
+	}
+
+	for _, kw := range keywords {
+		if kw.inURIAuthority {
+			continue
+		}
+
+		if v, ok := kw.get(p); ok {
+			q.Set(kw.name, v)
+		}
+	}
+
+	uri.RawQuery = q.Encode()
+
+	return uri
+}
+
+// IsUnixSocket reports whether the host refers to the directory of a unix
+// domain socket rather than a network host. Like libpq, any host that is an
+// absolute path is treated as a socket directory.
+func IsUnixSocket(host string) bool {
+	return strings.HasPrefix(host, "/")
+}
+
+func uriHostsRepresentable(hosts []string, ports []int) bool {
+	if len(ports) > 1 && len(ports) != len(hosts) {
+		return false
+	}
+
+	for _, host := range hosts {
+		if host == "" || IsUnixSocket(host) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func uriHosts(hosts []string, ports []int) string {
+	parts := make([]string, len(hosts))
+	for i, host := range hosts {
+		if strings.Contains(host, ":") {
+			// IPv6 address.
+			host = "[" + host + "]"
+		}
+
+		switch len(ports) {
+		case 0:
+		case 1:
+			host += ":" + strconv.Itoa(ports[0])
+		default:
+			host += ":" + strconv.Itoa(ports[i])
+		}
+
+		parts[i] = host
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func joinPorts(ports []int) string {
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.Itoa(port)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+func (kv KeyValue) String() string {
+	return kv.Key + "=" + kv.Value
+}
+
+// normalizeValue formats a value for use in a keyword/value connection string.
+// libpq ends an unquoted value at the first whitespace, so values containing
+// whitespace (or which are empty) must be single-quoted. Single quotes and
+// backslashes must be escaped with a backslash whether or not the value is
+// quoted.
+func normalizeValue(v string) string {
+	if v == "" {
+		return `''`
+	}
+
+	var (
+		b          strings.Builder
+		needsQuote bool
+	)
+
+	b.Grow(len(v))
+
+	for _, r := range v {
+		switch {
+		case r == '\\' || r == '\'':
+			b.WriteRune('\\')
+		case unicode.IsSpace(r):
+			needsQuote = true
+		default:
+		}
+		b.WriteRune(r)
+	}
+
+	if needsQuote {
+		return "'" + b.String() + "'"
+	}
+
+	return b.String()
+}
+
+func keyValue(k, v string) KeyValue {
+	return KeyValue{Key: k, Value: v}
+}
+
+type KeyValues []KeyValue
+
+func (kvs KeyValues) String() string {
+	parts := make([]string, len(kvs))
+	for i, kv := range kvs {
+		parts[i] = kv.String()
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// KeyValues returns the connection params as libpq keyword/value pairs, with
+// the values already quoted and escaped as needed.
+func (p ConnectionParams) KeyValues() KeyValues {
+	kvs := []KeyValue{keyValue("dbname", normalizeValue(p.dbName))}
+	for _, kw := range keywords {
+		if v, ok := kw.get(p); ok {
+			kvs = append(kvs, keyValue(kw.name, normalizeValue(v)))
+		}
+	}
+
+	return kvs
+}
@@ -0,0 +1,291 @@
+package connparams
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// writeTestCert writes a self-signed certificate and its key to dir, returning
+// their paths.
+func writeTestCert(t testing.TB, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pgtest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error creating certificate: %s", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling key: %s", err)
+	}
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600); err != nil {
+		t.Fatalf("unexpected error writing certificate: %s", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("unexpected error writing key: %s", err)
+	}
+
+	return certFile, keyFile
+}
+
+// clearPGEnv unsets any libpq environment variables, since pgconn.ParseConfig
+// uses them as defaults.
+func clearPGEnv(t *testing.T) {
+	t.Helper()
+
+	for _, name := range []string{
+		"PGHOST", "PGPORT", "PGDATABASE", "PGUSER", "PGPASSWORD", "PGPASSFILE",
+		"PGSERVICE", "PGSERVICEFILE", "PGSSLMODE", "PGSSLCERT", "PGSSLKEY",
+		"PGSSLROOTCERT", "PGAPPNAME", "PGCONNECT_TIMEOUT", "PGTARGETSESSIONATTRS",
+		"PGOPTIONS",
+	} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	// Avoid picking up the user's .pgpass file.
+	t.Setenv("PGPASSFILE", filepath.Join(t.TempDir(), "pgpass"))
+}
+
+// TestConnectionParamsRoundTrip verifies that every parameter rendered by both
+// URI and KeyValues is parsed by pgx into the expected field of the config.
+func TestConnectionParamsRoundTrip(t *testing.T) {
+	clearPGEnv(t)
+
+	var (
+		dir               = t.TempDir()
+		certFile, keyFile = writeTestCert(t, dir)
+		serviceFile       = filepath.Join(dir, "pg_service.conf")
+	)
+
+	if err := os.WriteFile(serviceFile, []byte("[pgtest]\nhost=service-host\n"), 0o600); err != nil {
+		t.Fatalf("unexpected error writing service file: %s", err)
+	}
+	t.Setenv("PGSERVICEFILE", serviceFile)
+
+	testCases := map[string]struct {
+		dbName string
+		opts   []Option
+		check  func(t *testing.T, config *pgconn.Config)
+	}{
+		"dbname": {
+			dbName: "my db's name",
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.Database != "my db's name" {
+					t.Errorf("config.Database = %q; want %q", config.Database, "my db's name")
+				}
+			},
+		},
+		"host_and_port": {
+			opts: []Option{WithHost("db.example.com"), WithPort(5400)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.Host != "db.example.com" || config.Port != 5400 {
+					t.Errorf("config.Host, config.Port = %q, %d; want %q, %d", config.Host, config.Port, "db.example.com", 5400)
+				}
+			},
+		},
+		"socket_dir": {
+			opts: []Option{WithHost("/var/run/postgresql"), WithPort(5433)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.Host != "/var/run/postgresql" || config.Port != 5433 {
+					t.Errorf("config.Host, config.Port = %q, %d; want %q, %d", config.Host, config.Port, "/var/run/postgresql", 5433)
+				}
+			},
+		},
+		"multiple_hosts": {
+			opts: []Option{WithHosts("primary", "standby"), WithPorts(5432, 5433), WithSSLMode(SSLModeDisable)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.Host != "primary" || config.Port != 5432 {
+					t.Errorf("config.Host, config.Port = %q, %d; want %q, %d", config.Host, config.Port, "primary", 5432)
+				}
+
+				if len(config.Fallbacks) != 1 || config.Fallbacks[0].Host != "standby" || config.Fallbacks[0].Port != 5433 {
+					t.Errorf("config.Fallbacks = %+v; want [{Host: standby, Port: 5433}]", config.Fallbacks)
+				}
+			},
+		},
+		"user_and_password": {
+			opts: []Option{WithUser("some user"), WithPassword(`p@ss w'rd\`)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.User != "some user" || config.Password != `p@ss w'rd\` {
+					t.Errorf("config.User, config.Password = %q, %q; want %q, %q", config.User, config.Password, "some user", `p@ss w'rd\`)
+				}
+			},
+		},
+		"sslmode_disable": {
+			opts: []Option{WithSSLMode(SSLModeDisable)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.TLSConfig != nil {
+					t.Errorf("config.TLSConfig = %+v; want nil", config.TLSConfig)
+				}
+			},
+		},
+		"sslmode_require": {
+			// TLS isn't used for unix domain sockets, which pgx
+			// defaults to if they exist, so an explicit host is
+			// required.
+			opts: []Option{WithHost("localhost"), WithSSLMode(SSLModeRequire)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.TLSConfig == nil || !config.TLSConfig.InsecureSkipVerify {
+					t.Errorf("config.TLSConfig = %+v; want InsecureSkipVerify", config.TLSConfig)
+				}
+
+				if len(config.Fallbacks) != 0 {
+					t.Errorf("len(config.Fallbacks) = %d; want 0", len(config.Fallbacks))
+				}
+			},
+		},
+		"fallback_application_name": {
+			opts: []Option{WithFallbackApplicationName("fallback name")},
+			// NOTE: pgx doesn't implement the fallback semantics of
+			// this keyword, and instead passes it through to the
+			// server as a run-time parameter.
+			check: func(t *testing.T, config *pgconn.Config) {
+				if name := config.RuntimeParams["fallback_application_name"]; name != "fallback name" {
+					t.Errorf(`config.RuntimeParams["fallback_application_name"] = %q; want %q`, name, "fallback name")
+				}
+			},
+		},
+		"connect_timeout": {
+			opts: []Option{WithConnectionTimeout(7)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.ConnectTimeout != 7*time.Second {
+					t.Errorf("config.ConnectTimeout = %s; want %s", config.ConnectTimeout, 7*time.Second)
+				}
+			},
+		},
+		"sslcert_sslkey_sslrootcert": {
+			opts: []Option{
+				WithHost("localhost"),
+				WithSSLMode(SSLModeVerifyCA),
+				WithSSLCert(certFile),
+				WithSSLKey(keyFile),
+				WithSSLRootCert(certFile),
+			},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.TLSConfig == nil {
+					t.Fatalf("config.TLSConfig = nil; want non-nil")
+				}
+
+				if len(config.TLSConfig.Certificates) != 1 {
+					t.Errorf("len(config.TLSConfig.Certificates) = %d; want 1", len(config.TLSConfig.Certificates))
+				}
+
+				if config.TLSConfig.RootCAs == nil {
+					t.Errorf("config.TLSConfig.RootCAs = nil; want non-nil")
+				}
+			},
+		},
+		"service": {
+			opts: []Option{WithService("pgtest")},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.Host != "service-host" {
+					t.Errorf("config.Host = %q; want %q", config.Host, "service-host")
+				}
+			},
+		},
+		"target_session_attrs": {
+			opts: []Option{WithTargetSessionAttrs(TargetSessionAttrsReadWrite)},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if config.ValidateConnect == nil {
+					t.Errorf("config.ValidateConnect = nil; want non-nil")
+				}
+			},
+		},
+		"application_name": {
+			opts: []Option{WithFallbackApplicationName("fallback"), WithApplicationName("TestFoo/bar baz")},
+			check: func(t *testing.T, config *pgconn.Config) {
+				if name := config.RuntimeParams["application_name"]; name != "TestFoo/bar baz" {
+					t.Errorf(`config.RuntimeParams["application_name"] = %q; want %q`, name, "TestFoo/bar baz")
+				}
+			},
+		},
+		"options": {
+			opts: []Option{WithOptions("-c geqo=off"), WithSearchPath("my schema", "public")},
+			check: func(t *testing.T, config *pgconn.Config) {
+				expected := `-c geqo=off -c search_path=my\ schema,public`
+				if options := config.RuntimeParams["options"]; options != expected {
+					t.Errorf(`config.RuntimeParams["options"] = %q; want %q`, options, expected)
+				}
+			},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		dbName := testCase.dbName
+		if dbName == "" {
+			dbName = "db"
+		}
+		p := New(dbName, testCase.opts...)
+
+		connStrings := map[string]string{
+			"uri":        p.URI().String(),
+			"key_values": p.KeyValues().String(),
+		}
+
+		for format, connString := range connStrings {
+			t.Run(testName+"/"+format, func(t *testing.T) {
+				t.Logf("connString = %s", connString)
+
+				config, err := pgconn.ParseConfig(connString)
+				if err != nil {
+					t.Fatalf("pgconn.ParseConfig(%q) = %s; want nil error", connString, err)
+				}
+
+				testCase.check(t, config)
+			})
+		}
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	testCases := map[string]string{
+		"":          `''`,
+		"foo":       `foo`,
+		"foo bar":   `'foo bar'`,
+		"foo\tbar":  "'foo\tbar'",
+		"foo\nbar":  "'foo\nbar'",
+		`it's`:      `it\'s`,
+		`back\`:     `back\\`,
+		"a=b":       `a=b`,
+		`it's done`: `'it\'s done'`,
+	}
+
+	for v, expected := range testCases {
+		if actual := normalizeValue(v); actual != expected {
+			t.Errorf("normalizeValue(%q) = %s; want %s", v, actual, expected)
+		}
+	}
+}
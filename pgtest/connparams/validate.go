@@ -0,0 +1,191 @@
+package connparams
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var sslModes = []string{
+	SSLModeDisable,
+	SSLModeAllow,
+	SSLModePrefer,
+	SSLModeRequire,
+	SSLModeVerifyCA,
+	SSLModeVerifyFull,
+}
+
+var targetSessionAttrs = []string{
+	TargetSessionAttrsAny,
+	TargetSessionAttrsReadWrite,
+	TargetSessionAttrsReadOnly,
+	TargetSessionAttrsPrimary,
+	TargetSessionAttrsStandby,
+	TargetSessionAttrsPreferStandby,
+}
+
+// A FieldError describes an invalid connection parameter.
+type FieldError struct {
+	// Field is the libpq keyword of the invalid parameter (e.g.
+	// "sslmode").
+	Field string
+
+	// Value is the invalid value.
+	Value string
+
+	// Source describes where the parameter was set. This is either
+	// "option", "default", or "env var <NAME>" for parameters set through
+	// FromEnv.
+	Source string
+
+	// Reason describes why the value is invalid.
+	Reason string
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	msg := fmt.Sprintf("invalid %s %q (from %s): %s", e.Field, e.Value, e.Source, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// A ValidationError is returned by ConnectionParams.Validate, and describes
+// every invalid connection parameter.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	parts := make([]string, len(e))
+	for i, err := range e {
+		parts[i] = err.Error()
+	}
+
+	return "connparams: " + strings.Join(parts, "; ")
+}
+
+func (e ValidationError) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// Validate checks the connection params for values which would prevent a
+// connection from being established, such as an unknown sslmode or a port out
+// of range. If any are found, a ValidationError is returned.
+//
+// Note that since the sslcert, sslkey, and sslrootcert files are checked for
+// existence, the result of Validate depends on the local filesystem.
+func (p ConnectionParams) Validate() error {
+	var errs ValidationError
+	fieldErr := func(field string, flag uint, value, reason string, err error) {
+		errs = append(errs, &FieldError{
+			Field:  field,
+			Value:  value,
+			Source: p.source(flag),
+			Reason: reason,
+			Err:    err,
+		})
+	}
+
+	hosts, hasHosts := p.getHosts()
+	if hasHosts {
+		if len(hosts) == 0 {
+			fieldErr("host", hostSet, "", "no hosts specified", nil)
+		}
+
+		for _, host := range hosts {
+			if host == "" {
+				fieldErr("host", hostSet, strings.Join(hosts, ","), "empty host", nil)
+				break
+			}
+		}
+	}
+
+	if ports, ok := p.getPorts(); ok {
+		for _, port := range ports {
+			if port < 1 || port > 65535 {
+				fieldErr("port", portSet, strconv.Itoa(port), "must be between 1 and 65535", nil)
+			}
+		}
+
+		if len(ports) == 0 {
+			fieldErr("port", portSet, "", "no ports specified", nil)
+		} else if len(ports) > 1 && len(ports) != len(hosts) {
+			fieldErr("port", portSet, joinPorts(ports), fmt.Sprintf("got %d ports for %d hosts", len(ports), len(hosts)), nil)
+		}
+	}
+
+	if mode, ok := p.getSSLMode(); ok && !slices.Contains(sslModes, mode) {
+		fieldErr("sslmode", sslModeSet, mode, "must be one of "+strings.Join(sslModes, ", "), nil)
+	}
+
+	if a, ok := p.getTargetSessionAttrs(); ok && !slices.Contains(targetSessionAttrs, a) {
+		fieldErr("target_session_attrs", targetSessionAttrsSet, a, "must be one of "+strings.Join(targetSessionAttrs, ", "), nil)
+	}
+
+	if t, ok := p.getConnectionTimeout(); ok && t < 0 {
+		fieldErr("connect_timeout", connectionTimeoutSet, strconv.Itoa(t), "must not be negative", nil)
+	}
+
+	cert, hasCert := p.getSSLCert()
+	key, hasKey := p.getSSLKey()
+	switch {
+	case hasCert && !hasKey:
+		fieldErr("sslcert", sslCertSet, cert, "sslkey must also be specified", nil)
+	case hasKey && !hasCert:
+		fieldErr("sslkey", sslKeySet, key, "sslcert must also be specified", nil)
+	}
+
+	if hasCert {
+		if err := checkFile(cert); err != nil {
+			fieldErr("sslcert", sslCertSet, cert, "unusable file", err)
+		}
+	}
+
+	if hasKey {
+		if err := checkFile(key); err != nil {
+			fieldErr("sslkey", sslKeySet, key, "unusable file", err)
+		}
+	}
+
+	// libpq treats an sslrootcert of "system" as a request to use the
+	// system's trusted CA roots, rather than a file.
+	if rootCert, ok := p.getSSLRootCert(); ok && rootCert != "system" {
+		if err := checkFile(rootCert); err != nil {
+			fieldErr("sslrootcert", sslRootCertSet, rootCert, "unusable file", err)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func checkFile(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return errors.New("is a directory")
+	}
+
+	return nil
+}
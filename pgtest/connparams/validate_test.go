@@ -0,0 +1,153 @@
+package connparams
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectionParamsValidateSuccess(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		cert = filepath.Join(dir, "client.crt")
+		key  = filepath.Join(dir, "client.key")
+	)
+
+	for _, name := range []string{cert, key} {
+		if err := os.WriteFile(name, nil, 0o600); err != nil {
+			t.Fatalf("unexpected error creating %s: %s", name, err)
+		}
+	}
+
+	p := NewWithDefaults(
+		"db",
+		WithHosts("primary", "/var/run/postgresql"),
+		WithPorts(5432, 5433),
+		WithSSLMode(SSLModeVerifyFull),
+		WithSSLCert(cert),
+		WithSSLKey(key),
+		WithSSLRootCert("system"),
+		WithTargetSessionAttrs(TargetSessionAttrsReadWrite),
+	)
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("p.Validate() = %s; want nil", err)
+	}
+}
+
+func TestConnectionParamsValidateErrors(t *testing.T) {
+	missingFile := filepath.Join(t.TempDir(), "missing")
+
+	testCases := map[string]struct {
+		opts           []Option
+		expectedErrors []FieldError
+	}{
+		"invalid_sslmode_from_option": {
+			opts: []Option{
+				WithSSLMode("verify_full"),
+			},
+			expectedErrors: []FieldError{
+				{Field: "sslmode", Value: "verify_full", Source: "option"},
+			},
+		},
+		"invalid_sslmode_from_env": {
+			opts: []Option{
+				FromEnv("PGTEST_SSLMODE", WithSSLMode("verify_full")),
+			},
+			expectedErrors: []FieldError{
+				{Field: "sslmode", Value: "verify_full", Source: "env var PGTEST_SSLMODE"},
+			},
+		},
+		"env_overridden_by_option": {
+			opts: []Option{
+				FromEnv("PGTEST_PORT", WithPort(5432)),
+				WithPort(0),
+			},
+			expectedErrors: []FieldError{
+				{Field: "port", Value: "0", Source: "option"},
+			},
+		},
+		"port_out_of_range": {
+			opts: []Option{
+				FromEnv("PGTEST_PORT", WithPorts(5432, 70000)),
+				WithHosts("a", "b"),
+			},
+			expectedErrors: []FieldError{
+				{Field: "port", Value: "70000", Source: "env var PGTEST_PORT"},
+			},
+		},
+		"port_count_mismatch": {
+			opts: []Option{
+				WithHosts("a", "b", "c"),
+				WithPorts(5432, 5433),
+			},
+			expectedErrors: []FieldError{
+				{Field: "port", Value: "5432,5433", Source: "option"},
+			},
+		},
+		"invalid_target_session_attrs": {
+			opts: []Option{
+				WithTargetSessionAttrs("primary-only"),
+			},
+			expectedErrors: []FieldError{
+				{Field: "target_session_attrs", Value: "primary-only", Source: "option"},
+			},
+		},
+		"cert_without_key": {
+			opts: []Option{
+				WithSSLCert(missingFile),
+			},
+			expectedErrors: []FieldError{
+				{Field: "sslcert", Value: missingFile, Source: "option"},
+				{Field: "sslcert", Value: missingFile, Source: "option", Err: fs.ErrNotExist},
+			},
+		},
+		"missing_root_cert": {
+			opts: []Option{
+				FromEnv("PGTEST_SSLROOTCERT", WithSSLRootCert(missingFile)),
+			},
+			expectedErrors: []FieldError{
+				{Field: "sslrootcert", Value: missingFile, Source: "env var PGTEST_SSLROOTCERT", Err: fs.ErrNotExist},
+			},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			p := NewWithDefaults("db", testCase.opts...)
+
+			err := p.Validate()
+			if err == nil {
+				t.Fatalf("p.Validate() = nil; want error")
+			}
+
+			t.Logf("err = %s", err)
+
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("unexpectedly not errors.As(%q, ValidationError); err = %#v", err, err)
+			}
+
+			if len(validationErr) != len(testCase.expectedErrors) {
+				t.Fatalf("len(validationErr) = %d; want %d", len(validationErr), len(testCase.expectedErrors))
+			}
+
+			for i, expected := range testCase.expectedErrors {
+				actual := validationErr[i]
+				if actual.Field != expected.Field || actual.Value != expected.Value || actual.Source != expected.Source {
+					t.Errorf(
+						"validationErr[%d] = {Field: %q, Value: %q, Source: %q}; want {Field: %q, Value: %q, Source: %q}", i,
+						actual.Field, actual.Value, actual.Source,
+						expected.Field, expected.Value, expected.Source,
+					)
+				}
+
+				if expected.Err != nil && !errors.Is(actual, expected.Err) {
+					t.Errorf("unexpectedly not errors.Is(validationErr[%d], %v); err = %s", i, expected.Err, actual)
+				}
+			}
+		})
+	}
+}
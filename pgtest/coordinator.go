@@ -0,0 +1,445 @@
+//go:build unix
+
+package pgtest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/ShawnROGrady/go-pgtest/pgtest/internal/coordinator"
+	"github.com/jackc/pgx/v5"
+)
+
+// coordinatorSocketEnv is set when a test binary is re-executed as the
+// coordinator process, to the path of the socket it should listen on.
+const coordinatorSocketEnv = "PGTEST_INTERNAL_COORDINATOR_SOCKET"
+
+// coordinatorConnectTimeout is how long to wait for the coordinator to start.
+const coordinatorConnectTimeout = 30 * time.Second
+
+func init() {
+	// This runs before any tests or TestMain, so the test binary can be
+	// re-executed as the coordinator. The variable is removed as soon as
+	// it is read, so it isn't inherited by any processes started later.
+	socketPath, ok := os.LookupEnv(coordinatorSocketEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(coordinatorSocketEnv)
+
+	if socketPath != "" {
+		os.Exit(runCoordinator(socketPath))
+	}
+}
+
+// coordinatorProcessConfig is the configuration passed to the coordinator
+// process over stdin, so that the password isn't visible in its environment.
+type coordinatorProcessConfig struct {
+	Servers     []serverDSN   `json:"servers"`
+	Placement   Placement     `json:"placement"`
+	IdleTimeout time.Duration `json:"idle_timeout"`
+	Prefix      string        `json:"prefix,omitempty"`
+}
+
+func runCoordinator(socketPath string) int {
+	var conf coordinatorProcessConfig
+	if err := json.NewDecoder(os.Stdin).Decode(&conf); err != nil {
+		log.Printf("ERROR: pgtest: read coordinator config: %s", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	// The coordinator only needs the names of the test databases, since
+	// each client connects to them with its own connection params.
+	factories := make([]*testDBFactory, 0, len(conf.Servers))
+	for _, server := range conf.Servers {
+		factory, err := openTestDBFactory(ctx, server.Name, server.RootDSN, func(dbName string) connparams.ConnectionParams {
+			return connparams.New(dbName)
+		}, dbNaming{prefix: conf.Prefix, pkg: testBinaryPackage()})
+		if err != nil {
+			log.Printf("ERROR: pgtest: open test db factory for server %s: %s", server.Name, err)
+			return 1
+		}
+
+		// The clients report any problems found by the preflight
+		// checks, so the coordinator only needs the capabilities.
+		factory.preflight, err = preflight(ctx, server.Name, factory.rootDB.db, nil)
+		if err != nil {
+			log.Printf("ERROR: pgtest: %s", err)
+		}
+
+		factories = append(factories, factory)
+	}
+	factory := newShardedFactory(conf.Placement, factories)
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		factory.close()
+		log.Printf("ERROR: pgtest: listen on %s: %s", socketPath, err)
+		return 1
+	}
+
+	// The listener removes the socket once it is closed, as soon as the
+	// coordinator is idle. Removing it again later could remove the
+	// socket of a new coordinator started in the meantime.
+
+	pool := &coordinatorPool{inner: newSupervisor(&config{}, factory, nil)}
+	if err := coordinator.Serve(ctx, l, pool, conf.IdleTimeout); err != nil {
+		log.Printf("ERROR: pgtest: serve coordinator: %s", err)
+		return 1
+	}
+
+	return 0
+}
+
+// coordinatorPool serves a supervisor's pool through the coordinator.
+type coordinatorPool struct {
+	inner *supervisor
+}
+
+func (p *coordinatorPool) Acquire(ctx context.Context, args coordinator.AcquireArgs) (coordinator.Lease, error) {
+	var source testDBSource = p.inner
+	if args.Server != "" {
+		var err error
+		source, err = p.inner.forServer(args.Server)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lease, err := source.getTestDB(ctx, args.Test)
+	if err != nil {
+		return nil, err
+	}
+
+	return coordinatorLease{lease}, nil
+}
+
+func (p *coordinatorPool) Close(ctx context.Context) error {
+	return p.inner.shutdown(ctx)
+}
+
+type coordinatorLease struct {
+	testDBLease
+}
+
+func (l coordinatorLease) Name() string {
+	return l.Data().Name()
+}
+
+func (l coordinatorLease) Server() string {
+	return l.Data().Server()
+}
+
+// coordinatorSocketPath returns the path of the socket for the coordinator
+// serving test databases on the specified servers, creating its directory if
+// necessary. Test binaries share a coordinator if they connect to the same
+// servers as the same user, with the same test db name prefix.
+func coordinatorSocketPath(servers []serverDSN, prefix string) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(os.Getuid())))
+	h.Write([]byte{0})
+	h.Write([]byte(prefix))
+	for _, server := range servers {
+		h.Write([]byte{0})
+		h.Write([]byte(server.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(server.RootDSN))
+	}
+
+	dir := filepath.Join(os.TempDir(), "pgtest-"+hex.EncodeToString(h.Sum(nil))[:16])
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "coord.sock"), nil
+}
+
+// checkPrivateDir checks that dir is a directory (not a symlink) owned by the
+// current user, which other users can't access. The coordinator is sent the
+// servers' DSNs, which may include passwords, so another user mustn't be able
+// to listen on its socket, or replace the directory before it is created.
+func checkPrivateDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s isn't owned by the current user", dir)
+	}
+
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("%s can be accessed by other users (mode %s)", dir, perm)
+	}
+
+	return nil
+}
+
+// startCoordinator starts the coordinator in the background by re-executing
+// the test binary. The coordinator runs in its own session, so it isn't
+// interrupted along with the test binaries, and logs to a file next to its
+// socket.
+func startCoordinator(socketPath string, conf coordinatorProcessConfig) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(filepath.Dir(socketPath), "coordinator.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	defer logFile.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	cmd := exec.Command(exe)
+	cmd.Env = reexecEnv(coordinatorSocketEnv, socketPath)
+	cmd.Stdin = r
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(w).Encode(conf); err != nil {
+		return errors.Join(fmt.Errorf("write config: %w", err), cmd.Process.Kill())
+	}
+
+	return cmd.Process.Release()
+}
+
+// coordinatedSource gets test databases from a coordinator.
+type coordinatedSource struct {
+	client  *coordinator.Client
+	servers map[string]connparamsFactory
+	resetOp ResetTestDBOp
+
+	// recorder records the stats, and is shared with the sources for each
+	// server.
+	recorder *statsRecorder
+
+	events *eventLogger
+
+	// server is the only server to get test databases on, if set by
+	// forServer.
+	server string
+}
+
+func newCoordinatedSource(ctx context.Context, conf *config) (testDBSource, error) {
+	if len(conf.managedServers) != 0 {
+		return nil, errors.New("coordinator can't be used with managed servers")
+	}
+
+	if conf.hooks.hasOwnerHooks() {
+		return nil, errors.New("coordinator can't be used with OnCreate or OnDestroy hooks, or ValidateOnAcquire")
+	}
+
+	servers, err := newServerDSNs(conf.servers)
+	if err != nil {
+		return nil, err
+	}
+
+	// The coordinator connects to the servers in the background, so check
+	// that they are ready here to report any errors.
+	for _, server := range servers {
+		if err := waitReady(ctx, server.Name, pingFunc(server.RootDSN), conf.ready); err != nil {
+			return nil, err
+		}
+
+		if err := preflightDSN(ctx, server, conf.events); err != nil {
+			return nil, err
+		}
+	}
+
+	return connectCoordinatedSource(ctx, conf, servers)
+}
+
+// connectCoordinatedSource connects to the coordinator for the servers,
+// starting it if necessary.
+func connectCoordinatedSource(ctx context.Context, conf *config, servers []serverDSN) (testDBSource, error) {
+	socketPath, err := coordinatorSocketPath(servers, conf.naming.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("coordinator socket path: %w", err)
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, coordinatorConnectTimeout)
+	defer cancel()
+
+	client, err := coordinator.Connect(connectCtx, socketPath, func() error {
+		return startCoordinator(socketPath, coordinatorProcessConfig{
+			Servers:     servers,
+			Placement:   conf.placement,
+			IdleTimeout: conf.coordinatorIdleTimeout,
+			Prefix:      conf.naming.prefix,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect to coordinator: %w", err)
+	}
+
+	paramFactories := make(map[string]connparamsFactory, len(conf.servers))
+	for _, server := range conf.servers {
+		paramFactories[server.name] = server.paramFactory
+	}
+
+	return &coordinatedSource{
+		client:   client,
+		servers:  paramFactories,
+		resetOp:  conf.resetOp,
+		recorder: new(statsRecorder),
+		events:   conf.events,
+	}, nil
+}
+
+// pingFunc returns a function which checks that a connection can be opened
+// with dsn.
+func pingFunc(dsn string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		conn, err := pgx.Connect(ctx, dsn)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close(ctx)
+	}
+}
+
+// preflightDSN runs the preflight checks for the server.
+func preflightDSN(ctx context.Context, server serverDSN, events *eventLogger) error {
+	conn, err := pgx.Connect(ctx, server.RootDSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	_, err = preflight(ctx, server.Name, conn, events)
+	return err
+}
+
+func (s *coordinatedSource) getTestDB(ctx context.Context, testName string) (testDBLease, error) {
+	start := time.Now()
+	reply, err := s.client.Acquire(ctx, coordinator.AcquireArgs{Test: testName, Server: s.server})
+	if err != nil {
+		return nil, fmt.Errorf("acquire: %w", err)
+	}
+	wait := time.Since(start)
+
+	paramFactory, ok := s.servers[reply.Server]
+	if !ok {
+		return nil, fmt.Errorf("coordinator leased test db on unknown server %q", reply.Server)
+	}
+
+	lease := &coordinatedLease{
+		client:   s.client,
+		recorder: s.recorder,
+		events:   s.events,
+		leaseID:  reply.LeaseID,
+		db:       &testDB{server: reply.Server, connparams: paramFactory(reply.Name)},
+	}
+
+	if s.resetOp != nil {
+		if err := resetTestDB(ctx, s.resetOp, lease.db, testName, s.recorder, s.events); err != nil {
+			// The lease is released rather than held until the
+			// client disconnects. The test db is reset again before
+			// being re-used.
+			if releaseErr := s.client.Release(context.Background(), lease.leaseID); releaseErr != nil {
+				err = errors.Join(err, fmt.Errorf("release test db: %w", releaseErr))
+			}
+			return nil, fmt.Errorf("reset test db: %w", err)
+		}
+	}
+
+	s.recorder.recordAcquire(wait)
+	return lease, nil
+}
+
+// stats returns the stats for the test binary's use of the coordinator, which
+// are shared with the sources for each server.
+func (s *coordinatedSource) stats() Stats {
+	return s.recorder.snapshot()
+}
+
+func (s *coordinatedSource) forServer(name string) (testDBSource, error) {
+	if _, ok := s.servers[name]; !ok {
+		return nil, fmt.Errorf("unknown server %q", name)
+	}
+
+	server := *s
+	server.server = name
+	return &server, nil
+}
+
+// shutdown disconnects from the coordinator, which drops the test databases
+// once no test binaries are connected.
+func (s *coordinatedSource) shutdown(context.Context) error {
+	if s.server != "" {
+		// The connection is owned by the source for all servers.
+		return nil
+	}
+
+	return s.client.Close()
+}
+
+type coordinatedLease struct {
+	client   *coordinator.Client
+	recorder *statsRecorder
+	events   *eventLogger
+	leaseID  uint64
+	db       TestDB
+}
+
+func (l *coordinatedLease) Data() TestDB { return l.db }
+
+func (l *coordinatedLease) Release() {
+	l.recorder.recordDone(false)
+	if err := l.client.Release(context.Background(), l.leaseID); err != nil {
+		if l.events.enabled() {
+			l.events.cleanupError("failed to release test db to coordinator", err, testDBAttrs(l.db)...)
+		} else {
+			log.Printf("ERROR: pgtest: release test db %s: %s", l.db.Name(), err)
+		}
+	}
+}
+
+func (l *coordinatedLease) Hijack() {
+	l.recorder.recordDone(true)
+	if err := l.client.Hijack(context.Background(), l.leaseID); err != nil {
+		if l.events.enabled() {
+			l.events.cleanupError("failed to hijack test db from coordinator", err, testDBAttrs(l.db)...)
+		} else {
+			log.Printf("ERROR: pgtest: hijack test db %s: %s", l.db.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package pgtest
+
+import (
+	"context"
+	"errors"
+)
+
+func newCoordinatedSource(context.Context, *config) (testDBSource, error) {
+	return nil, errors.New("coordinator is only supported on unix systems")
+}
@@ -0,0 +1,160 @@
+//go:build unix
+
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestCoordinatorSharedBetweenSupervisors(t *testing.T) {
+	// The coordinator's socket is created under the temp dir, so this
+	// prevents sharing a coordinator with any other test runs.
+	t.Setenv("TMPDIR", t.TempDir())
+
+	ctx := context.Background()
+
+	// Nothing listens on this port, so the coordinator can be started but
+	// can't create any test databases.
+	opts := []Option{
+		WithCoordinator(),
+		WithCoordinatorIdleTimeout(50 * time.Millisecond),
+		WithConnParams(connparams.WithHost("127.0.0.1"), connparams.WithPort(1)),
+	}
+
+	conf, err := newConfig(opts...)
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %s", err)
+	}
+
+	servers, err := newServerDSNs(conf.servers)
+	if err != nil {
+		t.Fatalf("unexpected error getting server configs: %s", err)
+	}
+
+	socketPath, err := coordinatorSocketPath(servers, conf.naming.prefix)
+	if err != nil {
+		t.Fatalf("unexpected error getting socket path: %s", err)
+	}
+
+	var sources []testDBSource
+	for i := 0; i < 2; i++ {
+		// The server isn't ready, so this skips the readiness check
+		// done by newCoordinatedSource.
+		source, err := connectCoordinatedSource(ctx, conf, servers)
+		if err != nil {
+			t.Fatalf("connectCoordinatedSource(...) %d = %s; want nil", i, err)
+		}
+		sources = append(sources, source)
+	}
+
+	for i, source := range sources {
+		if _, err := source.getTestDB(ctx, t.Name()); err == nil {
+			t.Errorf("source %d getTestDB(...) = nil error; want error from coordinator", i)
+		}
+	}
+
+	for i, source := range sources {
+		if err := source.shutdown(ctx); err != nil {
+			t.Errorf("source %d shutdown(...) = %s; want nil", i, err)
+		}
+	}
+
+	// The coordinator removes its socket once it exits.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("coordinator did not exit after idle timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCoordinatorSocketPathPrivateDir(t *testing.T) {
+	servers := []serverDSN{{Name: "default", RootDSN: "host=localhost"}}
+
+	testCases := map[string]struct {
+		// setup replaces the socket's directory once it has been
+		// created.
+		setup func(t *testing.T, dir string)
+	}{
+		"accessible_by_others": {
+			setup: func(t *testing.T, dir string) {
+				if err := os.Chmod(dir, 0o755); err != nil {
+					t.Fatalf("unexpected error changing mode: %s", err)
+				}
+			},
+		},
+		"symlink": {
+			setup: func(t *testing.T, dir string) {
+				if err := os.Remove(dir); err != nil {
+					t.Fatalf("unexpected error removing dir: %s", err)
+				}
+				if err := os.Symlink(t.TempDir(), dir); err != nil {
+					t.Fatalf("unexpected error creating symlink: %s", err)
+				}
+			},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+
+			socketPath, err := coordinatorSocketPath(servers, "")
+			if err != nil {
+				t.Fatalf("unexpected error getting socket path: %s", err)
+			}
+
+			testCase.setup(t, filepath.Dir(socketPath))
+
+			if _, err := coordinatorSocketPath(servers, ""); err == nil {
+				t.Errorf("coordinatorSocketPath(...) = nil error; want error")
+			}
+		})
+	}
+}
+
+func TestCoordinatorWithManagedServer(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSupervisor(ctx, WithCoordinator(), WithManagedServer(fakeManagedServer{}))
+	if err == nil {
+		_ = s.Shutdown(ctx)
+		t.Fatalf("NewSupervisor(...) = nil error; want error")
+	}
+}
+
+func TestCoordinatorWithOwnerHooks(t *testing.T) {
+	ctx := context.Background()
+	hook := func(context.Context, TestDB, *pgx.Conn) error { return nil }
+
+	for name, opt := range map[string]Option{
+		"OnCreate":          OnCreate(hook),
+		"OnDestroy":         OnDestroy(hook),
+		"ValidateOnAcquire": ValidateOnAcquire(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewSupervisor(ctx, WithCoordinator(), opt)
+			if err == nil {
+				_ = s.Shutdown(ctx)
+				t.Fatalf("NewSupervisor(...) = nil error; want error")
+			}
+		})
+	}
+}
+
+type fakeManagedServer struct{}
+
+func (fakeManagedServer) ConnParams() []connparams.Option { return nil }
+func (fakeManagedServer) Stop(context.Context) error      { return nil }
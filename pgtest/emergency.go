@@ -0,0 +1,108 @@
+package pgtest
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxTimeoutShutdownMargin is the most time before the -test.timeout
+	// deadline that the supervisor is shutdown.
+	maxTimeoutShutdownMargin = 10 * time.Second
+
+	// signalShutdownTimeout is how long to wait for the supervisor to
+	// shutdown after the test binary is interrupted.
+	signalShutdownTimeout = 10 * time.Second
+)
+
+// testTimeout returns the value of the -test.timeout flag, or zero if there is
+// no timeout.
+func testTimeout() time.Duration {
+	f := flag.Lookup("test.timeout")
+	if f == nil {
+		return 0
+	}
+
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return 0
+	}
+
+	timeout, _ := getter.Get().(time.Duration)
+	return timeout
+}
+
+// watchForEmergencies shuts down the supervisor if the test binary is
+// interrupted, or shortly before the test timeout panics, until stop is
+// called.
+func watchForEmergencies(supervisor Supervisor) (stop func()) {
+	// The flags are normally parsed by m.Run, but we need -test.timeout
+	// before then.
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
+	var (
+		timer    *time.Timer
+		timeoutC <-chan time.Time
+		margin   time.Duration
+	)
+
+	// The test binary panics once the timeout is reached, so the
+	// supervisor is shutdown with a margin before then.
+	if timeout := testTimeout(); timeout > 0 {
+		margin = min(timeout/10, maxTimeoutShutdownMargin)
+		timer = time.NewTimer(timeout - margin)
+		timeoutC = timer.C
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-timeoutC:
+			emergencyShutdown(supervisor, "test timeout is about to be reached", margin/2)
+		case sig := <-sigs:
+			emergencyShutdown(supervisor, "received "+sig.String(), signalShutdownTimeout)
+
+			// Notify stopped the signal from killing the test
+			// binary, so exit the way it would have.
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		}
+	}()
+
+	return func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// emergencyShutdown shuts down the supervisor while tests may still be
+// running, logging any test databases which couldn't be dropped. Test
+// databases still in use are dropped once they are released, or once the
+// timeout expires.
+func emergencyShutdown(supervisor Supervisor, reason string, timeout time.Duration) {
+	log.Printf("pgtest: %s, shutting down supervisor", reason)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := supervisor.Shutdown(ctx); err != nil {
+		log.Printf("ERROR: pgtest: could not clean up test databases: %s", err)
+	}
+}
@@ -0,0 +1,128 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A fakeSupervisor is a Supervisor which records when it is shutdown.
+type fakeSupervisor struct {
+	shutdown chan struct{}
+	stats    Stats
+}
+
+func (s *fakeSupervisor) GetTestDB(t testing.TB) TestDB {
+	t.Fatal("unexpected call to GetTestDB")
+	return nil
+}
+
+func (s *fakeSupervisor) Shutdown(ctx context.Context) error {
+	close(s.shutdown)
+	return errors.New("drop database pg_test_1: still in use")
+}
+
+func (s *fakeSupervisor) Stats() Stats {
+	return s.stats
+}
+
+func TestWatchForEmergenciesTimeout(t *testing.T) {
+	// The deadline for the test binary is set when the tests start, so
+	// changing the flag only affects watchForEmergencies.
+	original := flag.Lookup("test.timeout").Value.String()
+	if err := flag.Set("test.timeout", "500ms"); err != nil {
+		t.Fatalf("unexpected error setting -test.timeout: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = flag.Set("test.timeout", original)
+	})
+
+	supervisor := &fakeSupervisor{shutdown: make(chan struct{})}
+	stop := watchForEmergencies(supervisor)
+	defer stop()
+
+	select {
+	case <-supervisor.shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor was not shutdown before the test timeout")
+	}
+}
+
+func TestWatchForEmergenciesStopped(t *testing.T) {
+	original := flag.Lookup("test.timeout").Value.String()
+	if err := flag.Set("test.timeout", "100ms"); err != nil {
+		t.Fatalf("unexpected error setting -test.timeout: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = flag.Set("test.timeout", original)
+	})
+
+	supervisor := &fakeSupervisor{shutdown: make(chan struct{})}
+	stop := watchForEmergencies(supervisor)
+	stop()
+
+	select {
+	case <-supervisor.shutdown:
+		t.Fatal("supervisor was shutdown after watchForEmergencies was stopped")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestWatchForEmergenciesInterruptHelper is run in a separate process by
+// TestWatchForEmergenciesInterrupt, since the interrupt exits the process.
+func TestWatchForEmergenciesInterruptHelper(t *testing.T) {
+	if os.Getenv("PGTEST_INTERRUPT_HELPER") != "1" {
+		t.Skip("only run by TestWatchForEmergenciesInterrupt")
+	}
+
+	supervisor := &fakeSupervisor{shutdown: make(chan struct{})}
+	stop := watchForEmergencies(supervisor)
+	defer stop()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error finding process: %s", err)
+	}
+
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Fatalf("unexpected error sending interrupt: %s", err)
+	}
+
+	time.Sleep(5 * time.Second)
+	t.Fatal("process was not exited after interrupt")
+}
+
+func TestWatchForEmergenciesInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts can't be sent to a process on windows")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWatchForEmergenciesInterruptHelper$")
+	cmd.Env = append(os.Environ(), "PGTEST_INTERRUPT_HELPER=1")
+
+	out, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("helper process err = %v; want exit error (output:\n%s)", err, out)
+	}
+
+	if code := exitErr.ExitCode(); code != 130 {
+		t.Errorf("helper process exit code = %d; want 130 (output:\n%s)", code, out)
+	}
+
+	for _, expected := range []string{
+		"received interrupt, shutting down supervisor",
+		"could not clean up test databases: drop database pg_test_1: still in use",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("helper process output does not contain %q (output:\n%s)", expected, out)
+		}
+	}
+}
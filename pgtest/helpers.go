@@ -6,6 +6,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -16,8 +17,13 @@ type querier interface {
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
 }
 
-func dropDatabase(ctx context.Context, q querier, name string) error {
+func dropDatabase(ctx context.Context, q querier, name string, force bool) error {
 	query := fmt.Sprintf("DROP DATABASE %q;", name)
+	if force {
+		// Any connections to the database are terminated, rather than
+		// preventing it from being dropped.
+		query = fmt.Sprintf("DROP DATABASE %q WITH (FORCE);", name)
+	}
 	if _, err := q.Exec(ctx, query); err != nil {
 		return err
 	}
@@ -195,3 +201,96 @@ func getAllDatabases(ctx context.Context, q querier) ([]string, error) {
 
 	return dbNames, nil
 }
+
+// commentOnDatabase sets the comment on the named database.
+func commentOnDatabase(ctx context.Context, q querier, name, comment string) error {
+	// COMMENT doesn't take parameters, so the comment is quoted as a
+	// string literal.
+	query := fmt.Sprintf("COMMENT ON DATABASE %q IS %s;", name, quoteLiteral(comment))
+	if _, err := q.Exec(ctx, query); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// quoteLiteral quotes s as an escape string literal (E'...'), which is
+// interpreted the same way whatever standard_conforming_strings is set to.
+func quoteLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `''`)
+	return "E'" + s + "'"
+}
+
+type databaseComment struct {
+	name    string
+	comment string
+}
+
+// getAllDatabaseComments returns the comment on each database, which is empty
+// for databases without one.
+func getAllDatabaseComments(ctx context.Context, q querier) ([]databaseComment, error) {
+	rows, err := q.Query(ctx, `SELECT datname, coalesce(shobj_description(oid, 'pg_database'), '') FROM pg_database ORDER BY datname;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []databaseComment
+	for rows.Next() {
+		var c databaseComment
+		if err := rows.Scan(&c.name, &c.comment); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+type databaseInfo struct {
+	name    string
+	owner   string
+	size    int64
+	comment string
+
+	// modified is when the database's directory was last modified, or nil
+	// if the user isn't allowed to stat it.
+	modified *time.Time
+}
+
+// getDatabaseInfo returns the owner, size, comment and modification time of
+// each database whose name starts with prefix. The size is -1 if the user
+// can't connect to the database, since postgres won't report it. The
+// modification time is only known if the user may execute pg_stat_file (e.g.
+// superusers), and the database is in the default tablespace.
+func getDatabaseInfo(ctx context.Context, q querier, prefix string) ([]databaseInfo, error) {
+	rows, err := q.Query(ctx, `SELECT datname, pg_get_userbyid(datdba),
+	CASE WHEN has_database_privilege(oid, 'CONNECT') THEN pg_database_size(oid) ELSE -1 END,
+	coalesce(shobj_description(oid, 'pg_database'), ''),
+	CASE WHEN has_function_privilege('pg_stat_file(text, boolean)', 'EXECUTE') THEN (pg_stat_file('base/' || oid, true)).modification END
+FROM pg_database
+WHERE left(datname, length($1)) = $1
+ORDER BY datname;`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []databaseInfo
+	for rows.Next() {
+		var info databaseInfo
+		if err := rows.Scan(&info.name, &info.owner, &info.size, &info.comment, &info.modified); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
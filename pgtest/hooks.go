@@ -0,0 +1,98 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A TestDBHook is run at a stage in the life of a test database which isn't
+// tied to a test, with a connection to the test database.
+type TestDBHook func(ctx context.Context, db TestDB, conn *pgx.Conn) error
+
+// A TestHook is run at a stage in the life of a test database which is tied
+// to a test, with a connection to the test database.
+type TestHook func(ctx context.Context, t testing.TB, db TestDB, conn *pgx.Conn) error
+
+// hooks are the hooks run at each stage in the life of a test database.
+type hooks struct {
+	onCreate  []TestDBHook
+	onAcquire []TestHook
+	onRelease []TestHook
+	onDestroy []TestDBHook
+
+	// validate checks idle test databases, along with the invariants,
+	// before they are acquired.
+	validate   bool
+	invariants []TestDBHook
+}
+
+// withTestDBConn calls fn with a connection to db.
+func withTestDBConn(ctx context.Context, db TestDB, fn func(conn *pgx.Conn) error) error {
+	conn, err := pgx.Connect(ctx, db.DataSourceName())
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	return fn(conn)
+}
+
+func runTestDBHooks(ctx context.Context, hooks []TestDBHook, db TestDB) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	return withTestDBConn(ctx, db, func(conn *pgx.Conn) error {
+		for _, hook := range hooks {
+			if err := hook(ctx, db, conn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// validateTestDB checks that db still exists and can be connected to, and that
+// the invariants hold.
+func validateTestDB(ctx context.Context, db TestDB, invariants []TestDBHook) error {
+	return withTestDBConn(ctx, db, func(conn *pgx.Conn) error {
+		if err := conn.Ping(ctx); err != nil {
+			return fmt.Errorf("ping: %w", err)
+		}
+
+		for _, invariant := range invariants {
+			if err := invariant(ctx, db, conn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func runTestHooks(ctx context.Context, hooks []TestHook, t testing.TB, db TestDB) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	return withTestDBConn(ctx, db, func(conn *pgx.Conn) error {
+		for _, hook := range hooks {
+			if err := hook(ctx, t, db, conn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// hasOwnerHooks returns whether there are hooks which have to be run by
+// whatever creates and drops the test databases, which is the coordinator
+// process rather than the test binary when using WithCoordinator.
+func (h hooks) hasOwnerHooks() bool {
+	return len(h.onCreate) != 0 || len(h.onDestroy) != 0 || h.validate
+}
@@ -0,0 +1,196 @@
+package pgtest
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+// newUnreachableShardedFactory returns a shardedFactory whose test databases
+// can't be connected to, since nothing is listening on their port.
+func newUnreachableShardedFactory(t *testing.T) (*shardedFactory, pgxmock.PgxPoolIface) {
+	t.Helper()
+
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+
+	factory := newShardedFactory(PlaceLeastLoaded, []*testDBFactory{{
+		server: defaultServerName,
+		paramFactory: func(dbName string) connparams.ConnectionParams {
+			return connparams.New(dbName, connparams.WithHost("127.0.0.1"), connparams.WithPort(1))
+		},
+		rootDB: &rootDB{db: mockPool},
+		rng:    rand.New(new(sequentialRandSource)),
+	}})
+	t.Cleanup(func() {
+		factory.close()
+
+		if err := mockPool.ExpectationsWereMet(); err != nil {
+			t.Errorf("mock pool has unfulfilled expectations: %s", err)
+		}
+	})
+
+	return factory, mockPool
+}
+
+func TestSupervisorOnCreateFailure(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPool := newUnreachableShardedFactory(t)
+
+	var called bool
+	conf := &config{hooks: hooks{
+		onCreate: []TestDBHook{func(context.Context, TestDB, *pgx.Conn) error {
+			called = true
+			return nil
+		}},
+	}}
+	s := newSupervisor(conf, factory, nil)
+
+	// The test database is dropped, since it can't be set up by the
+	// hooks.
+	expectCreateDatabase(mockPool)
+	mockPool.
+		ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+		WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+
+	_, err := s.getTestDB(ctx, t.Name())
+	if err == nil {
+		t.Fatalf("s.getTestDB(...) = nil error; want error connecting to run OnCreate hooks")
+	}
+
+	if !strings.Contains(err.Error(), "OnCreate") {
+		t.Errorf("s.getTestDB(...) = %q; want error mentioning OnCreate", err)
+	}
+
+	if called {
+		t.Errorf("OnCreate hook called without a connection")
+	}
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+}
+
+func TestSupervisorOnDestroyFailure(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPool := newUnreachableShardedFactory(t)
+
+	// The hook itself isn't called, since the test database can't be
+	// connected to.
+	events, b := newTestEventLogger()
+	conf := &config{
+		hooks: hooks{
+			onDestroy: []TestDBHook{func(context.Context, TestDB, *pgx.Conn) error {
+				return nil
+			}},
+		},
+		events: events,
+	}
+	s := newSupervisor(conf, factory, nil)
+
+	expectCreateDatabase(mockPool)
+	lease, err := s.getTestDB(ctx, t.Name())
+	if err != nil {
+		t.Fatalf("unexpected error from s.getTestDB: %s", err)
+	}
+	lease.Release()
+
+	// The test database is still dropped if the hooks fail.
+	mockPool.
+		ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+		WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+
+	var cleanupErrors []loggedEvent
+	for _, event := range decodeLoggedEvents(t, b) {
+		if event.Event == eventCleanupError {
+			cleanupErrors = append(cleanupErrors, event)
+		}
+	}
+
+	if len(cleanupErrors) != 1 || !strings.Contains(cleanupErrors[0].Error, "connect") {
+		t.Errorf("logged cleanup errors = %+v; want one for the OnDestroy hook", cleanupErrors)
+	}
+
+	if destroyed := s.stats().Destroyed; destroyed != 1 {
+		t.Errorf("s.stats().Destroyed = %d; want 1", destroyed)
+	}
+}
+
+func TestSupervisorValidateOnAcquire(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPool := newUnreachableShardedFactory(t)
+	events, b := newTestEventLogger()
+
+	conf := &config{hooks: hooks{validate: true}, events: events}
+	s := newSupervisor(conf, factory, nil)
+
+	// Warnings are only logged as events when there is a logger.
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	expectCreateDatabase(mockPool)
+	first, err := s.getTestDB(ctx, t.Name())
+	if err != nil {
+		t.Fatalf("unexpected error from s.getTestDB: %s", err)
+	}
+	firstName := first.Data().Name()
+	first.Release()
+
+	// The idle test database can't be connected to, so it is dropped and
+	// replaced.
+	mockPool.
+		ExpectExec(`DROP DATABASE "` + firstName + `"`).
+		WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+	expectCreateDatabase(mockPool)
+
+	second, err := s.getTestDB(ctx, t.Name())
+	if err != nil {
+		t.Fatalf("unexpected error from s.getTestDB: %s", err)
+	}
+	if name := second.Data().Name(); name == firstName {
+		t.Errorf("got invalid test db %s again", name)
+	}
+	second.Release()
+
+	mockPool.
+		ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+		WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+
+	if invalidated := s.stats().Invalidated; invalidated != 1 {
+		t.Errorf("s.stats().Invalidated = %d; want 1", invalidated)
+	}
+
+	var invalid []loggedEvent
+	for _, event := range decodeLoggedEvents(t, b) {
+		if event.Event == eventInvalid {
+			invalid = append(invalid, event)
+		}
+	}
+
+	if len(invalid) != 1 || invalid[0].DB != firstName {
+		t.Errorf("logged invalid events = %+v; want one for %s", invalid, firstName)
+	}
+
+	if logged.Len() != 0 {
+		t.Errorf("logged with the log package as well as the logger: %q", logged.String())
+	}
+}
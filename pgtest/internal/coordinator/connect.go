@@ -0,0 +1,105 @@
+//go:build unix
+
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// pollInterval is how often Connect polls for a lock or a coordinator which is
+// starting up.
+const pollInterval = 10 * time.Millisecond
+
+// Connect connects to the coordinator listening on socketPath, calling start to
+// start one if none is running. Concurrent callers, including those in
+// different processes, are serialized by a lock file next to the socket so
+// that only one coordinator is started.
+//
+// The start function should start the coordinator in the background, which is
+// expected to listen on socketPath. Connect then waits for it to accept
+// connections until ctx is done.
+func Connect(ctx context.Context, socketPath string, start func() error) (*Client, error) {
+	if c, err := Dial(ctx, socketPath); err == nil {
+		return c, nil
+	}
+
+	unlock, err := lockFile(ctx, socketPath+".lock")
+	if err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+	defer unlock()
+
+	// Another process may have started a coordinator while we were
+	// waiting for the lock.
+	if c, err := Dial(ctx, socketPath); err == nil {
+		return c, nil
+	}
+
+	// Any existing socket belongs to a coordinator which has exited
+	// without cleaning up (or is exiting), which would prevent a new one
+	// from listening.
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	if err := start(); err != nil {
+		return nil, fmt.Errorf("start coordinator: %w", err)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		c, err := Dial(ctx, socketPath)
+		if err == nil {
+			return c, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for coordinator: %w (last error: %s)", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// lockFile acquires an exclusive lock on the named file, creating it if
+// necessary, and returns a function to release the lock.
+func lockFile(ctx context.Context, name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
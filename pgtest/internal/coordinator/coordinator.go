@@ -0,0 +1,319 @@
+//go:build unix
+
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serviceName is the name the coordinator is registered under with net/rpc.
+const serviceName = "Coordinator"
+
+// A Lease is a database acquired from the pool.
+type Lease interface {
+	// Name returns the name of the database.
+	Name() string
+
+	// Server returns the name of the server the database lives on.
+	Server() string
+
+	// Release releases the database back to the pool.
+	Release()
+
+	// Hijack removes the database from the pool, so it will not be re-used
+	// or dropped.
+	Hijack()
+}
+
+// A Pool is the pool of databases shared by the coordinator.
+type Pool interface {
+	Acquire(ctx context.Context, args AcquireArgs) (Lease, error)
+	Close(ctx context.Context) error
+}
+
+// AcquireArgs are the arguments to acquire a database.
+type AcquireArgs struct {
+	// Test is the name of the test the database is being acquired for.
+	Test string
+
+	// Server is the name of the server to acquire the database on. If
+	// empty, the database can be on any server.
+	Server string
+}
+
+// AcquireReply describes a database leased to a client.
+type AcquireReply struct {
+	LeaseID uint64
+	Name    string
+	Server  string
+}
+
+// LeaseArgs identify a database leased to a client.
+type LeaseArgs struct {
+	LeaseID uint64
+}
+
+// A server serves a pool to any number of clients.
+type server struct {
+	ctx  context.Context
+	pool Pool
+
+	nextLeaseID atomic.Uint64
+
+	mut         sync.Mutex
+	listener    net.Listener
+	sessions    int
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+}
+
+// Serve serves the pool on the listener until no clients have been connected
+// for idleTimeout (including when no clients ever connect), then closes the
+// pool.
+func Serve(ctx context.Context, l net.Listener, pool Pool, idleTimeout time.Duration) error {
+	s := &server{
+		ctx:         ctx,
+		pool:        pool,
+		listener:    l,
+		idleTimeout: idleTimeout,
+	}
+
+	s.mut.Lock()
+	s.idleTimer = time.AfterFunc(idleTimeout, s.handleIdle)
+	s.mut.Unlock()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+
+		s.mut.Lock()
+		s.sessions++
+		s.idleTimer.Stop()
+		s.mut.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+
+	// The listener is only closed once there are no sessions, but a
+	// client may have connected in the meantime.
+	wg.Wait()
+
+	if err := pool.Close(ctx); err != nil {
+		return fmt.Errorf("close pool: %w", err)
+	}
+
+	return nil
+}
+
+// handleIdle closes the listener once no clients are connected. A client may
+// have connected without being accepted yet, in which case its connection is
+// closed along with the listener. Its first call (see Dial) fails, so it can
+// start a new coordinator.
+func (s *server) handleIdle() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.sessions == 0 {
+		s.listener.Close()
+	}
+}
+
+func (s *server) serveConn(conn net.Conn) {
+	sess := &session{
+		server: s,
+		leases: make(map[uint64]Lease),
+	}
+
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName(serviceName, sess); err != nil {
+		panic(err)
+	}
+
+	rpcServer.ServeConn(conn)
+
+	// The client disconnected, so any databases it didn't release are
+	// returned to the pool. They will be reset before being re-used.
+	sess.releaseAll()
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.sessions--
+	if s.sessions == 0 {
+		s.idleTimer.Reset(s.idleTimeout)
+	}
+}
+
+// A session is the state of a single connected client. Its exported methods
+// are served through net/rpc.
+type session struct {
+	server *server
+
+	mut    sync.Mutex
+	leases map[uint64]Lease
+}
+
+// Ping does nothing, and is called by clients to check that their connection
+// was accepted.
+func (sess *session) Ping(_ struct{}, _ *struct{}) error {
+	return nil
+}
+
+// Acquire acquires a database from the pool.
+func (sess *session) Acquire(args AcquireArgs, reply *AcquireReply) error {
+	lease, err := sess.server.pool.Acquire(sess.server.ctx, args)
+	if err != nil {
+		return err
+	}
+
+	id := sess.server.nextLeaseID.Add(1)
+
+	sess.mut.Lock()
+	sess.leases[id] = lease
+	sess.mut.Unlock()
+
+	*reply = AcquireReply{LeaseID: id, Name: lease.Name(), Server: lease.Server()}
+	return nil
+}
+
+// Release releases a leased database back to the pool.
+func (sess *session) Release(args LeaseArgs, _ *struct{}) error {
+	lease, err := sess.takeLease(args.LeaseID)
+	if err != nil {
+		return err
+	}
+
+	lease.Release()
+	return nil
+}
+
+// Hijack removes a leased database from the pool.
+func (sess *session) Hijack(args LeaseArgs, _ *struct{}) error {
+	lease, err := sess.takeLease(args.LeaseID)
+	if err != nil {
+		return err
+	}
+
+	lease.Hijack()
+	return nil
+}
+
+func (sess *session) takeLease(id uint64) (Lease, error) {
+	sess.mut.Lock()
+	defer sess.mut.Unlock()
+
+	lease, ok := sess.leases[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown lease %d", id)
+	}
+
+	delete(sess.leases, id)
+	return lease, nil
+}
+
+func (sess *session) releaseAll() {
+	sess.mut.Lock()
+	defer sess.mut.Unlock()
+
+	for id, lease := range sess.leases {
+		lease.Release()
+		delete(sess.leases, id)
+	}
+}
+
+// A Client is a connection to a coordinator.
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the coordinator listening on socketPath. It checks that
+// the coordinator accepted the connection, since a coordinator which is
+// exiting may have closed its listener after the connection was made.
+func Dial(ctx context.Context, socketPath string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{rpc: rpc.NewClient(conn)}
+	if err := c.call(ctx, "Ping", struct{}{}, new(struct{})); err != nil {
+		c.rpc.Close()
+		return nil, fmt.Errorf("ping: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *Client) call(ctx context.Context, method string, args, reply any) error {
+	return c.callOrElse(ctx, method, args, reply, nil)
+}
+
+// callOrElse is like call, but if ctx is done before the call returns, late is
+// called in the background once it does (if it succeeds).
+func (c *Client) callOrElse(ctx context.Context, method string, args, reply any, late func()) error {
+	call := c.rpc.Go(serviceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		if late != nil {
+			go func() {
+				if call := <-call.Done; call.Error == nil {
+					late()
+				}
+			}()
+		}
+		return ctx.Err()
+	}
+}
+
+// Acquire leases a database from the coordinator. If ctx is done before the
+// database is leased, it is released once it is.
+func (c *Client) Acquire(ctx context.Context, args AcquireArgs) (AcquireReply, error) {
+	reply := new(AcquireReply)
+	err := c.callOrElse(ctx, "Acquire", args, reply, func() {
+		// There's nobody to report the error to, and the database
+		// is released anyway once the client disconnects.
+		_ = c.Release(context.Background(), reply.LeaseID)
+	})
+	if err != nil {
+		return AcquireReply{}, err
+	}
+
+	return *reply, nil
+}
+
+// Release releases a leased database back to the coordinator's pool.
+func (c *Client) Release(ctx context.Context, leaseID uint64) error {
+	return c.call(ctx, "Release", LeaseArgs{LeaseID: leaseID}, new(struct{}))
+}
+
+// Hijack removes a leased database from the coordinator's pool.
+func (c *Client) Hijack(ctx context.Context, leaseID uint64) error {
+	return c.call(ctx, "Hijack", LeaseArgs{LeaseID: leaseID}, new(struct{}))
+}
+
+// Close closes the connection to the coordinator. Any databases which are
+// still leased are released.
+func (c *Client) Close() error {
+	if err := c.rpc.Close(); err != nil && !errors.Is(err, rpc.ErrShutdown) {
+		return err
+	}
+
+	return nil
+}
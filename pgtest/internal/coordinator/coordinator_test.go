@@ -0,0 +1,414 @@
+//go:build unix
+
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeLease struct {
+	pool *fakePool
+	name string
+}
+
+func (l *fakeLease) Name() string   { return l.name }
+func (l *fakeLease) Server() string { return "default" }
+func (l *fakeLease) Release()       { l.pool.release(l.name) }
+func (l *fakeLease) Hijack()        { l.pool.hijack(l.name) }
+
+// A fakePool is a Pool which just tracks the state of each database.
+type fakePool struct {
+	mut      sync.Mutex
+	created  int
+	idle     []string
+	acquired map[string]bool
+	hijacked map[string]bool
+	closed   bool
+
+	// block, if set, is received from before each database is acquired.
+	block chan struct{}
+}
+
+func newFakePool() *fakePool {
+	return &fakePool{
+		acquired: make(map[string]bool),
+		hijacked: make(map[string]bool),
+	}
+}
+
+func (p *fakePool) Acquire(_ context.Context, _ AcquireArgs) (Lease, error) {
+	if p.block != nil {
+		<-p.block
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	var name string
+	if n := len(p.idle); n != 0 {
+		name = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	} else {
+		p.created++
+		name = fmt.Sprintf("db_%d", p.created)
+	}
+
+	p.acquired[name] = true
+	return &fakeLease{pool: p, name: name}, nil
+}
+
+func (p *fakePool) release(name string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	delete(p.acquired, name)
+	p.idle = append(p.idle, name)
+}
+
+func (p *fakePool) hijack(name string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	delete(p.acquired, name)
+	p.hijacked[name] = true
+}
+
+func (p *fakePool) Close(context.Context) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	p.closed = true
+	return nil
+}
+
+func (p *fakePool) state() (acquired, idle int, closed bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	return len(p.acquired), len(p.idle), p.closed
+}
+
+// serve starts serving the pool on a new socket, returning the socket path and
+// a channel which receives the result of Serve.
+func serve(t *testing.T, pool Pool, idleTimeout time.Duration) (string, <-chan error) {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "coord.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("unexpected error listening on %s: %s", socketPath, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(context.Background(), l, pool, idleTimeout)
+	}()
+
+	return socketPath, done
+}
+
+func waitServeDone(t *testing.T, done <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve(...) = %s; want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Serve to return")
+	}
+}
+
+func TestCoordinatorShareDatabases(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		pool = newFakePool()
+	)
+
+	socketPath, done := serve(t, pool, 50*time.Millisecond)
+
+	client1, err := Dial(ctx, socketPath)
+	if err != nil {
+		t.Fatalf("dial client1: %s", err)
+	}
+
+	client2, err := Dial(ctx, socketPath)
+	if err != nil {
+		t.Fatalf("dial client2: %s", err)
+	}
+
+	lease1, err := client1.Acquire(ctx, AcquireArgs{Test: "TestOne"})
+	if err != nil {
+		t.Fatalf("client1 acquire: %s", err)
+	}
+
+	if err := client1.Release(ctx, lease1.LeaseID); err != nil {
+		t.Fatalf("client1 release: %s", err)
+	}
+
+	// The database released by client1 should be re-used by client2.
+	lease2, err := client2.Acquire(ctx, AcquireArgs{Test: "TestTwo"})
+	if err != nil {
+		t.Fatalf("client2 acquire: %s", err)
+	}
+
+	if lease2.Name != lease1.Name {
+		t.Errorf("client2 acquired %q; want %q", lease2.Name, lease1.Name)
+	}
+
+	if lease2.LeaseID == lease1.LeaseID {
+		t.Errorf("client2 lease ID = %d; want different from client1 lease ID", lease2.LeaseID)
+	}
+
+	// Leases are scoped to the client which acquired them.
+	if err := client1.Release(ctx, lease2.LeaseID); err == nil {
+		t.Errorf("client1 release of client2 lease = nil; want error")
+	}
+
+	if err := client2.Hijack(ctx, lease2.LeaseID); err != nil {
+		t.Fatalf("client2 hijack: %s", err)
+	}
+
+	for _, c := range []*Client{client1, client2} {
+		if err := c.Close(); err != nil {
+			t.Errorf("close client: %s", err)
+		}
+	}
+
+	waitServeDone(t, done)
+
+	if acquired, _, closed := pool.state(); acquired != 0 || !closed {
+		t.Errorf("after serve pool state = (acquired=%d, closed=%t); want (acquired=0, closed=true)", acquired, closed)
+	}
+}
+
+func TestCoordinatorReleasesOnDisconnect(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		pool = newFakePool()
+	)
+
+	socketPath, done := serve(t, pool, 50*time.Millisecond)
+
+	client, err := Dial(ctx, socketPath)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Acquire(ctx, AcquireArgs{}); err != nil {
+			t.Fatalf("acquire %d: %s", i, err)
+		}
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	waitServeDone(t, done)
+
+	if acquired, idle, _ := pool.state(); acquired != 0 || idle != 3 {
+		t.Errorf("after disconnect pool state = (acquired=%d, idle=%d); want (acquired=0, idle=3)", acquired, idle)
+	}
+}
+
+func TestCoordinatorStaysUpWhileClientConnected(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		pool = newFakePool()
+	)
+
+	socketPath, done := serve(t, pool, 20*time.Millisecond)
+
+	client, err := Dial(ctx, socketPath)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := client.Acquire(ctx, AcquireArgs{}); err != nil {
+		t.Fatalf("acquire after idle timeout: %s", err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	waitServeDone(t, done)
+}
+
+func TestConnectStartsOnce(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		pool       = newFakePool()
+		socketPath = filepath.Join(t.TempDir(), "coord.sock")
+		starts     atomic.Int32
+		done       = make(chan error, 1)
+	)
+
+	start := func() error {
+		starts.Add(1)
+
+		l, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			done <- Serve(ctx, l, pool, 50*time.Millisecond)
+		}()
+		return nil
+	}
+
+	var (
+		wg      sync.WaitGroup
+		clients = make([]*Client, 8)
+		errs    = make([]error, len(clients))
+	)
+
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			connectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			clients[i], errs[i] = Connect(connectCtx, socketPath, start)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("connect %d: %s", i, err)
+		}
+	}
+
+	if n := starts.Load(); n != 1 {
+		t.Errorf("coordinator started %d times; want 1", n)
+	}
+
+	for _, c := range clients {
+		if _, err := c.Acquire(ctx, AcquireArgs{}); err != nil {
+			t.Errorf("acquire: %s", err)
+		}
+		c.Close()
+	}
+
+	waitServeDone(t, done)
+}
+
+func TestConnectReplacesExitingCoordinator(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		pool       = newFakePool()
+		socketPath = filepath.Join(t.TempDir(), "coord.sock")
+		starts     atomic.Int32
+		done       = make(chan error, 1)
+	)
+
+	// The exiting coordinator closes connections without serving them,
+	// like a coordinator which closed its listener after the connections
+	// were made.
+	exiting, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("unexpected error listening on %s: %s", socketPath, err)
+	}
+	defer exiting.Close()
+
+	go func() {
+		for {
+			conn, err := exiting.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	start := func() error {
+		starts.Add(1)
+
+		l, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			done <- Serve(ctx, l, pool, 50*time.Millisecond)
+		}()
+		return nil
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	client, err := Connect(connectCtx, socketPath, start)
+	if err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+
+	if n := starts.Load(); n != 1 {
+		t.Errorf("coordinator started %d times; want 1", n)
+	}
+
+	if _, err := client.Acquire(ctx, AcquireArgs{}); err != nil {
+		t.Errorf("acquire: %s", err)
+	}
+	client.Close()
+
+	waitServeDone(t, done)
+}
+
+func TestClientAcquireCancelled(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		pool = newFakePool()
+	)
+	pool.block = make(chan struct{})
+
+	socketPath, done := serve(t, pool, 50*time.Millisecond)
+
+	client, err := Dial(ctx, socketPath)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Acquire(acquireCtx, AcquireArgs{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire: err = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// The database is leased after the client gave up on it, so it is
+	// released rather than leaked until the client disconnects.
+	close(pool.block)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if acquired, idle, _ := pool.state(); acquired == 0 && idle == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("late lease not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	waitServeDone(t, done)
+}
@@ -0,0 +1,9 @@
+// Package coordinator shares a pool of test databases between multiple
+// processes (e.g. the test binaries for each package run by 'go test ./...').
+//
+// A single coordinator process owns the pool, and serves it over a unix domain
+// socket. Clients lease databases from the coordinator, which are returned to
+// the pool when released or when the client disconnects. Once no clients have
+// been connected for an idle timeout, the coordinator closes the pool and
+// exits.
+package coordinator
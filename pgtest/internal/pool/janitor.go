@@ -0,0 +1,137 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// maxJanitorInterval is the longest time between the janitor checking
+	// for idle resources to destroy.
+	maxJanitorInterval = time.Second
+
+	// janitorDestroyTimeout is how long the janitor waits for the idle
+	// resources it evicts to be destroyed.
+	janitorDestroyTimeout = 30 * time.Second
+)
+
+// janitorInterval returns how often the janitor checks for idle resources to
+// destroy, which is often enough to destroy resources shortly after they
+// reach the IdleTimeout.
+func (conf *ResourceConf[T]) janitorInterval() time.Duration {
+	interval := maxJanitorInterval
+	if conf.IdleTimeout > 0 {
+		interval = min(interval, conf.IdleTimeout/2)
+	}
+
+	return interval
+}
+
+// runJanitor periodically destroys idle resources which exceed the IdleTimeout
+// or MaxIdle, until stop is closed.
+func (pool *Pool[T]) runJanitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			pool.evictIdle(now)
+		}
+	}
+}
+
+// evictIdle destroys the idle resources which exceed the IdleTimeout or
+// MaxIdle as of now. The resources are destroyed without holding the lock,
+// since that may be slow.
+func (pool *Pool[T]) evictIdle(now time.Time) {
+	pool.mut.Lock()
+	evicted := pool.takeEvictedLocked(now)
+	pool.mut.Unlock()
+
+	if len(evicted) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), janitorDestroyTimeout)
+	defer cancel()
+
+	errs := make([]error, len(evicted))
+	for i, resource := range evicted {
+		errs[i] = pool.resourceConf.Destroy(ctx, resource.data)
+	}
+
+	pool.mut.Lock()
+	defer pool.mut.Unlock()
+
+	for i, resource := range evicted {
+		pool.pending--
+
+		switch {
+		case errs[i] == nil:
+			resource.state = resourceStateDestroyed
+			pool.stats.Destroyed++
+			pool.stats.Evicted++
+		case pool.destroyed:
+			// Close gave up waiting for the resource, so it is no
+			// longer owned by the pool.
+			resource.state = resourceStateDestroyed
+		default:
+			// The resource is still idle, so destroying it is
+			// tried again later (or by Close).
+			resource.state = resourceStateIdle
+			pool.owned.enqueue(resource)
+			pool.pushIdleLocked(resource)
+		}
+	}
+
+	pool.notifyDrainedLocked()
+}
+
+// takeEvictedLocked takes the idle resources which exceed the IdleTimeout or
+// MaxIdle as of now from the pool, leaving them pending until they are
+// destroyed.
+func (pool *Pool[T]) takeEvictedLocked(now time.Time) []*Resource[T] {
+	// Close destroys all the resources anyway.
+	if pool.closed {
+		return nil
+	}
+
+	var (
+		conf    = pool.resourceConf
+		kept    []*Resource[T]
+		evicted []*Resource[T]
+	)
+
+	// The idle stack is popped from the most recently released resource,
+	// so the resources which have been idle the longest are evicted first
+	// once MaxIdle is reached.
+	for {
+		resource, _, ok := pool.popIdleLocked(nil)
+		if !ok {
+			break
+		}
+
+		expired := conf.IdleTimeout > 0 && now.Sub(resource.idleSince) >= conf.IdleTimeout
+		surplus := conf.MaxIdle > 0 && len(kept) >= conf.MaxIdle
+		if !expired && !surplus {
+			kept = append(kept, resource)
+			continue
+		}
+
+		resource.state = resourceStatePending
+		pool.removeOwnedResourceLocked(resource)
+		pool.pending++
+		evicted = append(evicted, resource)
+	}
+
+	// The kept resources are pushed back from the oldest, to preserve
+	// their order.
+	for i := len(kept) - 1; i >= 0; i-- {
+		pool.pushIdleLocked(kept[i])
+	}
+
+	return evicted
+}
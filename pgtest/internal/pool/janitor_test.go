@@ -0,0 +1,236 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolEvictIdle(t *testing.T) {
+	// The resources are released in order, and have been idle for the
+	// corresponding duration.
+	idleFor := []time.Duration{3 * time.Minute, 2 * time.Minute, time.Minute}
+
+	testCases := map[string]struct {
+		idleTimeout   time.Duration
+		maxIdle       int
+		expectedKept  []int
+		expectedStats Stats
+	}{
+		"idle_timeout": {
+			idleTimeout:  90 * time.Second,
+			expectedKept: []int{2},
+		},
+		"max_idle": {
+			maxIdle:      2,
+			expectedKept: []int{1, 2},
+		},
+		"idle_timeout_and_max_idle": {
+			idleTimeout:  150 * time.Second,
+			maxIdle:      1,
+			expectedKept: []int{2},
+		},
+		"nothing_to_evict": {
+			idleTimeout:  time.Hour,
+			maxIdle:      3,
+			expectedKept: []int{0, 1, 2},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			var (
+				ctx    = context.Background()
+				now    = time.Now()
+				counts = new(resourceCounts)
+			)
+
+			conf := countedResourceConf(counts, fakeResourceConf)
+			conf.IdleTimeout = testCase.idleTimeout
+			conf.MaxIdle = testCase.maxIdle
+
+			// The pool is created without a janitor, so evictIdle is
+			// only called by the test.
+			pool := &Pool[*fakeResource]{resourceConf: conf}
+
+			resources := make([]*Resource[*fakeResource], len(idleFor))
+			for i := range resources {
+				r, err := pool.Acquire(ctx)
+				if err != nil {
+					t.Fatalf("acquire: %s", err)
+				}
+				resources[i] = r
+			}
+
+			data := make([]*fakeResource, len(resources))
+			for i, r := range resources {
+				data[i] = r.Data()
+				r.Release()
+				r.idleSince = now.Add(-idleFor[i])
+			}
+
+			pool.evictIdle(now)
+
+			kept := make(map[int]bool)
+			for _, i := range testCase.expectedKept {
+				kept[i] = true
+			}
+
+			for i, d := range data {
+				if err := d.Valid(); kept[i] && err != nil {
+					t.Errorf("resource %d was evicted; want kept", i)
+				} else if !kept[i] && err == nil {
+					t.Errorf("resource %d was kept; want evicted", i)
+				}
+			}
+
+			expectedEvicted := len(idleFor) - len(testCase.expectedKept)
+			if evicted := pool.Stats().Evicted; evicted != expectedEvicted {
+				t.Errorf("pool.Stats().Evicted = %d; want %d", evicted, expectedEvicted)
+			}
+
+			// The invariants still hold: the kept resources are
+			// both idle and owned, most recently released first.
+			var expectedIdle []*Resource[*fakeResource]
+			for i := len(testCase.expectedKept) - 1; i >= 0; i-- {
+				expectedIdle = append(expectedIdle, resources[testCase.expectedKept[i]])
+			}
+			if idle := pool.idle.items(); !equalResources(idle, expectedIdle) {
+				t.Errorf("pool.idle.items() = %v; want %v", idle, expectedIdle)
+			}
+			if owned := pool.owned.items(); len(owned) != len(testCase.expectedKept) {
+				t.Errorf("pool owns %d resources; want %d", len(owned), len(testCase.expectedKept))
+			}
+
+			if err := pool.Close(ctx); err != nil {
+				t.Fatalf("close: %s", err)
+			}
+			counts.assertCreatedEqualsDestroyed(t)
+		})
+	}
+}
+
+func equalResources[T any](a, b []*Resource[T]) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestPoolJanitor(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		counts = new(resourceCounts)
+	)
+
+	conf := countedResourceConf(counts, fakeResourceConf)
+	conf.IdleTimeout = 20 * time.Millisecond
+	pool := New(conf)
+
+	r, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	data := r.Data()
+	r.Release()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for data.Valid() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle resource not destroyed by the janitor")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := pool.Close(ctx); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	counts.assertCreatedEqualsDestroyed(t)
+}
+
+func TestPoolEvictIdleWithoutLock(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		destroying = make(chan struct{})
+		unblock    = make(chan struct{})
+		fail       atomic.Bool
+	)
+
+	conf := &ResourceConf[*fakeResource]{
+		Create: fakeResourceConf.Create,
+		Destroy: func(ctx context.Context, x *fakeResource) error {
+			select {
+			case destroying <- struct{}{}:
+				<-unblock
+			default:
+			}
+			if fail.Load() {
+				return errors.New("destroy failed")
+			}
+			return fakeResourceConf.Destroy(ctx, x)
+		},
+		IdleTimeout: time.Minute,
+	}
+	pool := &Pool[*fakeResource]{resourceConf: conf}
+
+	r, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	r.Release()
+
+	fail.Store(true)
+	evicted := make(chan struct{})
+	go func() {
+		defer close(evicted)
+		pool.evictIdle(time.Now().Add(time.Hour))
+	}()
+	<-destroying
+
+	// The pool can be used while the idle resource is being destroyed.
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		r, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Errorf("acquire while evicting: %s", err)
+			return
+		}
+		r.Release()
+	}()
+
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("acquire blocked while an idle resource was being evicted")
+	}
+
+	close(unblock)
+	<-evicted
+
+	// The resource which couldn't be destroyed is still idle, along with
+	// the one created while it was being evicted.
+	if idle := pool.idle.len(); idle != 2 {
+		t.Errorf("pool has %d idle resources; want 2", idle)
+	}
+	if stats := pool.Stats(); stats.Evicted != 0 || stats.Destroyed != 0 {
+		t.Errorf("pool.Stats() = %+v; want nothing evicted or destroyed", stats)
+	}
+
+	fail.Store(false)
+	if err := pool.Close(ctx); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	if destroyed := pool.Stats().Destroyed; destroyed != 2 {
+		t.Errorf("pool.Stats().Destroyed = %d; want 2", destroyed)
+	}
+}
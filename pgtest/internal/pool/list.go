@@ -3,8 +3,9 @@ package pool
 import "fmt"
 
 type listNode[T any] struct {
-	val T
-	tl  *listNode[T]
+	val  T
+	prev *listNode[T]
+	tl   *listNode[T]
 }
 
 func (node *listNode[T]) GoString() string {
@@ -15,15 +16,6 @@ func (node *listNode[T]) GoString() string {
 	return fmt.Sprintf("&listNode{val: %#v, tl: %#v}", node.val, node.tl)
 }
 
-func (node *listNode[T]) pushBack(v T) {
-	if node.tl == nil {
-		node.tl = &listNode[T]{val: v}
-		return
-	}
-
-	node.tl.pushBack(v)
-}
-
 func (node *listNode[T]) items() []T {
 	var (
 		vs  []T
@@ -38,21 +30,38 @@ func (node *listNode[T]) items() []T {
 	return vs
 }
 
+// A queue is a doubly linked list, along with an index of the node holding
+// each value so any value can be removed in constant time. As a result each
+// value can only be in the queue once.
 type queue[T comparable] struct {
-	hd *listNode[T]
+	hd    *listNode[T]
+	last  *listNode[T]
+	nodes map[T]*listNode[T]
 }
 
 func (q *queue[T]) empty() bool {
 	return q.hd == nil
 }
 
+// enqueue adds v to the back of the queue. It panics if v is already in the
+// queue.
 func (q *queue[T]) enqueue(v T) {
-	if q.hd == nil {
-		q.hd = &listNode[T]{val: v}
-		return
+	if _, ok := q.nodes[v]; ok {
+		panic(internalVariantBroken(fmt.Sprintf("value enqueued twice: %v", v)))
+	}
+
+	node := &listNode[T]{val: v, prev: q.last}
+	if q.last == nil {
+		q.hd = node
+	} else {
+		q.last.tl = node
 	}
+	q.last = node
 
-	q.hd.pushBack(v)
+	if q.nodes == nil {
+		q.nodes = make(map[T]*listNode[T])
+	}
+	q.nodes[v] = node
 }
 
 func (q *queue[T]) dequeue() (T, bool) {
@@ -62,38 +71,39 @@ func (q *queue[T]) dequeue() (T, bool) {
 	}
 
 	dequeued := q.hd.val
-	q.hd = q.hd.tl
+	q.unlink(q.hd)
 	return dequeued, true
 }
 
 func (q *queue[T]) remove(v T) bool {
-	var (
-		prev = q.hd
-		cur  = prev
-	)
-
-	for cur != nil {
-		if cur.val == v {
-			break
-		}
-
-		prev = cur
-		cur = cur.tl
+	node, ok := q.nodes[v]
+	if !ok {
+		return false
 	}
 
-	if cur == nil {
-		return false
+	q.unlink(node)
+	return true
+}
+
+func (q *queue[T]) unlink(node *listNode[T]) {
+	if node.prev == nil {
+		q.hd = node.tl
+	} else {
+		node.prev.tl = node.tl
 	}
 
-	if cur == q.hd {
-		q.hd = cur.tl
-		return true
+	if node.tl == nil {
+		q.last = node.prev
+	} else {
+		node.tl.prev = node.prev
 	}
 
-	prev.tl = cur.tl
-	cur.tl = nil
+	node.prev, node.tl = nil, nil
+	delete(q.nodes, node.val)
+}
 
-	return true
+func (q *queue[T]) len() int {
+	return len(q.nodes)
 }
 
 func (q *queue[T]) items() []T {
@@ -104,32 +114,48 @@ func (q queue[T]) GoString() string {
 	return fmt.Sprintf("&queue{hd: %#v}", q.hd)
 }
 
-type stack[T any] struct {
-	hd *listNode[T]
+// A stack is a linked list with the same index as a queue, so any value can be
+// removed in constant time, and each value can only be on the stack once.
+type stack[T comparable] struct {
+	q queue[T]
 }
 
 func (st *stack[T]) empty() bool {
-	return st.hd == nil
+	return st.q.empty()
 }
 
+// push adds v to the top of the stack. It panics if v is already on the stack.
 func (st *stack[T]) push(v T) {
-	st.hd = &listNode[T]{
-		val: v,
-		tl:  st.hd,
+	if _, ok := st.q.nodes[v]; ok {
+		panic(internalVariantBroken(fmt.Sprintf("value pushed twice: %v", v)))
+	}
+
+	node := &listNode[T]{val: v, tl: st.q.hd}
+	if st.q.hd == nil {
+		st.q.last = node
+	} else {
+		st.q.hd.prev = node
 	}
+	st.q.hd = node
+
+	if st.q.nodes == nil {
+		st.q.nodes = make(map[T]*listNode[T])
+	}
+	st.q.nodes[v] = node
 }
 
 func (st *stack[T]) pop() (T, bool) {
-	if st.hd == nil {
-		var nothing T
-		return nothing, false
-	}
+	return st.q.dequeue()
+}
+
+func (st *stack[T]) remove(v T) bool {
+	return st.q.remove(v)
+}
 
-	popped := st.hd.val
-	st.hd = st.hd.tl
-	return popped, true
+func (st *stack[T]) len() int {
+	return st.q.len()
 }
 
 func (st *stack[T]) items() []T {
-	return st.hd.items()
+	return st.q.items()
 }
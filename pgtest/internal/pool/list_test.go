@@ -145,10 +145,33 @@ func TestQueueRemoves(t *testing.T) {
 			if !slices.Equal(finalItems, expectedFinalItems) {
 				t.Errorf("after q.remove(%q) q.items() = %q; want %q", testCase.toRemove, finalItems, expectedFinalItems)
 			}
+
+			// The back of the queue is kept track of after removing.
+			q.enqueue("last")
+			expectedFinalItems = append(slices.Clone(expectedFinalItems), "last")
+			if finalItems := q.items(); !slices.Equal(finalItems, expectedFinalItems) {
+				t.Errorf("after q.enqueue(%q) q.items() = %q; want %q", "last", finalItems, expectedFinalItems)
+			}
+			if q.len() != len(expectedFinalItems) {
+				t.Errorf("q.len() = %d; want %d", q.len(), len(expectedFinalItems))
+			}
 		})
 	}
 }
 
+func TestQueueEnqueueTwice(t *testing.T) {
+	q := new(queue[string])
+	q.enqueue("foo")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("enqueueing a value already in the queue didn't panic")
+		}
+	}()
+
+	q.enqueue("foo")
+}
+
 type qContainer[T comparable] struct{ q queue[T] }
 
 func TestQueueDequeueAll(t *testing.T) {
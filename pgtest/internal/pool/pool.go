@@ -3,16 +3,29 @@ package pool
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // ResourceConf describes the configuration for dealing with resources owned by
 // the pool.
 type ResourceConf[T any] struct {
 	Create  func(context.Context) (T, error)
-	Destroy func(T) error
+	Destroy func(context.Context, T) error
+
+	// Validate checks that an idle resource can still be used before it is
+	// acquired, if set. Resources which fail validation are destroyed and
+	// replaced.
+	Validate func(context.Context, T) error
+
+	// IdleTimeout is how long a resource can be idle before it is
+	// destroyed. Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// MaxIdle is the most idle resources to keep, with the resources which
+	// have been idle the longest destroyed first. Zero means no maximum.
+	MaxIdle int
 }
 
 // Pool is a generic resource pool.
@@ -33,12 +46,93 @@ type Pool[T any] struct {
 	closed bool
 	owned  queue[*Resource[T]]
 	idle   stack[*Resource[T]]
+
+	// affinity indexes the idle resources by the affinity keys they were
+	// last acquired with (see AcquireWithAffinity). Each stack is a subset
+	// of idle, in the same order.
+	affinity map[string]*stack[*Resource[T]]
+
+	// acquired is the number of acquired resources.
+	acquired int
+
+	// pending is the number of pending resources (see
+	// resourceStatePending).
+	pending int
+
+	// drained is closed once there are no acquired or pending resources,
+	// while Close is waiting for them to be released.
+	drained chan struct{}
+
+	// destroyed is set once Close has destroyed the resources, after
+	// which late releases destroy the released resource.
+	destroyed bool
+
+	// closeDone is closed once the first call to Close returns, which
+	// later calls wait for.
+	closeDone chan struct{}
+
+	// stopJanitor is closed by Close to stop the janitor, if it is running.
+	stopJanitor chan struct{}
+
+	stats Stats
+}
+
+// Stats describes what a Pool has done over its lifetime.
+type Stats struct {
+	// Created is the number of resources created.
+	Created int
+
+	// Destroyed is the number of resources destroyed.
+	Destroyed int
+
+	// Hijacked is the number of resources hijacked.
+	Hijacked int
+
+	// Invalidated is the number of idle resources which failed validation.
+	Invalidated int
+
+	// Evicted is the number of idle resources destroyed by the janitor,
+	// due to IdleTimeout or MaxIdle.
+	Evicted int
+
+	// Acquires is the number of successful calls to Acquire.
+	Acquires int
+
+	// AcquireWait is the total time spent in successful calls to Acquire,
+	// including creating resources.
+	AcquireWait time.Duration
+
+	// MaxAcquireWait is the longest time spent in a successful call to
+	// Acquire.
+	MaxAcquireWait time.Duration
+
+	// PeakAcquired is the most resources acquired at once.
+	PeakAcquired int
+
+	// AffinityHits is the number of calls to AcquireWithAffinity which
+	// got an idle resource last acquired with one of the same keys.
+	AffinityHits int
+}
+
+// Stats returns what the pool has done so far.
+func (pool *Pool[T]) Stats() Stats {
+	pool.mut.Lock()
+	defer pool.mut.Unlock()
+
+	return pool.stats
 }
 
 func New[T any](resourceConf *ResourceConf[T]) *Pool[T] {
-	return &Pool[T]{
+	pool := &Pool[T]{
 		resourceConf: resourceConf,
 	}
+
+	if resourceConf.IdleTimeout > 0 || resourceConf.MaxIdle > 0 {
+		pool.stopJanitor = make(chan struct{})
+		go pool.runJanitor(resourceConf.janitorInterval(), pool.stopJanitor)
+	}
+
+	return pool
 }
 
 func (pool *Pool[T]) createResourceLocked(ctx context.Context) (*Resource[T], error) {
@@ -53,22 +147,50 @@ func (pool *Pool[T]) createResourceLocked(ctx context.Context) (*Resource[T], er
 	}
 
 	pool.owned.enqueue(resource)
+	pool.stats.Created++
 	return resource, nil
 }
 
 // Acquire acquires the resource from the pool. This can either be a newly
 // created resource, or a previously created idle resource.
 func (pool *Pool[T]) Acquire(ctx context.Context) (*Resource[T], error) {
+	return pool.AcquireWithAffinity(ctx, nil)
+}
+
+// AcquireWithAffinity acquires a resource from the pool, preferring the most
+// recently released idle resource which was last acquired with the first of
+// keys, then the second and so on. If there isn't one it is the same as
+// Acquire.
+func (pool *Pool[T]) AcquireWithAffinity(ctx context.Context, keys []string) (*Resource[T], error) {
+	start := time.Now()
+
 	pool.mut.Lock()
 	defer pool.mut.Unlock()
 
-	if pool.closed {
-		return nil, ErrPoolClosed
-	}
+	for {
+		// The pool may be closed while an idle resource is being
+		// validated.
+		if pool.closed {
+			return nil, ErrPoolClosed
+		}
+
+		idleResource, hit, ok := pool.popIdleLocked(keys)
+		if !ok {
+			break
+		}
+
+		valid, err := pool.validateLocked(ctx, idleResource)
+		if err != nil {
+			return nil, err
+		}
 
-	if idleResource, ok := pool.idle.pop(); ok {
-		idleResource.state = resourceStateAcquired
-		return idleResource, nil
+		if valid {
+			if hit {
+				pool.stats.AffinityHits++
+			}
+			pool.markAcquiredLocked(idleResource, keys, time.Since(start))
+			return idleResource, nil
+		}
 	}
 
 	newResource, err := pool.createResourceLocked(ctx)
@@ -76,36 +198,230 @@ func (pool *Pool[T]) Acquire(ctx context.Context) (*Resource[T], error) {
 		return nil, err
 	}
 
-	newResource.state = resourceStateAcquired
+	pool.markAcquiredLocked(newResource, keys, time.Since(start))
 	return newResource, nil
 }
 
+// pushIdleLocked pushes resource onto the idle stack, and indexes it by its
+// affinity keys.
+func (pool *Pool[T]) pushIdleLocked(resource *Resource[T]) {
+	pool.idle.push(resource)
+
+	for _, key := range resource.affinity {
+		st, ok := pool.affinity[key]
+		if !ok {
+			if pool.affinity == nil {
+				pool.affinity = make(map[string]*stack[*Resource[T]])
+			}
+			st = new(stack[*Resource[T]])
+			pool.affinity[key] = st
+		}
+
+		// The keys may repeat, in which case the resource is already
+		// indexed.
+		st.remove(resource)
+		st.push(resource)
+	}
+}
+
+// popIdleLocked pops the most recently released idle resource which was last
+// acquired with the first of keys which has one, or otherwise the most
+// recently released idle resource. hit reports whether it was found by one of
+// keys.
+func (pool *Pool[T]) popIdleLocked(keys []string) (resource *Resource[T], hit, ok bool) {
+	for _, key := range keys {
+		if st, found := pool.affinity[key]; found {
+			resource, _ = st.pop()
+			pool.idle.remove(resource)
+			pool.unindexIdleLocked(resource)
+			return resource, true, true
+		}
+	}
+
+	resource, ok = pool.idle.pop()
+	if ok {
+		pool.unindexIdleLocked(resource)
+	}
+
+	return resource, false, ok
+}
+
+// unindexIdleLocked removes resource, which is no longer idle, from the
+// affinity index.
+func (pool *Pool[T]) unindexIdleLocked(resource *Resource[T]) {
+	for _, key := range resource.affinity {
+		st, ok := pool.affinity[key]
+		if !ok {
+			continue
+		}
+
+		st.remove(resource)
+		if st.empty() {
+			delete(pool.affinity, key)
+		}
+	}
+}
+
+// validateLocked checks whether an idle resource which was popped from the idle
+// stack can be acquired, destroying it if not. The lock is released while the
+// resource is validated, since that may be slow (e.g. opening a connection).
+// An error is only returned if ctx is done or the pool was closed in the
+// meantime, in which case the resource is returned to the idle stack.
+func (pool *Pool[T]) validateLocked(ctx context.Context, resource *Resource[T]) (bool, error) {
+	if pool.resourceConf.Validate == nil {
+		return true, nil
+	}
+
+	resource.state = resourceStatePending
+	pool.removeOwnedResourceLocked(resource)
+	pool.pending++
+
+	pool.mut.Unlock()
+	err := pool.resourceConf.Validate(ctx, resource.data)
+
+	// The resource may have only failed validation because ctx is done.
+	invalid := err != nil && ctx.Err() == nil
+
+	// The resource can't be used either way, so it is no longer owned by
+	// the pool even if it couldn't be destroyed.
+	var destroyErr error
+	if invalid {
+		destroyErr = pool.resourceConf.Destroy(ctx, resource.data)
+	}
+	pool.mut.Lock()
+
+	pool.pending--
+	pool.notifyDrainedLocked()
+
+	if invalid {
+		pool.stats.Invalidated++
+		if destroyErr == nil {
+			pool.stats.Destroyed++
+		}
+		resource.state = resourceStateDestroyed
+		return false, nil
+	}
+
+	switch {
+	case pool.destroyed:
+		// Close gave up waiting for the resource, so it is destroyed
+		// now like a late release. The resource isn't owned by the pool
+		// either way.
+		_ = pool.destroyResourceLocked(context.WithoutCancel(ctx), resource)
+		resource.state = resourceStateDestroyed
+		return false, ErrPoolClosed
+	case pool.closed:
+		// Close is waiting for the resource, and destroys it once it
+		// is idle.
+		err = ErrPoolClosed
+	case err != nil:
+		err = ctx.Err()
+	}
+
+	resource.state = resourceStateIdle
+	pool.owned.enqueue(resource)
+	if err != nil {
+		pool.pushIdleLocked(resource)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (pool *Pool[T]) markAcquiredLocked(resource *Resource[T], affinity []string, wait time.Duration) {
+	resource.state = resourceStateAcquired
+	resource.affinity = affinity
+	pool.acquired++
+
+	pool.stats.Acquires++
+	pool.stats.AcquireWait += wait
+	pool.stats.MaxAcquireWait = max(pool.stats.MaxAcquireWait, wait)
+	pool.stats.PeakAcquired = max(pool.stats.PeakAcquired, pool.acquired)
+}
+
+// markNotAcquiredLocked is called once an acquired resource is released or
+// hijacked.
+func (pool *Pool[T]) markNotAcquiredLocked() {
+	pool.acquired--
+	pool.notifyDrainedLocked()
+}
+
+// notifyDrainedLocked notifies Close once no resources are acquired or
+// pending.
+func (pool *Pool[T]) notifyDrainedLocked() {
+	if pool.acquired == 0 && pool.pending == 0 && pool.drained != nil {
+		close(pool.drained)
+		pool.drained = nil
+	}
+}
+
 func (pool *Pool[T]) removeOwnedResourceLocked(resource *Resource[T]) {
 	if removed := pool.owned.remove(resource); !removed {
 		panic(internalVariantBroken("tried to destroy resource not owned by pool"))
 	}
 }
 
-// Close cleans up the pool and prevents new resources from being acquired.
+// Close prevents new resources from being acquired, waits for the acquired
+// resources to be released, then destroys all resources owned by the pool.
+//
+// If ctx expires before every acquired resource is released, the resources
+// are destroyed anyway, including the ones still acquired. Any acquired
+// resources which couldn't be destroyed are destroyed when they are released
+// instead. Resources destroyed while acquired can still be released or
+// hijacked, which does nothing.
 //
-// NOTE: for now we're just destroying ALL resources owned by the pool, not
-// just the idle ones. This is primarily for simplicity, since the assumption
-// is that pools will only be closed after all tests are done running. As a
-// result, we can assume that nobody is using resources acquired from the pool
-// when we close the pool. While this is likely not a valid assumption, since
-// we probably want to allow for a graceful shutdown in certain cases, it seems
-// better to stick with the simple approach for now then adjust as needed.
-func (pool *Pool[T]) Close(_ context.Context) error {
+// Later calls wait for the first call to return (or for ctx to be done), then
+// return nil.
+func (pool *Pool[T]) Close(ctx context.Context) error {
 	pool.mut.Lock()
 	defer pool.mut.Unlock()
 
 	if pool.closed {
-		return nil
+		closeDone := pool.closeDone
+
+		pool.mut.Unlock()
+		defer pool.mut.Lock()
+
+		select {
+		case <-closeDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	pool.closed = true
+	pool.closeDone = make(chan struct{})
+	defer close(pool.closeDone)
+	if pool.stopJanitor != nil {
+		close(pool.stopJanitor)
+	}
+
+	if pool.acquired != 0 || pool.pending != 0 {
+		drained := make(chan struct{})
+		pool.drained = drained
 
-	var destroyErrs []destroyResourceError[T]
+		pool.mut.Unlock()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		}
+		pool.mut.Lock()
+
+		pool.drained = nil
+	}
+
+	destroyCtx := ctx
+	if ctx.Err() != nil {
+		// The resources are still destroyed rather than leaked, even
+		// though waiting for them used up ctx.
+		destroyCtx = context.WithoutCancel(ctx)
+	}
+
+	var (
+		destroyErrs []destroyResourceError[T]
+		remaining   []*Resource[T]
+	)
 
 	for {
 		toDestroy, ok := pool.owned.dequeue()
@@ -113,11 +429,25 @@ func (pool *Pool[T]) Close(_ context.Context) error {
 			break
 		}
 
-		if err := pool.destroyResourceLocked(toDestroy); err != nil {
+		if err := pool.destroyResourceLocked(destroyCtx, toDestroy); err != nil {
 			destroyErrs = append(destroyErrs, destroyResourceError[T]{resourceData: toDestroy.data, cause: err})
+
+			// Acquired resources get another chance to be
+			// destroyed once they are released.
+			if toDestroy.state == resourceStateAcquired {
+				remaining = append(remaining, toDestroy)
+			}
 		}
 	}
 
+	for _, r := range remaining {
+		pool.owned.enqueue(r)
+	}
+
+	pool.idle = stack[*Resource[T]]{}
+	pool.affinity = nil
+	pool.destroyed = true
+
 	if len(destroyErrs) != 0 {
 		return destroyResourcesError[T](destroyErrs)
 	}
@@ -125,12 +455,13 @@ func (pool *Pool[T]) Close(_ context.Context) error {
 	return nil
 }
 
-func (pool *Pool[T]) destroyResourceLocked(resource *Resource[T]) error {
-	if err := pool.resourceConf.Destroy(resource.data); err != nil {
+func (pool *Pool[T]) destroyResourceLocked(ctx context.Context, resource *Resource[T]) error {
+	if err := pool.resourceConf.Destroy(ctx, resource.data); err != nil {
 		return err
 	}
 
 	resource.state = resourceStateDestroyed
+	pool.stats.Destroyed++
 
 	return nil
 }
@@ -139,21 +470,34 @@ func (pool *Pool[T]) handleResourceReleased(resource *Resource[T]) error {
 	pool.mut.Lock()
 	defer pool.mut.Unlock()
 
+	// The resource may have been destroyed by Close while it was still
+	// acquired, if Close's context expired.
+	if resource.state == resourceStateDestroyed && pool.destroyed {
+		return nil
+	}
+
 	if resource.state != resourceStateAcquired {
 		return fmt.Errorf("cannot release a non-acquired resource (state=%s)", resource.state)
 	}
 
-	// TODO: correctly handle pool closed. For now, since pool.Close
-	// destroys all resources and we are holding the lock here, we should
-	// never reach this point if the pool has closed (since the resource
-	// state will be resourceStateDestroyed). When we update the closing
-	// strategy though, which is likely, that will no longer be valid.
-	if pool.closed {
-		return errors.New("release acquired resource on closed pool")
+	pool.markNotAcquiredLocked()
+
+	if pool.destroyed {
+		// Close couldn't destroy the resource while it was acquired,
+		// so it is destroyed now rather than returned to the pool. The
+		// error was already returned by Close, so there's nothing to do
+		// with it if the resource still can't be destroyed.
+		if err := pool.destroyResourceLocked(context.Background(), resource); err == nil {
+			pool.removeOwnedResourceLocked(resource)
+		}
+		return nil
 	}
 
+	// While Close is waiting, released resources are left idle for it to
+	// destroy.
 	resource.state = resourceStateIdle
-	pool.idle.push(resource)
+	resource.idleSince = time.Now()
+	pool.pushIdleLocked(resource)
 
 	return nil
 }
@@ -162,6 +506,12 @@ func (pool *Pool[T]) getResourceData(resource *Resource[T]) (data T, err error)
 	pool.mut.Lock()
 	defer pool.mut.Unlock()
 
+	// The resource may have been destroyed by Close while it was still
+	// acquired, in which case the holder can still get its data.
+	if resource.state == resourceStateDestroyed && pool.destroyed {
+		return resource.data, nil
+	}
+
 	if resource.state != resourceStateAcquired && resource.state != resourceStateHijacked {
 		return data, fmt.Errorf("cannot get data on a resource that is neither acquired nor hijacked (state=%s)", resource.state)
 	}
@@ -173,12 +523,20 @@ func (pool *Pool[T]) handleResourceHijacked(resource *Resource[T]) error {
 	pool.mut.Lock()
 	defer pool.mut.Unlock()
 
+	// The resource may have been destroyed by Close while it was still
+	// acquired, so there's nothing left to hijack.
+	if resource.state == resourceStateDestroyed && pool.destroyed {
+		return nil
+	}
+
 	if resource.state != resourceStateAcquired {
 		return fmt.Errorf("cannot hijack a non-acquired resource (state=%s)", resource.state)
 	}
 
 	resource.state = resourceStateHijacked
 	pool.removeOwnedResourceLocked(resource)
+	pool.markNotAcquiredLocked()
+	pool.stats.Hijacked++
 
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type resourceCounts struct {
@@ -32,8 +33,8 @@ func countedResourceConf[T any](counts *resourceCounts, conf *ResourceConf[T]) *
 			}
 			return x, err
 		},
-		Destroy: func(x T) error {
-			err := conf.Destroy(x)
+		Destroy: func(ctx context.Context, x T) error {
+			err := conf.Destroy(ctx, x)
 			if err == nil {
 				counts.destroyed.Add(1)
 			}
@@ -66,7 +67,7 @@ var fakeResourceConf = &ResourceConf[*fakeResource]{
 	Create: func(context.Context) (*fakeResource, error) {
 		return new(fakeResource), nil
 	},
-	Destroy: func(x *fakeResource) error {
+	Destroy: func(_ context.Context, x *fakeResource) error {
 		x.Close()
 		return nil
 	},
@@ -114,3 +115,542 @@ func TestPoolAcquireThenAcquire(t *testing.T) {
 
 	t.Logf("pool.owned.items = %v", pool.owned.items())
 }
+
+func TestPoolCloseWaitsForRelease(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		counts = new(resourceCounts)
+		pool   = New(countedResourceConf(counts, fakeResourceConf))
+	)
+
+	r, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- pool.Close(ctx)
+	}()
+
+	select {
+	case err := <-closed:
+		t.Fatalf("close returned before the acquired resource was released (err = %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := pool.Acquire(ctx); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("acquire while closing: err = %v; want %v", err, ErrPoolClosed)
+	}
+
+	if err := r.Data().Valid(); err != nil {
+		t.Errorf("acquired resource destroyed before release: %s", err)
+	}
+	data := r.Data()
+	r.Release()
+
+	if err := <-closed; err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	if err := data.Valid(); err == nil {
+		t.Errorf("released resource not destroyed by close")
+	}
+	counts.assertCreatedEqualsDestroyed(t)
+}
+
+func TestPoolCloseWaitsForHijack(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		counts = new(resourceCounts)
+		pool   = New(countedResourceConf(counts, fakeResourceConf))
+	)
+
+	r, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- pool.Close(ctx)
+	}()
+
+	r.Hijack()
+
+	if err := <-closed; err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	if err := r.Data().Valid(); err != nil {
+		t.Errorf("hijacked resource destroyed by close: %s", err)
+	}
+	if destroyed := counts.destroyed.Load(); destroyed != 0 {
+		t.Errorf("destroyed=%d; want 0", destroyed)
+	}
+}
+
+func TestPoolCloseContextExpired(t *testing.T) {
+	var (
+		counts = new(resourceCounts)
+		pool   = New(countedResourceConf(counts, fakeResourceConf))
+	)
+
+	r, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	data := r.Data()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := pool.Close(ctx); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	if err := data.Valid(); err == nil {
+		t.Errorf("acquired resource not destroyed once close's context expired")
+	}
+
+	// Getting the data of and releasing the destroyed resource are no-ops.
+	if r.Data() != data {
+		t.Errorf("r.Data() changed once the resource was destroyed")
+	}
+	r.Release()
+
+	counts.assertCreatedEqualsDestroyed(t)
+}
+
+func TestPoolCloseContextExpiredHijack(t *testing.T) {
+	var (
+		counts = new(resourceCounts)
+		pool   = New(countedResourceConf(counts, fakeResourceConf))
+	)
+
+	r, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := pool.Close(ctx); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	// Hijacking the destroyed resource (e.g. to keep it for a failed
+	// test) is a no-op.
+	_ = r.Data()
+	r.Hijack()
+
+	if stats := pool.Stats(); stats.Hijacked != 0 {
+		t.Errorf("pool.Stats().Hijacked = %d; want 0", stats.Hijacked)
+	}
+	counts.assertCreatedEqualsDestroyed(t)
+}
+
+func TestPoolConcurrentClose(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		counts = new(resourceCounts)
+		pool   = New(countedResourceConf(counts, fakeResourceConf))
+	)
+
+	r, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+
+	closed := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			closed <- pool.Close(ctx)
+		}()
+	}
+
+	select {
+	case err := <-closed:
+		t.Fatalf("close returned before the acquired resource was released (err = %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.Release()
+
+	for i := 0; i < 2; i++ {
+		if err := <-closed; err != nil {
+			t.Fatalf("close: %s", err)
+		}
+	}
+
+	// Both calls returned once the resource was destroyed.
+	counts.assertCreatedEqualsDestroyed(t)
+}
+
+func TestPoolLateReleaseDestroys(t *testing.T) {
+	var (
+		counts = new(resourceCounts)
+		inUse  atomic.Bool
+	)
+
+	conf := &ResourceConf[*fakeResource]{
+		Create: fakeResourceConf.Create,
+		Destroy: func(ctx context.Context, x *fakeResource) error {
+			if inUse.Load() {
+				return errors.New("resource in use")
+			}
+			return fakeResourceConf.Destroy(ctx, x)
+		},
+	}
+	pool := New(countedResourceConf(counts, conf))
+
+	r, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	data := r.Data()
+	inUse.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := pool.Close(ctx); err == nil {
+		t.Fatalf("close = nil; want error destroying the acquired resource")
+	}
+
+	inUse.Store(false)
+	r.Release()
+
+	if err := data.Valid(); err == nil {
+		t.Errorf("late released resource not destroyed")
+	}
+	if !pool.owned.empty() {
+		t.Errorf("pool still owns resources after late release: %v", pool.owned.items())
+	}
+	counts.assertCreatedEqualsDestroyed(t)
+}
+
+func TestPoolStats(t *testing.T) {
+	ctx := context.Background()
+	pool := New(fakeResourceConf)
+
+	first, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("first acquire: %s", err)
+	}
+	second, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("second acquire: %s", err)
+	}
+
+	first.Release()
+	second.Hijack()
+
+	third, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("third acquire: %s", err)
+	}
+	third.Release()
+
+	if err := pool.Close(ctx); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	stats := pool.Stats()
+	if stats.AcquireWait < stats.MaxAcquireWait {
+		t.Errorf("stats.AcquireWait = %s; want at least stats.MaxAcquireWait = %s", stats.AcquireWait, stats.MaxAcquireWait)
+	}
+
+	stats.AcquireWait, stats.MaxAcquireWait = 0, 0
+	expected := Stats{
+		Created:      2,
+		Destroyed:    1,
+		Hijacked:     1,
+		Acquires:     3,
+		PeakAcquired: 2,
+	}
+	if stats != expected {
+		t.Errorf("pool.Stats() = %+v; want %+v", stats, expected)
+	}
+}
+
+func TestPoolValidate(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		counts = new(resourceCounts)
+		conf   = countedResourceConf(counts, fakeResourceConf)
+	)
+	conf.Validate = func(_ context.Context, x *fakeResource) error {
+		return x.Valid()
+	}
+	pool := New(conf)
+
+	first, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("first acquire: %s", err)
+	}
+	second, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("second acquire: %s", err)
+	}
+
+	// Break the first resource while it is idle, so it fails validation.
+	broken := first.Data()
+	first.Release()
+	second.Release()
+	broken.Close()
+
+	for i := 0; i < 2; i++ {
+		r, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatalf("acquire after release: %s", err)
+		}
+		if err := r.Data().Valid(); err != nil {
+			t.Errorf("acquired invalid resource: %s", err)
+		}
+		defer r.Release()
+	}
+
+	stats := pool.Stats()
+	if stats.Invalidated != 1 || stats.Created != 3 || stats.Destroyed != 1 {
+		t.Errorf("pool.Stats() = %+v; want 1 invalidated, 3 created and 1 destroyed", stats)
+	}
+}
+
+func TestPoolValidateContextDone(t *testing.T) {
+	conf := &ResourceConf[*fakeResource]{
+		Create:  fakeResourceConf.Create,
+		Destroy: fakeResourceConf.Destroy,
+		Validate: func(ctx context.Context, _ *fakeResource) error {
+			return ctx.Err()
+		},
+	}
+	pool := New(conf)
+
+	r, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	r.Release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := pool.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire with cancelled context: err = %v; want %v", err, context.Canceled)
+	}
+
+	// The resource wasn't destroyed just because the context was done.
+	if _, err := pool.Acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	if stats := pool.Stats(); stats.Created != 1 || stats.Invalidated != 0 {
+		t.Errorf("pool.Stats() = %+v; want 1 created and 0 invalidated", stats)
+	}
+}
+
+func TestPoolValidateWithoutLock(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		validating = make(chan struct{})
+		unblock    = make(chan struct{})
+	)
+
+	conf := &ResourceConf[*fakeResource]{
+		Create:  fakeResourceConf.Create,
+		Destroy: fakeResourceConf.Destroy,
+		Validate: func(context.Context, *fakeResource) error {
+			close(validating)
+			<-unblock
+			return nil
+		},
+	}
+	pool := New(conf)
+
+	first, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("first acquire: %s", err)
+	}
+	second, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("second acquire: %s", err)
+	}
+	first.Release()
+
+	acquired := make(chan *Resource[*fakeResource])
+	go func() {
+		r, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Errorf("acquire idle resource: %s", err)
+		}
+		acquired <- r
+	}()
+	<-validating
+
+	// The pool can be used while the idle resource is being validated.
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		second.Release()
+		_ = pool.Stats()
+	}()
+
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("release blocked while another resource was being validated")
+	}
+
+	close(unblock)
+	r := <-acquired
+	if r != first {
+		t.Errorf("acquire got a different resource than the idle one it validated")
+	}
+	r.Release()
+
+	if err := pool.Close(ctx); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
+
+func TestPoolCloseWaitsForValidate(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		counts     = new(resourceCounts)
+		validating = make(chan struct{})
+		unblock    = make(chan struct{})
+	)
+
+	conf := countedResourceConf(counts, fakeResourceConf)
+	conf.Validate = func(context.Context, *fakeResource) error {
+		close(validating)
+		<-unblock
+		return nil
+	}
+	pool := New(conf)
+
+	r, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	r.Release()
+
+	acquireErr := make(chan error)
+	go func() {
+		_, err := pool.Acquire(ctx)
+		acquireErr <- err
+	}()
+	<-validating
+
+	closed := make(chan error)
+	go func() {
+		closed <- pool.Close(ctx)
+	}()
+
+	select {
+	case err := <-closed:
+		t.Fatalf("close returned while a resource was being validated (err = %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(unblock)
+
+	// The pool was closed while the resource was being validated, so it
+	// isn't handed out.
+	if err := <-acquireErr; !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("acquire while closing: err = %v; want %v", err, ErrPoolClosed)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	counts.assertCreatedEqualsDestroyed(t)
+}
+
+func TestPoolAcquireWithAffinity(t *testing.T) {
+	// The resources are released in order, so the last one is on top of
+	// the idle stack.
+	releasedWith := [][]string{
+		{"test:TestA", "family:TestA"},
+		{"test:TestB", "family:TestB"},
+		{"test:TestA/x", "family:TestA"},
+	}
+
+	testCases := map[string]struct {
+		keys        []string
+		expected    int
+		expectedHit bool
+	}{
+		"same_test": {
+			keys:        []string{"test:TestA", "family:TestA"},
+			expected:    0,
+			expectedHit: true,
+		},
+		"same_family": {
+			keys:        []string{"test:TestA/y", "family:TestA"},
+			expected:    2,
+			expectedHit: true,
+		},
+		"other_family": {
+			keys:        []string{"test:TestB/x", "family:TestB"},
+			expected:    1,
+			expectedHit: true,
+		},
+		"no_match": {
+			keys:     []string{"test:TestC", "family:TestC"},
+			expected: 2,
+		},
+		"no_keys": {
+			expected: 2,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			pool := New(fakeResourceConf)
+
+			resources := make([]*Resource[*fakeResource], len(releasedWith))
+			for i, keys := range releasedWith {
+				r, err := pool.AcquireWithAffinity(ctx, keys)
+				if err != nil {
+					t.Fatalf("acquire: %s", err)
+				}
+				resources[i] = r
+			}
+
+			data := make([]*fakeResource, len(resources))
+			for i, r := range resources {
+				data[i] = r.Data()
+				r.Release()
+			}
+
+			r, err := pool.AcquireWithAffinity(ctx, testCase.keys)
+			if err != nil {
+				t.Fatalf("acquire with affinity: %s", err)
+			}
+			if r.Data() != data[testCase.expected] {
+				t.Errorf("pool.AcquireWithAffinity(%q) didn't return resource %d", testCase.keys, testCase.expected)
+			}
+
+			expectedHits := 0
+			if testCase.expectedHit {
+				expectedHits = 1
+			}
+			if hits := pool.Stats().AffinityHits; hits != expectedHits {
+				t.Errorf("pool.Stats().AffinityHits = %d; want %d", hits, expectedHits)
+			}
+
+			// The remaining idle resources can still be acquired, and
+			// are no longer indexed once they are.
+			for i := 0; i < len(resources)-1; i++ {
+				if _, err := pool.Acquire(ctx); err != nil {
+					t.Fatalf("acquire: %s", err)
+				}
+			}
+			if !pool.idle.empty() || len(pool.affinity) != 0 {
+				t.Errorf("pool has idle resources after acquiring all of them: idle=%v affinity=%v", pool.idle.items(), pool.affinity)
+			}
+		})
+	}
+}
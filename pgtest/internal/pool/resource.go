@@ -1,6 +1,9 @@
 package pool
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // resourceState describes the state of a resource that has been created by the
 // pool.
@@ -24,6 +27,11 @@ const (
 
 	// The resource has been destroyed.
 	resourceStateDestroyed
+
+	// The resource has been taken from the idle stack to be validated or
+	// evicted, which is done without holding the pool's lock. It isn't owned by the
+	// pool in the meantime, so Close doesn't destroy it.
+	resourceStatePending
 )
 
 func (state resourceState) String() string {
@@ -39,6 +47,8 @@ func (state resourceState) String() string {
 		return "hijacked"
 	case resourceStateDestroyed:
 		return "destroyed"
+	case resourceStatePending:
+		return "pending"
 	}
 
 	panic(internalVariantBroken(fmt.Sprintf("invalid resourceState: %d", state)))
@@ -49,6 +59,12 @@ type Resource[T any] struct {
 	pool  *Pool[T]
 	data  T
 	state resourceState
+
+	// idleSince is when the resource was last released.
+	idleSince time.Time
+
+	// affinity are the keys the resource was last acquired with.
+	affinity []string
 }
 
 // Release releases the resource back to the pool so it can be re-used.
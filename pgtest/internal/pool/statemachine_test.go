@@ -0,0 +1,283 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var stateMachineSeed = flag.Int64("pool.seed", 0, "seed for the randomized pool tests (default: random)")
+
+// A machineResource is a resource which keeps track of how it is used, to
+// check that the pool never hands out or destroys a resource which is in use.
+type machineResource struct {
+	inUse     atomic.Bool
+	broken    atomic.Bool
+	destroyed atomic.Bool
+}
+
+// stateMachine drives a pool with random operations from several workers.
+type stateMachine struct {
+	t    *testing.T
+	pool *Pool[*machineResource]
+
+	mut      sync.Mutex
+	hijacked []*machineResource
+}
+
+func newStateMachine(t *testing.T, maxIdle int) *stateMachine {
+	m := &stateMachine{t: t}
+
+	conf := &ResourceConf[*machineResource]{
+		Create: func(context.Context) (*machineResource, error) {
+			return new(machineResource), nil
+		},
+		Destroy: func(_ context.Context, r *machineResource) error {
+			if r.inUse.Load() {
+				t.Errorf("destroyed a resource which is in use")
+			}
+			if r.destroyed.Swap(true) {
+				t.Errorf("destroyed a resource twice")
+			}
+			return nil
+		},
+		Validate: func(_ context.Context, r *machineResource) error {
+			if r.broken.Load() {
+				return errors.New("broken")
+			}
+			return nil
+		},
+		MaxIdle: maxIdle,
+	}
+
+	// The pool is created without a janitor, so the workers decide when
+	// idle resources are evicted.
+	m.pool = &Pool[*machineResource]{resourceConf: conf}
+	return m
+}
+
+// checkInvariants checks that the bookkeeping of the pool is consistent.
+func (m *stateMachine) checkInvariants() {
+	m.t.Helper()
+
+	m.pool.mut.Lock()
+	defer m.pool.mut.Unlock()
+
+	owned := make(map[*Resource[*machineResource]]bool)
+	for _, r := range m.pool.owned.items() {
+		if owned[r] {
+			m.t.Errorf("resource owned twice")
+		}
+		owned[r] = true
+	}
+	if len(owned) != m.pool.owned.len() {
+		m.t.Errorf("pool.owned.len() = %d; want %d", m.pool.owned.len(), len(owned))
+	}
+
+	idle := make(map[*Resource[*machineResource]]bool)
+	for _, r := range m.pool.idle.items() {
+		if idle[r] {
+			m.t.Errorf("resource idle twice")
+		}
+		idle[r] = true
+
+		if !owned[r] {
+			m.t.Errorf("idle resource not owned by the pool")
+		}
+		if r.state != resourceStateIdle {
+			m.t.Errorf("resource on the idle stack has state %s", r.state)
+		}
+	}
+
+	var acquired int
+	for r := range owned {
+		switch r.state {
+		case resourceStateAcquired:
+			acquired++
+		case resourceStateIdle:
+			if !idle[r] {
+				m.t.Errorf("idle resource not on the idle stack")
+			}
+		default:
+			m.t.Errorf("owned resource has state %s", r.state)
+		}
+	}
+
+	for key, st := range m.pool.affinity {
+		if st.empty() {
+			m.t.Errorf("empty affinity stack for key %q", key)
+		}
+
+		for _, r := range st.items() {
+			if !idle[r] {
+				m.t.Errorf("resource indexed by key %q isn't idle", key)
+			}
+			if !slices.Contains(r.affinity, key) {
+				m.t.Errorf("resource indexed by key %q was acquired with %q", key, r.affinity)
+			}
+		}
+	}
+	for r := range idle {
+		for _, key := range r.affinity {
+			if st := m.pool.affinity[key]; st == nil || !slices.Contains(st.items(), r) {
+				m.t.Errorf("idle resource not indexed by key %q", key)
+			}
+		}
+	}
+
+	if acquired != m.pool.acquired {
+		m.t.Errorf("pool.acquired = %d; want %d", m.pool.acquired, acquired)
+	}
+}
+
+// worker randomly acquires, releases and hijacks resources until the pool is
+// closed, then releases everything it still holds.
+func (m *stateMachine) worker(ctx context.Context, rng *rand.Rand) {
+	var held []*Resource[*machineResource]
+
+	release := func(i int) {
+		r := held[i]
+		held = append(held[:i], held[i+1:]...)
+
+		data := r.Data()
+		if rng.Intn(10) == 0 {
+			data.broken.Store(true)
+		}
+		data.inUse.Store(false)
+		r.Release()
+	}
+
+	for {
+		switch op := rng.Intn(10); {
+		case op < 4 || len(held) == 0:
+			r, err := m.pool.AcquireWithAffinity(ctx, randomAffinityKeys(rng))
+			if errors.Is(err, ErrPoolClosed) {
+				for len(held) != 0 {
+					release(len(held) - 1)
+				}
+				return
+			}
+			if err != nil {
+				m.t.Errorf("acquire: %s", err)
+				return
+			}
+
+			data := r.Data()
+			if data.inUse.Swap(true) {
+				m.t.Errorf("acquired a resource which is already in use")
+			}
+			if data.destroyed.Load() || data.broken.Load() {
+				m.t.Errorf("acquired a destroyed or broken resource")
+			}
+			held = append(held, r)
+		case op < 9:
+			release(rng.Intn(len(held)))
+		default:
+			i := rng.Intn(len(held))
+			r := held[i]
+			held = append(held[:i], held[i+1:]...)
+
+			data := r.Data()
+			r.Hijack()
+			data.inUse.Store(false)
+
+			m.mut.Lock()
+			m.hijacked = append(m.hijacked, data)
+			m.mut.Unlock()
+		}
+
+		m.checkInvariants()
+	}
+}
+
+// randomAffinityKeys returns up to 2 keys from a small set, possibly repeated.
+func randomAffinityKeys(rng *rand.Rand) []string {
+	keys := make([]string, rng.Intn(3))
+	for i := range keys {
+		keys[i] = string(rune('a' + rng.Intn(4)))
+	}
+
+	return keys
+}
+
+func TestPoolStateMachine(t *testing.T) {
+	const (
+		iterations = 20
+		workers    = 8
+	)
+
+	seed := *stateMachineSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed = %d (re-run with -pool.seed=%d)", seed, seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	for i := 0; i < iterations; i++ {
+		m := newStateMachine(t, rng.Intn(4))
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for j := 0; j < workers; j++ {
+			workerRNG := rand.New(rand.NewSource(rng.Int63()))
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.worker(ctx, workerRNG)
+			}()
+		}
+
+		// Idle resources are evicted concurrently with the workers.
+		stopEvicting := make(chan struct{})
+		evicted := make(chan struct{})
+		go func() {
+			defer close(evicted)
+			for {
+				select {
+				case <-stopEvicting:
+					return
+				case <-time.After(100 * time.Microsecond):
+					m.pool.evictIdle(time.Now())
+				}
+			}
+		}()
+
+		time.Sleep(time.Duration(rng.Intn(20)) * time.Millisecond)
+
+		if err := m.pool.Close(ctx); err != nil {
+			t.Fatalf("close: %s", err)
+		}
+		close(stopEvicting)
+		<-evicted
+		wg.Wait()
+
+		m.checkInvariants()
+		if !m.pool.owned.empty() {
+			t.Errorf("pool still owns %d resources after close", m.pool.owned.len())
+		}
+
+		stats := m.pool.Stats()
+		if stats.Created != stats.Destroyed+stats.Hijacked {
+			t.Errorf("pool.Stats() = %+v; want created = destroyed + hijacked", stats)
+		}
+		if stats.Hijacked != len(m.hijacked) {
+			t.Errorf("stats.Hijacked = %d; want %d", stats.Hijacked, len(m.hijacked))
+		}
+		for _, r := range m.hijacked {
+			if r.destroyed.Load() {
+				t.Errorf("hijacked resource destroyed")
+			}
+		}
+
+		if t.Failed() {
+			t.Fatalf("iteration %d failed", i)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package localserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The binaries required to run a local server.
+var requiredBinaries = []string{"initdb", "pg_ctl", "postgres"}
+
+// ErrBinariesNotFound is returned if the postgres server binaries can't be
+// found.
+var ErrBinariesNotFound = errors.New("localserver: postgres binaries not found")
+
+// findBinDir returns the directory containing the postgres server binaries.
+//
+// If binDir is specified, only that directory is checked. Otherwise the
+// binaries are looked up on the PATH, followed by the directory reported by
+// pg_config, followed by the Debian/Ubuntu convention of
+// /usr/lib/postgresql/<version>/bin (which is not on the PATH by default),
+// preferring the newest version.
+func findBinDir(binDir string) (string, error) {
+	if binDir != "" {
+		if err := checkBinDir(binDir); err != nil {
+			return "", fmt.Errorf("%w in %s: %w", ErrBinariesNotFound, binDir, err)
+		}
+
+		return binDir, nil
+	}
+
+	var candidates []string
+	if pgCtl, err := exec.LookPath("pg_ctl"); err == nil {
+		candidates = append(candidates, filepath.Dir(pgCtl))
+	}
+
+	if out, err := exec.Command("pg_config", "--bindir").Output(); err == nil {
+		candidates = append(candidates, strings.TrimSpace(string(out)))
+	}
+
+	candidates = append(candidates, debianBinDirs("/usr/lib/postgresql")...)
+
+	for _, candidate := range candidates {
+		if checkBinDir(candidate) == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w on PATH (set the bin dir explicitly with WithBinDir or PGTEST_PG_BIN_DIR)", ErrBinariesNotFound)
+}
+
+// checkBinDir checks that dir contains each of the required binaries.
+func checkBinDir(dir string) error {
+	for _, name := range requiredBinaries {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+			return fmt.Errorf("%s is not executable", path)
+		}
+	}
+
+	return nil
+}
+
+// debianBinDirs returns the bin directories under root for each installed
+// version, newest first.
+func debianBinDirs(root string) []string {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+
+	var versions []int
+	for _, entry := range entries {
+		if v, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			versions = append(versions, v)
+		}
+	}
+
+	slices.Sort(versions)
+	slices.Reverse(versions)
+
+	dirs := make([]string, len(versions))
+	for i, v := range versions {
+		dirs[i] = filepath.Join(root, strconv.Itoa(v), "bin")
+	}
+
+	return dirs
+}
@@ -0,0 +1,345 @@
+// Package localserver runs an ephemeral postgres server from locally installed
+// binaries, so that tests can be run without managing a server separately
+// (e.g. through docker).
+//
+// A typical TestMain looks like:
+//
+//	srv, err := localserver.Start(ctx)
+//	if err != nil {
+//		log.Fatalf("start local postgres server: %s", err)
+//	}
+//
+//	supervisor, err := pgtest.NewSupervisor(ctx, pgtest.WithManagedServer(srv))
+//	if err != nil {
+//		log.Fatalf("initialize pgtest supervisor: %s", err)
+//	}
+//
+//	// The server is stopped when the supervisor is shutdown.
+//	os.Exit(pgtest.RunMain(ctx, m, supervisor))
+package localserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+)
+
+const (
+	defaultUser         = "postgres"
+	defaultPort         = 5432
+	defaultStartTimeout = 60 * time.Second
+
+	// maxSocketPathLen is the longest unix domain socket path which fits
+	// in sun_path on all supported platforms (104 bytes on macOS, including
+	// the terminating NUL).
+	maxSocketPathLen = 103
+
+	// shortSocketBaseDir is where the socket directory is created instead
+	// if the socket path in the temporary directory would be too long (e.g.
+	// with the default TMPDIR on macOS).
+	shortSocketBaseDir = "/tmp"
+)
+
+// defaultSettings are the server settings used unless overridden with
+// WithSetting. These trade durability for speed, since test data is
+// disposable.
+var defaultSettings = map[string]string{
+	"fsync":              "off",
+	"synchronous_commit": "off",
+	"full_page_writes":   "off",
+}
+
+// A Server is a running postgres server, whose data directory lives in a
+// temporary directory that is removed when the server is stopped.
+type Server struct {
+	binDir  string
+	dir     string
+	dataDir string
+
+	// socketDir is the directory of the unix domain socket, which is dir
+	// unless its path is too long.
+	socketDir string
+
+	host    string
+	port    int
+	user    string
+	output  io.Writer
+	storage Storage
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// Start initializes a new database cluster in a temporary directory and starts
+// a server for it, waiting until it is ready to accept connections.
+func Start(ctx context.Context, opts ...Option) (*Server, error) {
+	c := &config{
+		binDir:       os.Getenv("PGTEST_PG_BIN_DIR"),
+		user:         defaultUser,
+		settings:     maps.Clone(defaultSettings),
+		startTimeout: defaultStartTimeout,
+		output:       io.Discard,
+
+		minFreeMemory: defaultMinFreeMemory,
+	}
+
+	for _, opt := range opts {
+		opt.apply(c)
+	}
+
+	binDir, err := findBinDir(c.binDir)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir, storage, err := chooseStorage(c.ramDirs, c.minFreeMemory, systemStorageProbe)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(baseDir, "pgtest-")
+	if err != nil {
+		return nil, fmt.Errorf("localserver: create temp dir: %w", err)
+	}
+
+	s := &Server{
+		binDir:  binDir,
+		dir:     dir,
+		dataDir: filepath.Join(dir, "data"),
+		user:    c.user,
+		output:  c.output,
+		storage: storage,
+	}
+
+	if len(c.ramDirs) != 0 {
+		fmt.Fprintf(s.output, "localserver: storing data directory on %s (%s)\n", storage, s.dataDir)
+	}
+
+	if err := s.start(ctx, c); err != nil {
+		_ = s.removeTempDirs()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Server) start(ctx context.Context, c *config) error {
+	if err := s.run(ctx, "initdb",
+		"--pgdata", s.dataDir,
+		"--username", s.user,
+		"--auth", "trust",
+		"--encoding", "UTF8",
+		"--no-sync",
+	); err != nil {
+		return err
+	}
+
+	settings := c.settings
+	if c.tcp {
+		port, err := freePort()
+		if err != nil {
+			return fmt.Errorf("localserver: find free port: %w", err)
+		}
+
+		s.host = "127.0.0.1"
+		s.port = port
+		settings["listen_addresses"] = s.host
+	} else {
+		s.port = defaultPort
+		settings["listen_addresses"] = ""
+	}
+	settings["port"] = strconv.Itoa(s.port)
+
+	// The socket directory is always set, since the default directory
+	// (e.g. /var/run/postgresql) might not be writable by the current
+	// user.
+	socketDir, err := makeSocketDir(s.dir, shortSocketBaseDir, s.port)
+	if err != nil {
+		return err
+	}
+	s.socketDir = socketDir
+	settings["unix_socket_directories"] = s.socketDir
+	if !c.tcp {
+		s.host = s.socketDir
+	}
+
+	if err := appendSettings(filepath.Join(s.dataDir, "postgresql.conf"), settings); err != nil {
+		return fmt.Errorf("localserver: write settings: %w", err)
+	}
+
+	return s.run(ctx, "pg_ctl", "start",
+		"--pgdata", s.dataDir,
+		"--log", s.LogFile(),
+		"--wait",
+		"--timeout", strconv.Itoa(int(c.startTimeout.Seconds())),
+		"--silent",
+	)
+}
+
+// run runs one of the postgres binaries, including its output in the returned
+// error if it fails.
+func (s *Server) run(ctx context.Context, name string, args ...string) error {
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, filepath.Join(s.binDir, name), args...)
+	cmd.Stdout = io.MultiWriter(s.output, &out)
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("localserver: %s: %w: %s", name, err, strings.TrimSpace(out.String()))
+	}
+
+	return nil
+}
+
+// ConnParams returns the parameters for connecting to the server as its
+// superuser.
+func (s *Server) ConnParams() []connparams.Option {
+	return []connparams.Option{
+		connparams.WithHost(s.host),
+		connparams.WithPort(s.port),
+		connparams.WithUser(s.user),
+		connparams.WithSSLMode(connparams.SSLModeDisable),
+	}
+}
+
+// Storage returns where the server's data directory is stored.
+func (s *Server) Storage() Storage {
+	return s.storage
+}
+
+// DataDir returns the server's data directory. Note that this is removed when
+// the server is stopped.
+func (s *Server) DataDir() string {
+	return s.dataDir
+}
+
+// LogFile returns the path of the server's log file. Note that this is removed
+// when the server is stopped.
+func (s *Server) LogFile() string {
+	return filepath.Join(s.dir, "postgres.log")
+}
+
+// Stop stops the server and removes its temporary directory. It is safe to
+// call Stop multiple times.
+func (s *Server) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		if err := s.run(ctx, "pg_ctl", "stop",
+			"--pgdata", s.dataDir,
+			"--mode", "fast",
+			"--wait",
+			"--silent",
+		); err != nil {
+			s.stopErr = err
+			return
+		}
+
+		s.stopErr = s.removeTempDirs()
+	})
+
+	return s.stopErr
+}
+
+// removeTempDirs removes the server's temporary directory, along with the
+// socket directory if it was created separately.
+func (s *Server) removeTempDirs() error {
+	var err error
+	if s.socketDir != "" && s.socketDir != s.dir {
+		if removeErr := os.RemoveAll(s.socketDir); removeErr != nil {
+			err = fmt.Errorf("localserver: remove socket dir: %w", removeErr)
+		}
+	}
+
+	if removeErr := os.RemoveAll(s.dir); removeErr != nil {
+		err = errors.Join(err, fmt.Errorf("localserver: remove temp dir: %w", removeErr))
+	}
+
+	return err
+}
+
+// makeSocketDir returns the directory for the server's unix domain socket,
+// which is dir unless the socket path would be too long for sun_path. In that
+// case a short directory is created in baseDir instead, since otherwise the
+// server would fail to start.
+func makeSocketDir(dir, baseDir string, port int) (string, error) {
+	if len(socketPath(dir, port)) <= maxSocketPathLen {
+		return dir, nil
+	}
+
+	// The name is the same length as those created by os.MkdirTemp.
+	if len(socketPath(filepath.Join(baseDir, "pgtest-0123456789"), port)) > maxSocketPathLen {
+		return "", fmt.Errorf("localserver: socket path in %s is longer than %d bytes, and so is the fallback in %s", dir, maxSocketPathLen, baseDir)
+	}
+
+	socketDir, err := os.MkdirTemp(baseDir, "pgtest-")
+	if err != nil {
+		return "", fmt.Errorf("localserver: socket path in %s is longer than %d bytes, and failed to create socket dir: %w", dir, maxSocketPathLen, err)
+	}
+
+	return socketDir, nil
+}
+
+// socketPath returns the path of the unix domain socket the server creates in
+// dir.
+func socketPath(dir string, port int) string {
+	return filepath.Join(dir, ".s.PGSQL."+strconv.Itoa(port))
+}
+
+// appendSettings appends the settings to the postgresql.conf file. Later
+// entries in the file take precedence over earlier ones, so this overrides
+// anything written by initdb.
+func appendSettings(confFile string, settings map[string]string) error {
+	f, err := os.OpenFile(confFile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, formatSettings(settings)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func formatSettings(settings map[string]string) string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteString("\n# Added by pgtest/localserver.\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s = '%s'\n", name, strings.ReplaceAll(settings[name], "'", "''"))
+	}
+
+	return b.String()
+}
+
+// freePort returns a port on the loopback interface which is not currently in
+// use.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,213 @@
+package localserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/jackc/pgx/v5"
+)
+
+// writeFakeBinaries writes an executable file for each of the names to dir.
+func writeFakeBinaries(t testing.TB, dir string, names ...string) {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("unexpected error creating %s: %s", dir, err)
+	}
+
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatalf("unexpected error writing %s: %s", name, err)
+		}
+	}
+}
+
+func TestFindBinDirConfigured(t *testing.T) {
+	binDir := t.TempDir()
+	writeFakeBinaries(t, binDir, requiredBinaries...)
+
+	found, err := findBinDir(binDir)
+	if err != nil {
+		t.Fatalf("findBinDir(%q) = %s; want nil error", binDir, err)
+	}
+
+	if found != binDir {
+		t.Errorf("findBinDir(%q) = %q; want %q", binDir, found, binDir)
+	}
+}
+
+func TestFindBinDirConfiguredMissingBinary(t *testing.T) {
+	binDir := t.TempDir()
+	writeFakeBinaries(t, binDir, "initdb", "pg_ctl")
+
+	_, err := findBinDir(binDir)
+	if !errors.Is(err, ErrBinariesNotFound) {
+		t.Errorf("findBinDir(%q) = %v; want %v", binDir, err, ErrBinariesNotFound)
+	}
+}
+
+func TestFindBinDirOnPath(t *testing.T) {
+	binDir := t.TempDir()
+	writeFakeBinaries(t, binDir, requiredBinaries...)
+	t.Setenv("PATH", binDir)
+
+	found, err := findBinDir("")
+	if err != nil {
+		t.Fatalf("findBinDir(\"\") = %s; want nil error", err)
+	}
+
+	if found != binDir {
+		t.Errorf("findBinDir(\"\") = %q; want %q", found, binDir)
+	}
+}
+
+func TestDebianBinDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, version := range []string{"9", "15", "13", "not-a-version"} {
+		writeFakeBinaries(t, filepath.Join(root, version, "bin"))
+	}
+
+	expected := []string{
+		filepath.Join(root, "15", "bin"),
+		filepath.Join(root, "13", "bin"),
+		filepath.Join(root, "9", "bin"),
+	}
+
+	if actual := debianBinDirs(root); !slices.Equal(actual, expected) {
+		t.Errorf("debianBinDirs(root) = %q; want %q", actual, expected)
+	}
+}
+
+func TestFormatSettings(t *testing.T) {
+	settings := map[string]string{
+		"fsync":                   "off",
+		"unix_socket_directories": "/tmp/it's here",
+		"listen_addresses":        "",
+	}
+
+	expected := "\n# Added by pgtest/localserver.\n" +
+		"fsync = 'off'\n" +
+		"listen_addresses = ''\n" +
+		"unix_socket_directories = '/tmp/it''s here'\n"
+
+	if actual := formatSettings(settings); actual != expected {
+		t.Errorf("formatSettings(settings) = %q; want %q", actual, expected)
+	}
+}
+
+func TestMakeSocketDir(t *testing.T) {
+	baseDir := t.TempDir()
+	longDir := filepath.Join(baseDir, strings.Repeat("x", maxSocketPathLen))
+
+	testCases := map[string]struct {
+		dir         string
+		baseDir     string
+		expectSame  bool
+		expectedErr bool
+	}{
+		"short_dir": {
+			dir:        "/tmp/pgtest-123",
+			baseDir:    baseDir,
+			expectSame: true,
+		},
+		"long_dir": {
+			dir:     longDir,
+			baseDir: baseDir,
+		},
+		"long_dir_and_base_dir": {
+			dir:         longDir,
+			baseDir:     longDir,
+			expectedErr: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			dir, err := makeSocketDir(testCase.dir, testCase.baseDir, defaultPort)
+			if testCase.expectedErr {
+				if err == nil {
+					t.Fatalf("makeSocketDir(%q, ...) = %q; want error", testCase.dir, dir)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error from makeSocketDir: %s", err)
+			}
+
+			if testCase.expectSame {
+				if dir != testCase.dir {
+					t.Errorf("makeSocketDir(%q, ...) = %q; want %q", testCase.dir, dir, testCase.dir)
+				}
+				return
+			}
+
+			if filepath.Dir(dir) != testCase.baseDir {
+				t.Errorf("makeSocketDir(%q, ...) = %q; want directory in %q", testCase.dir, dir, testCase.baseDir)
+			}
+			if n := len(socketPath(dir, defaultPort)); n > maxSocketPathLen {
+				t.Errorf("socket path in %q is %d bytes; want at most %d", dir, n, maxSocketPathLen)
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				t.Errorf("os.Stat(%q) = %v, %v; want directory", dir, info, err)
+			}
+		})
+	}
+}
+
+// TestStart runs a real server, so is skipped if the binaries aren't
+// installed.
+func TestStart(t *testing.T) {
+	if _, err := findBinDir(os.Getenv("PGTEST_PG_BIN_DIR")); err != nil {
+		t.Skipf("skipping: %s", err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("skipping: postgres can't be run as root")
+	}
+
+	testCases := map[string][]Option{
+		"unix_socket": nil,
+		"tcp":         {WithTCP()},
+	}
+
+	for testName, opts := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+
+			s, err := Start(ctx, opts...)
+			if err != nil {
+				t.Fatalf("Start(ctx) = %s; want nil error", err)
+			}
+			defer func() {
+				if err := s.Stop(ctx); err != nil {
+					t.Errorf("s.Stop(ctx) = %s; want nil", err)
+				}
+
+				if _, err := os.Stat(s.dir); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("after stop os.Stat(s.dir) = %v; want %v", err, os.ErrNotExist)
+				}
+			}()
+
+			conn, err := pgx.Connect(ctx, connparams.New("postgres", s.ConnParams()...).URI().String())
+			if err != nil {
+				t.Fatalf("connect: %s", err)
+			}
+			defer conn.Close(ctx)
+
+			var fsync string
+			if err := conn.QueryRow(ctx, "SHOW fsync;").Scan(&fsync); err != nil {
+				t.Fatalf("show fsync: %s", err)
+			}
+
+			if fsync != "off" {
+				t.Errorf("fsync = %q; want %q", fsync, "off")
+			}
+		})
+	}
+}
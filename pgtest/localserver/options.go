@@ -0,0 +1,109 @@
+package localserver
+
+import (
+	"io"
+	"time"
+)
+
+type config struct {
+	binDir       string
+	user         string
+	tcp          bool
+	settings     map[string]string
+	startTimeout time.Duration
+	output       io.Writer
+
+	// ramDirs are the RAM-backed directories to try storing the data
+	// directory in. If empty, the data directory is stored on disk.
+	ramDirs       []string
+	minFreeMemory uint64
+}
+
+// Option is a parameter to configure the local server.
+type Option interface {
+	apply(*config)
+}
+
+type optFn func(*config)
+
+func (fn optFn) apply(c *config) { fn(c) }
+
+// WithBinDir returns an option specifying the directory containing the
+// initdb, pg_ctl, and postgres binaries. By default the PGTEST_PG_BIN_DIR
+// environment variable is used if set, otherwise the binaries are looked up
+// on the PATH.
+func WithBinDir(dir string) Option {
+	return optFn(func(c *config) {
+		c.binDir = dir
+	})
+}
+
+// WithUser returns an option specifying the name of the superuser created by
+// initdb. Defaults to "postgres".
+func WithUser(user string) Option {
+	return optFn(func(c *config) {
+		c.user = user
+	})
+}
+
+// WithTCP returns an option which makes the server listen on a free port on
+// the loopback interface. By default the server only listens on a unix domain
+// socket in its temporary directory, or under /tmp if that path would be too
+// long for a unix domain socket.
+func WithTCP() Option {
+	return optFn(func(c *config) {
+		c.tcp = true
+	})
+}
+
+// WithSetting returns an option specifying a server configuration parameter,
+// which is written to postgresql.conf. This takes precedence over the
+// test-friendly defaults (e.g. "fsync=off").
+func WithSetting(name, value string) Option {
+	return optFn(func(c *config) {
+		c.settings[name] = value
+	})
+}
+
+// WithStartTimeout returns an option specifying how long to wait for the server
+// to start. Defaults to 60 seconds.
+func WithStartTimeout(timeout time.Duration) Option {
+	return optFn(func(c *config) {
+		c.startTimeout = timeout
+	})
+}
+
+// WithOutput returns an option specifying where to write the output of initdb
+// and pg_ctl. By default it is discarded, although the server log is always
+// written to postgres.log in the server's temporary directory.
+func WithOutput(w io.Writer) Option {
+	return optFn(func(c *config) {
+		c.output = w
+	})
+}
+
+// WithRAMStorage returns an option which stores the server's data directory on
+// a RAM-backed filesystem if one is available, which considerably speeds up
+// creating and dropping databases. The first of the directories which is
+// writable and on a tmpfs (or ramfs) is used, defaulting to /dev/shm if none
+// are specified. If none of the directories are suitable, or RAM-backed
+// filesystems can't be detected on this system, the data directory is stored
+// on disk as usual; use Server.Storage to check which was used.
+func WithRAMStorage(dirs ...string) Option {
+	return optFn(func(c *config) {
+		if len(dirs) == 0 {
+			dirs = []string{defaultRAMDir}
+		}
+		c.ramDirs = dirs
+	})
+}
+
+// WithMinFreeMemory returns an option specifying the minimum available memory,
+// in bytes, required to store the data directory in RAM. If RAM storage is
+// used and less memory is available, Start returns ErrInsufficientMemory.
+// Defaults to 1 GiB.
+func WithMinFreeMemory(bytes uint64) Option {
+	return optFn(func(c *config) {
+		c.minFreeMemory = bytes
+	})
+}
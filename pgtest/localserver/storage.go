@@ -0,0 +1,113 @@
+package localserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Storage describes where the server's data directory is stored.
+type Storage uint
+
+const (
+	// The data directory is stored on disk, in the default temporary
+	// directory.
+	StorageDisk Storage = iota
+
+	// The data directory is stored on a RAM-backed filesystem (e.g.
+	// /dev/shm), which avoids most of the I/O cost of creating and dropping
+	// databases.
+	StorageRAM
+)
+
+func (s Storage) String() string {
+	//exhaustive:enforce
+	switch s {
+	case StorageDisk:
+		return "disk"
+	case StorageRAM:
+		return "ram"
+	}
+
+	return fmt.Sprintf("Storage(%d)", uint(s))
+}
+
+const (
+	// defaultRAMDir is the RAM-backed directory tried by WithRAMStorage if
+	// no directories are specified.
+	defaultRAMDir = "/dev/shm"
+
+	// defaultMinFreeMemory is the minimum amount of available memory
+	// required to store the data directory in RAM.
+	defaultMinFreeMemory = 1 << 30 // 1 GiB
+)
+
+// ErrInsufficientMemory is returned by Start if RAM storage was requested but
+// the available memory is below the configured threshold.
+var ErrInsufficientMemory = errors.New("localserver: insufficient free memory")
+
+// storageProbe provides information about the system for choosing where to
+// store the data directory. This allows the choice to be tested independent
+// of the actual system.
+type storageProbe struct {
+	// isRAMBacked reports whether dir is on a RAM-backed filesystem.
+	isRAMBacked func(dir string) (bool, error)
+
+	// availableMemory returns the available memory in bytes, or false if
+	// it can't be determined on this system.
+	availableMemory func() (uint64, bool)
+}
+
+var systemStorageProbe = storageProbe{
+	isRAMBacked:     isRAMBacked,
+	availableMemory: availableMemory,
+}
+
+// chooseStorage returns the directory to create the server's temporary
+// directory in, along with the kind of storage it is on. An empty directory
+// indicates the default temporary directory.
+//
+// The first of the ramDirs which exists, is writable, and is RAM-backed is
+// used, falling back to disk if none are. However, if a RAM-backed directory
+// is found but the available memory is below minFreeMemory, an error is
+// returned rather than falling back, since the caller asked for RAM storage
+// and the system is likely already under memory pressure.
+func chooseStorage(ramDirs []string, minFreeMemory uint64, probe storageProbe) (string, Storage, error) {
+	for _, dir := range ramDirs {
+		if !isWritableDir(dir) {
+			continue
+		}
+
+		if ok, err := probe.isRAMBacked(dir); err != nil || !ok {
+			continue
+		}
+
+		if available, ok := probe.availableMemory(); ok && available < minFreeMemory {
+			return "", StorageDisk, fmt.Errorf(
+				"%w to store data directory in %s: %d bytes available, %d required",
+				ErrInsufficientMemory, dir, available, minFreeMemory,
+			)
+		}
+
+		return dir, StorageRAM, nil
+	}
+
+	return "", StorageDisk, nil
+}
+
+func isWritableDir(dir string) bool {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+
+	f, err := os.CreateTemp(dir, ".pgtest-probe-")
+	if err != nil {
+		return false
+	}
+
+	f.Close()
+	_ = os.Remove(f.Name())
+
+	return true
+}
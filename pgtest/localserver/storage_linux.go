@@ -0,0 +1,56 @@
+//go:build linux
+
+package localserver
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Filesystem magic numbers, from linux/magic.h.
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+func isRAMBacked(dir string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false, err
+	}
+
+	// The type of Type differs between architectures, but the magic
+	// numbers fit in 32 bits.
+	fsType := uint32(st.Type)
+	return fsType == tmpfsMagic || fsType == ramfsMagic, nil
+}
+
+// availableMemory returns the MemAvailable reported by /proc/meminfo.
+func availableMemory() (uint64, bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The line is formatted like "MemAvailable:   12345678 kB".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+
+		return kb * 1024, true
+	}
+
+	return 0, false
+}
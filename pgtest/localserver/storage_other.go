@@ -0,0 +1,13 @@
+//go:build !linux
+
+package localserver
+
+// RAM-backed storage is only detected on linux, so other systems always fall
+// back to disk.
+func isRAMBacked(string) (bool, error) {
+	return false, nil
+}
+
+func availableMemory() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,114 @@
+package localserver
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func fakeStorageProbe(ramBacked map[string]bool, available uint64, availableKnown bool) storageProbe {
+	return storageProbe{
+		isRAMBacked: func(dir string) (bool, error) {
+			return ramBacked[dir], nil
+		},
+		availableMemory: func() (uint64, bool) {
+			return available, availableKnown
+		},
+	}
+}
+
+func TestChooseStorage(t *testing.T) {
+	var (
+		ramDir  = t.TempDir()
+		diskDir = t.TempDir()
+		missing = filepath.Join(t.TempDir(), "missing")
+	)
+
+	testCases := map[string]struct {
+		ramDirs         []string
+		probe           storageProbe
+		expectedDir     string
+		expectedStorage Storage
+		expectedErr     error
+	}{
+		"ram_not_requested": {
+			ramDirs:         nil,
+			probe:           fakeStorageProbe(map[string]bool{ramDir: true}, 1<<40, true),
+			expectedDir:     "",
+			expectedStorage: StorageDisk,
+		},
+		"ram_available": {
+			ramDirs:         []string{ramDir},
+			probe:           fakeStorageProbe(map[string]bool{ramDir: true}, 1<<40, true),
+			expectedDir:     ramDir,
+			expectedStorage: StorageRAM,
+		},
+		"skips_missing_and_non_ram_dirs": {
+			ramDirs:         []string{missing, diskDir, ramDir},
+			probe:           fakeStorageProbe(map[string]bool{ramDir: true, missing: true}, 1<<40, true),
+			expectedDir:     ramDir,
+			expectedStorage: StorageRAM,
+		},
+		"falls_back_to_disk": {
+			ramDirs:         []string{missing, diskDir},
+			probe:           fakeStorageProbe(nil, 1<<40, true),
+			expectedDir:     "",
+			expectedStorage: StorageDisk,
+		},
+		"insufficient_memory": {
+			ramDirs:     []string{ramDir},
+			probe:       fakeStorageProbe(map[string]bool{ramDir: true}, 1<<20, true),
+			expectedErr: ErrInsufficientMemory,
+		},
+		"unknown_memory": {
+			ramDirs:         []string{ramDir},
+			probe:           fakeStorageProbe(map[string]bool{ramDir: true}, 0, false),
+			expectedDir:     ramDir,
+			expectedStorage: StorageRAM,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			dir, storage, err := chooseStorage(testCase.ramDirs, defaultMinFreeMemory, testCase.probe)
+			if testCase.expectedErr != nil {
+				if !errors.Is(err, testCase.expectedErr) {
+					t.Fatalf("chooseStorage(...) = %v; want %v", err, testCase.expectedErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("chooseStorage(...) = %s; want nil error", err)
+			}
+
+			if dir != testCase.expectedDir || storage != testCase.expectedStorage {
+				t.Errorf("chooseStorage(...) = (%q, %s); want (%q, %s)", dir, storage, testCase.expectedDir, testCase.expectedStorage)
+			}
+		})
+	}
+}
+
+func TestIsRAMBackedDevShm(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skipping: RAM-backed filesystems are only detected on linux")
+	}
+
+	if !isWritableDir(defaultRAMDir) {
+		t.Skipf("skipping: %s is not a writable directory", defaultRAMDir)
+	}
+
+	ok, err := isRAMBacked(defaultRAMDir)
+	if err != nil {
+		t.Fatalf("isRAMBacked(%q) = %s; want nil error", defaultRAMDir, err)
+	}
+
+	if !ok {
+		t.Errorf("isRAMBacked(%q) = false; want true", defaultRAMDir)
+	}
+
+	if _, ok := availableMemory(); !ok {
+		t.Errorf("availableMemory() = (_, false); want (_, true)")
+	}
+}
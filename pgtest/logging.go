@@ -0,0 +1,119 @@
+package pgtest
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// The events logged to the logger specified by WithLogger, which are the value
+// of the "event" attribute.
+const (
+	eventCreate       = "create"
+	eventResetStart   = "reset_start"
+	eventResetEnd     = "reset_end"
+	eventInvalid      = "invalid"
+	eventAcquire      = "acquire"
+	eventRelease      = "release"
+	eventHijack       = "hijack"
+	eventDrop         = "drop"
+	eventCleanupError = "cleanup_error"
+	eventWarning      = "warning"
+)
+
+// An eventLogger logs lifecycle events as structured records. A nil
+// eventLogger logs nothing, for supervisors without WithLogger.
+type eventLogger slog.Logger
+
+// enabled returns whether events are logged. Warnings and errors which are
+// logged as events are only logged with the log package if not.
+func (l *eventLogger) enabled() bool {
+	return l != nil
+}
+
+func (l *eventLogger) log(level slog.Level, event, msg string, attrs ...slog.Attr) {
+	if l == nil {
+		return
+	}
+
+	attrs = append([]slog.Attr{slog.String("event", event)}, attrs...)
+	(*slog.Logger)(l).LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// testDBAttrs are the attributes identifying a test database.
+func testDBAttrs(db TestDB) []slog.Attr {
+	return []slog.Attr{
+		slog.String("db", db.Name()),
+		slog.String("server", db.Server()),
+	}
+}
+
+func (l *eventLogger) created(db TestDB, d time.Duration) {
+	l.log(slog.LevelInfo, eventCreate, "pgtest: created test db",
+		append(testDBAttrs(db), slog.Duration("duration", d))...,
+	)
+}
+
+func (l *eventLogger) resetStart(db TestDB, test string, op ResetTestDBOp) {
+	l.log(slog.LevelDebug, eventResetStart, "pgtest: resetting test db",
+		append(testDBAttrs(db), slog.String("test", test), slog.String("op", op.name()))...,
+	)
+}
+
+func (l *eventLogger) resetEnd(db TestDB, test string, op ResetTestDBOp, d time.Duration, err error) {
+	attrs := append(testDBAttrs(db),
+		slog.String("test", test),
+		slog.String("op", op.name()),
+		slog.Duration("duration", d),
+	)
+
+	if err != nil {
+		l.log(slog.LevelError, eventResetEnd, "pgtest: failed to reset test db", append(attrs, slog.Any("error", err))...)
+		return
+	}
+
+	l.log(slog.LevelDebug, eventResetEnd, "pgtest: reset test db", attrs...)
+}
+
+func (l *eventLogger) invalid(db TestDB, d time.Duration, err error) {
+	l.log(slog.LevelWarn, eventInvalid, "pgtest: test db failed validation",
+		append(testDBAttrs(db), slog.Duration("duration", d), slog.Any("error", err))...,
+	)
+}
+
+func (l *eventLogger) acquired(db TestDB, test string, wait time.Duration) {
+	l.log(slog.LevelDebug, eventAcquire, "pgtest: acquired test db",
+		append(testDBAttrs(db), slog.String("test", test), slog.Duration("duration", wait))...,
+	)
+}
+
+func (l *eventLogger) released(db TestDB, test string, held time.Duration) {
+	l.log(slog.LevelDebug, eventRelease, "pgtest: released test db",
+		append(testDBAttrs(db), slog.String("test", test), slog.Duration("duration", held))...,
+	)
+}
+
+func (l *eventLogger) hijacked(db TestDB, test string, held time.Duration) {
+	l.log(slog.LevelInfo, eventHijack, "pgtest: keeping test db for failed test",
+		append(testDBAttrs(db), slog.String("test", test), slog.Duration("duration", held))...,
+	)
+}
+
+func (l *eventLogger) dropped(db TestDB, d time.Duration, err error) {
+	attrs := append(testDBAttrs(db), slog.Duration("duration", d))
+
+	if err != nil {
+		l.log(slog.LevelError, eventDrop, "pgtest: failed to drop test db", append(attrs, slog.Any("error", err))...)
+		return
+	}
+
+	l.log(slog.LevelInfo, eventDrop, "pgtest: dropped test db", attrs...)
+}
+
+func (l *eventLogger) cleanupError(msg string, err error, attrs ...slog.Attr) {
+	l.log(slog.LevelError, eventCleanupError, "pgtest: "+msg, append(attrs, slog.Any("error", err))...)
+}
+
+func (l *eventLogger) warning(msg string, attrs ...slog.Attr) {
+	l.log(slog.LevelWarn, eventWarning, "pgtest: "+msg, attrs...)
+}
@@ -0,0 +1,241 @@
+package pgtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+// newTestEventLogger returns an eventLogger which logs JSON records to the
+// returned buffer.
+func newTestEventLogger() (*eventLogger, *bytes.Buffer) {
+	var b bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return (*eventLogger)(logger), &b
+}
+
+// loggedEvent is the part of a logged record which is checked by tests.
+type loggedEvent struct {
+	Level  string `json:"level"`
+	Event  string `json:"event"`
+	DB     string `json:"db"`
+	Server string `json:"server"`
+	Test   string `json:"test"`
+	Op     string `json:"op"`
+	Error  string `json:"error"`
+}
+
+func decodeLoggedEvents(t *testing.T, b *bytes.Buffer) []loggedEvent {
+	t.Helper()
+
+	var (
+		events []loggedEvent
+		dec    = json.NewDecoder(b)
+	)
+	for dec.More() {
+		var (
+			event  loggedEvent
+			record map[string]any
+		)
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("unexpected error decoding logged record: %s", err)
+		}
+
+		_, hasDuration := record["duration"]
+		if event := record["event"]; !hasDuration && event != eventResetStart && event != eventCleanupError && event != eventWarning {
+			t.Errorf("logged record has no duration: %v", record)
+		}
+
+		raw, _ := json.Marshal(record)
+		if err := json.Unmarshal(raw, &event); err != nil {
+			t.Fatalf("unexpected error decoding logged event: %s", err)
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func TestSupervisorLogsEvents(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPools := newMockShardedFactory(t, PlaceLeastLoaded, "a")
+	events, b := newTestEventLogger()
+
+	s := &testSupervisor{
+		inner:         newSupervisor(&config{events: events}, factory, nil),
+		events:        events,
+		shutdownState: new(shutdownState),
+	}
+
+	expectCreateDatabase(mockPools["a"])
+
+	var name string
+	t.Run("uses_db", func(t *testing.T) {
+		name = s.GetTestDB(t).Name()
+	})
+
+	mockPools["a"].
+		ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+		WillReturnError(errors.New("database is being accessed by other users"))
+
+	if err := s.Shutdown(ctx); err == nil {
+		t.Fatalf("s.Shutdown(ctx) = nil; want error dropping test db")
+	}
+
+	test := t.Name() + "/uses_db"
+	expected := []loggedEvent{
+		{Level: "INFO", Event: eventCreate, DB: name, Server: "a"},
+		{Level: "DEBUG", Event: eventAcquire, DB: name, Server: "a", Test: test},
+		{Level: "DEBUG", Event: eventRelease, DB: name, Server: "a", Test: test},
+		{Level: "ERROR", Event: eventDrop, DB: name, Server: "a", Error: "database is being accessed by other users"},
+		{Level: "ERROR", Event: eventCleanupError},
+	}
+
+	actual := decodeLoggedEvents(t, b)
+	// The cleanup error includes the error from the pool, which isn't
+	// worth matching exactly.
+	if len(actual) == len(expected) && actual[len(actual)-1].Error != "" {
+		actual[len(actual)-1].Error = ""
+	}
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected logged events (-want +got):\n%s", diff)
+	}
+}
+
+func TestResetTestDBLogsEvents(t *testing.T) {
+	ctx := context.Background()
+	events, b := newTestEventLogger()
+
+	// Nothing is listening on port 1, so the reset fails to connect.
+	testDB := &testDB{
+		server:     "default",
+		connparams: connparams.New("pg_test_1", connparams.WithHost("127.0.0.1"), connparams.WithPort(1)),
+	}
+
+	recorder := new(statsRecorder)
+	if err := resetTestDB(ctx, TruncateAllTables(), testDB, "TestFoo", recorder, events); err == nil {
+		t.Fatalf("resetTestDB(...) = nil; want error connecting")
+	}
+
+	actual := decodeLoggedEvents(t, b)
+	if len(actual) != 2 {
+		t.Fatalf("logged %d events; want 2 (events = %+v)", len(actual), actual)
+	}
+
+	if actual[1].Error == "" {
+		t.Errorf("reset_end event has no error")
+	}
+	actual[1].Error = ""
+
+	expected := []loggedEvent{
+		{Level: "DEBUG", Event: eventResetStart, DB: "pg_test_1", Server: "default", Test: "TestFoo", Op: "TruncateAllTables"},
+		{Level: "ERROR", Event: eventResetEnd, DB: "pg_test_1", Server: "default", Test: "TestFoo", Op: "TruncateAllTables"},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected logged events (-want +got):\n%s", diff)
+	}
+
+	if resets := recorder.snapshot().Resets; len(resets) != 0 {
+		t.Errorf("failed reset was recorded: %v", resets)
+	}
+}
+
+// failingWriter is an io.WriteCloser whose writes fail.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+func (failingWriter) Close() error              { return nil }
+
+func TestWarningsOnlyLoggedToLogger(t *testing.T) {
+	ctx := context.Background()
+
+	// Nothing is listening on port 1, so the test db can't be annotated.
+	db := &testDB{
+		server:     "default",
+		connparams: connparams.New("pg_test_1", connparams.WithHost("127.0.0.1"), connparams.WithPort(1)),
+	}
+
+	testCases := map[string]struct {
+		run      func(t *testing.T, events *eventLogger)
+		expected loggedEvent
+	}{
+		"annotate": {
+			run: func(t *testing.T, events *eventLogger) {
+				s := &testSupervisor{events: events}
+				s.writeAnnotation(ctx, db, newAnnotation(t.Name()))
+			},
+			expected: loggedEvent{Level: "WARN", Event: eventWarning, DB: "pg_test_1", Server: "default"},
+		},
+		"preflight": {
+			run: func(t *testing.T, events *eventLogger) {
+				mockPool, err := pgxmock.NewPool()
+				if err != nil {
+					t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+				}
+				defer mockPool.Close()
+
+				state := healthyPreflightState()
+				state.maxConnections = state.conns
+				expectPreflightState(mockPool, state)
+
+				if _, err := preflight(ctx, "default", mockPool, events); err != nil {
+					t.Fatalf("unexpected error from preflight: %s", err)
+				}
+			},
+			expected: loggedEvent{Level: "WARN", Event: eventWarning, Server: "default"},
+		},
+		"reaper": {
+			run: func(t *testing.T, events *eventLogger) {
+				rp := newReaper(failingWriter{}, func() error { return nil })
+				rp.events = events
+				rp.register(db)
+			},
+			expected: loggedEvent{Level: "ERROR", Event: eventCleanupError, DB: "pg_test_1", Server: "default", Op: "register"},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			events, b := newTestEventLogger()
+
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			testCase.run(t, events)
+
+			if logged.Len() != 0 {
+				t.Errorf("logged with the log package as well as the logger:\n%s", logged.String())
+			}
+
+			actual := decodeLoggedEvents(t, b)
+			if len(actual) != 1 {
+				t.Fatalf("logged %d events; want 1 (events = %+v)", len(actual), actual)
+			}
+
+			if actual[0].Error == "" {
+				t.Errorf("%s event has no error", actual[0].Event)
+			}
+			actual[0].Error = ""
+
+			if diff := cmp.Diff(testCase.expected, actual[0]); diff != "" {
+				t.Errorf("unexpected logged event (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNilEventLogger(t *testing.T) {
+	var events *eventLogger
+	events.created(&testDB{connparams: connparams.New("pg_test_1")}, 0)
+}
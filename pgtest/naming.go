@@ -0,0 +1,251 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDBNameLen is the longest identifier postgres allows, in bytes.
+	// Longer identifiers are silently truncated.
+	maxDBNameLen = 63
+
+	// maxDBNamePrefixLen is the longest prefix allowed by
+	// WithDBNamePrefix, which leaves room for the rest of the name.
+	maxDBNamePrefixLen = 32
+
+	// descriptiveDBNameTimeLayout is the layout of the time in the names
+	// given by DescriptiveDBName.
+	descriptiveDBNameTimeLayout = "060102150405"
+)
+
+// A DBNameHint describes a test database which is about to be created, for a
+// DBNamer to name it.
+type DBNameHint struct {
+	// Prefix is the prefix the name must start with (see
+	// WithDBNamePrefix).
+	Prefix string
+
+	// Package is the name of the test binary's package (e.g. "pgtest"),
+	// if known.
+	Package string
+
+	// Test is the name of the test the test database is being created for,
+	// if known. Since test databases are re-used, later tests may use it
+	// as well.
+	Test string
+
+	// Time is when the test database is being created.
+	Time time.Time
+
+	// Random is a random non-negative number, which is different each
+	// time a name is retried because a database with the previous name
+	// already exists.
+	Random int
+}
+
+// A DBNamer returns the name of a new test database. The name must start with
+// hint.Prefix, consist only of letters, digits and underscores, and be at most
+// 63 bytes long.
+type DBNamer func(hint DBNameHint) string
+
+// RandomDBName names test databases with the prefix followed by a random
+// number (e.g. "pg_test_5577006791947779410"). This is the default.
+func RandomDBName(hint DBNameHint) string {
+	return hint.Prefix + strconv.Itoa(hint.Random)
+}
+
+// DescriptiveDBName names test databases with the prefix followed by the
+// package and test names, the time and a random suffix (e.g.
+// "pg_test_pgtest_testfoo_bar_261018150405_1ly7vk"), which makes them easier to
+// identify. The package and test names are lowercased, with anything other
+// than letters and digits replaced by underscores, and are truncated as needed
+// to stay within postgres's 63 byte limit.
+func DescriptiveDBName(hint DBNameHint) string {
+	suffix := "_" + hint.Time.Format(descriptiveDBNameTimeLayout) + "_" + strconv.FormatInt(int64(hint.Random)%(36*36*36*36*36*36), 36)
+
+	var parts []string
+	for _, part := range []string{hint.Package, hint.Test} {
+		if part = sanitizeDBNamePart(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	desc := strings.Join(parts, "_")
+	if budget := maxDBNameLen - len(hint.Prefix) - len(suffix); len(desc) > budget {
+		desc = strings.TrimRight(desc[:max(budget, 0)], "_")
+	}
+
+	if desc == "" {
+		return hint.Prefix + strings.TrimPrefix(suffix, "_")
+	}
+
+	return hint.Prefix + desc + suffix
+}
+
+// descriptiveDBNameSuffix matches the time and random suffix at the end of the
+// names given by DescriptiveDBName.
+var descriptiveDBNameSuffix = regexp.MustCompile(`_(\d{12})_[0-9a-z]{1,6}$`)
+
+// descriptiveDBNameTime returns the time in a name given by DescriptiveDBName,
+// which is when the test database was created. The time is in the local time
+// zone of the test binary which created it, which is assumed to be the same as
+// the caller's.
+func descriptiveDBNameTime(name string) (time.Time, bool) {
+	match := descriptiveDBNameSuffix.FindStringSubmatch(name)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation(descriptiveDBNameTimeLayout, match[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// sanitizeDBNamePart lowercases s and replaces each run of characters other
+// than letters and digits with a single underscore.
+func sanitizeDBNamePart(s string) string {
+	var (
+		b          strings.Builder
+		underscore bool
+	)
+
+	for _, r := range strings.ToLower(s) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+			continue
+		}
+
+		if !underscore && b.Len() != 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+
+	return strings.TrimRight(b.String(), "_")
+}
+
+// isDBNameChar returns whether r is allowed in the names of test databases.
+func isDBNameChar(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '_'
+}
+
+// validateDBNamePrefix checks that prefix can be used by WithDBNamePrefix.
+// Since databases are treated as test databases by their prefix, a prefix
+// which starts with the default prefix (or vice versa) is rejected, as
+// otherwise one project could drop another's test databases.
+func validateDBNamePrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("test db name prefix must not be empty")
+	}
+
+	if !strings.HasSuffix(prefix, "_") {
+		return fmt.Errorf("test db name prefix %q must end with an underscore", prefix)
+	}
+
+	if prefix != testDBNamePrefix && (strings.HasPrefix(prefix, testDBNamePrefix) || strings.HasPrefix(testDBNamePrefix, prefix)) {
+		return fmt.Errorf("test db name prefix %q overlaps with the default prefix %q", prefix, testDBNamePrefix)
+	}
+
+	if len(prefix) > maxDBNamePrefixLen {
+		return fmt.Errorf("test db name prefix %q is longer than %d bytes", prefix, maxDBNamePrefixLen)
+	}
+
+	if strings.IndexFunc(prefix, func(r rune) bool { return !isDBNameChar(r) }) != -1 {
+		return fmt.Errorf("test db name prefix %q must only contain letters, digits and underscores", prefix)
+	}
+
+	return nil
+}
+
+// dbNaming describes how a testDBFactory names its test databases. The zero
+// value uses the default prefix and RandomDBName.
+type dbNaming struct {
+	prefix string
+	namer  DBNamer
+
+	// pkg is the name of the test binary's package.
+	pkg string
+}
+
+func (n dbNaming) namePrefix() string {
+	if n.prefix == "" {
+		return testDBNamePrefix
+	}
+
+	return n.prefix
+}
+
+// isTestDBName returns whether the named database is a test database, which is
+// the case if it has the prefix followed by the rest of a name.
+func (n dbNaming) isTestDBName(name string) bool {
+	prefix := n.namePrefix()
+	return len(name) > len(prefix) && strings.HasPrefix(name, prefix)
+}
+
+// name returns the name of a new test database.
+func (n dbNaming) name(ctx context.Context, random int) (string, error) {
+	hint := DBNameHint{
+		Prefix:  n.namePrefix(),
+		Package: n.pkg,
+		Test:    testNameFromContext(ctx),
+		Time:    time.Now(),
+		Random:  random,
+	}
+
+	namer := n.namer
+	if namer == nil {
+		namer = RandomDBName
+	}
+
+	name := namer(hint)
+	switch {
+	case !strings.HasPrefix(name, hint.Prefix):
+		return "", fmt.Errorf("test db name %q doesn't start with the prefix %q", name, hint.Prefix)
+	case len(name) > maxDBNameLen:
+		return "", fmt.Errorf("test db name %q is longer than %d bytes", name, maxDBNameLen)
+	case strings.IndexFunc(name, func(r rune) bool { return !isDBNameChar(r) }) != -1:
+		return "", fmt.Errorf("test db name %q must only contain letters, digits and underscores", name)
+	}
+
+	return name, nil
+}
+
+// testBinaryPackage guesses the name of the test binary's package from its
+// file name, which 'go test' names after the package (e.g. "pgtest.test").
+func testBinaryPackage() string {
+	name := filepath.Base(os.Args[0])
+	name = strings.TrimSuffix(name, ".exe")
+
+	pkg, ok := strings.CutSuffix(name, ".test")
+	if !ok {
+		return ""
+	}
+
+	return pkg
+}
+
+type testNameKey struct{}
+
+// withTestName returns a context carrying the name of the test a test database
+// is being acquired for, so it can be included in the names of new test
+// databases.
+func withTestName(ctx context.Context, testName string) context.Context {
+	return context.WithValue(ctx, testNameKey{}, testName)
+}
+
+func testNameFromContext(ctx context.Context) string {
+	testName, _ := ctx.Value(testNameKey{}).(string)
+	return testName
+}
@@ -0,0 +1,247 @@
+package pgtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescriptiveDBName(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 15, 4, 5, 0, time.UTC)
+
+	testCases := map[string]struct {
+		hint     DBNameHint
+		expected string
+	}{
+		"package_and_test": {
+			hint:     DBNameHint{Prefix: "pg_test_", Package: "store", Test: "TestFoo/bar", Time: now, Random: 35},
+			expected: "pg_test_store_testfoo_bar_261018150405_z",
+		},
+		"test_only": {
+			hint:     DBNameHint{Prefix: "pg_test_", Test: "TestFoo", Time: now, Random: 36},
+			expected: "pg_test_testfoo_261018150405_10",
+		},
+		"nothing_to_describe": {
+			hint:     DBNameHint{Prefix: "pg_test_", Time: now, Random: 1},
+			expected: "pg_test_261018150405_1",
+		},
+		"sanitized": {
+			hint:     DBNameHint{Prefix: "pg_test_", Package: "my-pkg", Test: "TestFoo/with spaces_and-CAPS!", Time: now, Random: 1},
+			expected: "pg_test_my_pkg_testfoo_with_spaces_and_caps_261018150405_1",
+		},
+		"truncated": {
+			hint: DBNameHint{
+				Prefix:  "pg_test_",
+				Package: "store",
+				Test:    "TestAVeryLongTestName/with_a_very_long_subtest_name",
+				Time:    now,
+				Random:  1,
+			},
+			expected: "pg_test_store_testaverylongtestname_with_a_very_261018150405_1",
+		},
+		"truncated_at_underscore": {
+			hint: DBNameHint{
+				Prefix: "pg_test_",
+				Test:   "TestAVeryLongTestName_with_a_very_longs/xyz",
+				Time:   now,
+				Random: 1,
+			},
+			expected: "pg_test_testaverylongtestname_with_a_very_longs_261018150405_1",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			name := DescriptiveDBName(testCase.hint)
+			if name != testCase.expected {
+				t.Errorf("DescriptiveDBName(%+v) = %q; want %q", testCase.hint, name, testCase.expected)
+			}
+
+			if len(name) > maxDBNameLen {
+				t.Errorf("len(%q) = %d; want at most %d", name, len(name), maxDBNameLen)
+			}
+		})
+	}
+}
+
+func TestDescriptiveDBNameTime(t *testing.T) {
+	created := time.Date(2026, time.October, 18, 15, 4, 5, 0, time.Local)
+
+	testCases := map[string]struct {
+		name       string
+		expectedOK bool
+	}{
+		"descriptive": {
+			name:       DescriptiveDBName(DBNameHint{Prefix: "pg_test_", Package: "store", Test: "TestFoo", Time: created, Random: 12345}),
+			expectedOK: true,
+		},
+		"nothing_to_describe": {
+			name:       DescriptiveDBName(DBNameHint{Prefix: "pg_test_", Time: created, Random: 1}),
+			expectedOK: true,
+		},
+		"random": {
+			name: RandomDBName(DBNameHint{Prefix: "pg_test_", Random: 261018150405}),
+		},
+		"invalid_time": {
+			name: "pg_test_store_261399150405_1",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			actual, ok := descriptiveDBNameTime(testCase.name)
+			if ok != testCase.expectedOK {
+				t.Fatalf("descriptiveDBNameTime(%q) = %v, %t; want ok %t", testCase.name, actual, ok, testCase.expectedOK)
+			}
+
+			if ok && !actual.Equal(created) {
+				t.Errorf("descriptiveDBNameTime(%q) = %v; want %v", testCase.name, actual, created)
+			}
+		})
+	}
+}
+
+func TestDBNamingName(t *testing.T) {
+	testCases := map[string]struct {
+		naming        dbNaming
+		expected      string
+		expectedError string
+	}{
+		"default": {
+			expected: "pg_test_42",
+		},
+		"custom_prefix": {
+			naming:   dbNaming{prefix: "proj_"},
+			expected: "proj_42",
+		},
+		"custom_namer": {
+			naming: dbNaming{
+				namer: func(hint DBNameHint) string {
+					return hint.Prefix + strings.ToLower(hint.Test)
+				},
+			},
+			expected: "pg_test_testfoo",
+		},
+		"namer_without_prefix": {
+			naming: dbNaming{
+				namer: func(DBNameHint) string { return "testfoo" },
+			},
+			expectedError: `test db name "testfoo" doesn't start with the prefix "pg_test_"`,
+		},
+		"namer_too_long": {
+			naming: dbNaming{
+				namer: func(hint DBNameHint) string { return hint.Prefix + strings.Repeat("x", 56) },
+			},
+			expectedError: "is longer than 63 bytes",
+		},
+		"namer_invalid_chars": {
+			naming: dbNaming{
+				namer: func(hint DBNameHint) string { return hint.Prefix + `foo"bar` },
+			},
+			expectedError: "must only contain letters, digits and underscores",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx := withTestName(context.Background(), "TestFoo")
+
+			name, err := testCase.naming.name(ctx, 42)
+			if testCase.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.expectedError) {
+					t.Fatalf("naming.name() = (%q, %v); want error containing %q", name, err, testCase.expectedError)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error from naming.name(): %s", err)
+			}
+			if name != testCase.expected {
+				t.Errorf("naming.name() = %q; want %q", name, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestValidateDBNamePrefix(t *testing.T) {
+	testCases := map[string]struct {
+		prefix      string
+		expectValid bool
+	}{
+		"default": {
+			prefix:      testDBNamePrefix,
+			expectValid: true,
+		},
+		"custom": {
+			prefix:      "myproject_test_",
+			expectValid: true,
+		},
+		"empty": {
+			prefix: "",
+		},
+		"too_long": {
+			prefix: strings.Repeat("x", maxDBNamePrefixLen+1),
+		},
+		"invalid_chars": {
+			prefix: "my-project_",
+		},
+		"no_trailing_underscore": {
+			prefix: "myproject",
+		},
+		"starts_with_default": {
+			prefix: testDBNamePrefix + "foo_",
+		},
+		"default_starts_with_prefix": {
+			prefix: "pg_",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			err := validateDBNamePrefix(testCase.prefix)
+			if testCase.expectValid && err != nil {
+				t.Errorf("validateDBNamePrefix(%q) = %s; want nil", testCase.prefix, err)
+			} else if !testCase.expectValid && err == nil {
+				t.Errorf("validateDBNamePrefix(%q) = nil; want error", testCase.prefix)
+			}
+		})
+	}
+}
+
+func TestDBNamingIsTestDBName(t *testing.T) {
+	testCases := map[string]struct {
+		naming   dbNaming
+		name     string
+		expected bool
+	}{
+		"default_prefix": {
+			name:     "pg_test_5577006791947779410",
+			expected: true,
+		},
+		"just_the_prefix": {
+			name: "pg_test_",
+		},
+		"other_database": {
+			name: "postgres",
+		},
+		"custom_prefix": {
+			naming:   dbNaming{prefix: "proj_a_"},
+			name:     "proj_a_1",
+			expected: true,
+		},
+		"default_prefix_with_custom_prefix": {
+			naming: dbNaming{prefix: "proj_a_"},
+			name:   "pg_test_1",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if got := testCase.naming.isTestDBName(testCase.name); got != testCase.expected {
+				t.Errorf("naming.isTestDBName(%q) = %t; want %t", testCase.name, got, testCase.expected)
+			}
+		})
+	}
+}
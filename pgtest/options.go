@@ -1,5 +1,13 @@
 package pgtest
 
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+)
+
 type Option interface {
 	apply(*config)
 }
@@ -16,6 +24,299 @@ func WithResetOp(op ResetTestDBOp) Option {
 	})
 }
 
+// WithConnParams returns an option which specifies additional parameters for
+// connecting to the postgres server. These take precedence over any
+// parameters specified through environment variables.
+func WithConnParams(opts ...connparams.Option) Option {
+	return optFn(func(c *config) {
+		c.connParamOpts = append(c.connParamOpts, opts...)
+	})
+}
+
+// A ManagedServer is a postgres server whose lifetime is managed by the
+// supervisor, such as one started by the localserver package.
+type ManagedServer interface {
+	// ConnParams returns the parameters for connecting to the server.
+	ConnParams() []connparams.Option
+
+	// Stop stops the server.
+	Stop(ctx context.Context) error
+}
+
+// WithManagedServer returns an option which connects to the specified server,
+// and stops the server when the supervisor is shutdown (e.g. at the end of
+// RunMain). The server's connection params take precedence over any
+// specified through environment variables.
+func WithManagedServer(s ManagedServer) Option {
+	return optFn(func(c *config) {
+		c.managedServers = append(c.managedServers, s)
+		c.connParamOpts = append(c.connParamOpts, s.ConnParams()...)
+	})
+}
+
+// WithServers returns an option which spreads test databases across multiple
+// postgres servers, for example to get past the CPU or connection limits of a
+// single server. Each server's connection params take precedence over those
+// shared by all servers, such as those specified through environment variables
+// or WithConnParams.
+//
+// If a server can't be reached it is taken out of rotation for a while, and
+// test databases are created on the other servers instead.
+func WithServers(servers ...Server) Option {
+	return optFn(func(c *config) {
+		c.serverOpts = append(c.serverOpts, servers...)
+	})
+}
+
+// WithPlacement returns an option which specifies how to decide which server
+// new test databases are created on when using WithServers. The default is
+// PlaceLeastLoaded.
+func WithPlacement(p Placement) Option {
+	return optFn(func(c *config) {
+		c.placement = p
+	})
+}
+
+// WithReadyTimeout returns an option which specifies how long NewSupervisor
+// waits for each server to accept connections, for example while it is
+// starting up. Errors which won't recover, such as authentication failures,
+// are returned immediately. If zero, each server is only checked once. The
+// default is 30 seconds, and can also be set through PGTEST_READY_TIMEOUT.
+func WithReadyTimeout(d time.Duration) Option {
+	return optFn(func(c *config) {
+		c.ready.timeout = d
+	})
+}
+
+// WithReadyBackoff returns an option which specifies how long to wait between
+// attempts to connect to a server which isn't ready yet. The first wait is
+// initial, which doubles after each attempt up to maximum.
+func WithReadyBackoff(initial, maximum time.Duration) Option {
+	return optFn(func(c *config) {
+		c.ready.initialBackoff = initial
+		c.ready.maxBackoff = maximum
+	})
+}
+
+// WithCoordinator returns an option which gets test databases from a
+// coordinator process, rather than a pool owned by the test binary. The
+// coordinator is started on demand by the first test binary to need it, and
+// is shared by all test binaries connecting to the same postgres server, so
+// running 'go test ./...' re-uses test databases across packages.
+//
+// The coordinator can also be enabled by setting PGTEST_COORDINATOR=true. It
+// is only supported on unix systems, and can't be combined with
+// WithManagedServer since the server would be stopped while the coordinator
+// is still using it.
+//
+// The coordinator is the test binary re-executed with
+// PGTEST_INTERNAL_COORDINATOR_SOCKET set, which an init function in this
+// package checks for before any tests run, running the coordinator and exiting
+// instead. The variable is removed from the environment as soon as it is read,
+// and must not be set otherwise, since any binary importing this package would
+// run as the coordinator.
+func WithCoordinator() Option {
+	return optFn(func(c *config) {
+		c.useCoordinator = true
+	})
+}
+
+// WithCoordinatorIdleTimeout returns an option which specifies how long the
+// coordinator waits without any connected test binaries before dropping its
+// test databases and exiting. The default is 30 seconds.
+//
+// This only applies if the coordinator is started by this test binary.
+func WithCoordinatorIdleTimeout(d time.Duration) Option {
+	return optFn(func(c *config) {
+		c.coordinatorIdleTimeout = d
+	})
+}
+
+// OnCreate returns an option which runs hook on each test database after it is
+// created, such as to install extensions. If hook fails, the test database is
+// dropped and the test trying to get it fails.
+//
+// Hooks of each kind run in the order they were specified, with a single
+// connection to the test database. OnCreate can't be combined with
+// WithCoordinator, since the coordinator process creates the test databases.
+func OnCreate(hook TestDBHook) Option {
+	return optFn(func(c *config) {
+		c.hooks.onCreate = append(c.hooks.onCreate, hook)
+	})
+}
+
+// OnAcquire returns an option which runs hook each time a test gets a test
+// database, after it is reset, such as to grant privileges to an app role. If
+// hook fails, the test fails.
+func OnAcquire(hook TestHook) Option {
+	return optFn(func(c *config) {
+		c.hooks.onAcquire = append(c.hooks.onAcquire, hook)
+	})
+}
+
+// OnRelease returns an option which runs hook once a test is done with a test
+// database, before it is released for re-use (or kept, if the test failed and
+// KeepDatabasesForFailed was specified), such as to capture stats. If hook
+// fails, the test fails but the test database is still released.
+func OnRelease(hook TestHook) Option {
+	return optFn(func(c *config) {
+		c.hooks.onRelease = append(c.hooks.onRelease, hook)
+	})
+}
+
+// OnDestroy returns an option which runs hook on each test database before it
+// is dropped. If hook fails, the error is logged and the test database is
+// still dropped.
+//
+// OnDestroy can't be combined with WithCoordinator, since the coordinator
+// process drops the test databases.
+func OnDestroy(hook TestDBHook) Option {
+	return optFn(func(c *config) {
+		c.hooks.onDestroy = append(c.hooks.onDestroy, hook)
+	})
+}
+
+// ValidateOnAcquire returns an option which checks that an idle test database
+// still exists and can be connected to before handing it to a test, along with
+// any invariants, such as the schema being intact. Test databases which fail
+// are dropped and transparently replaced by new ones, rather than causing
+// confusing failures in the next test to use them (e.g. after being dropped
+// manually, or left broken by a failed reset).
+//
+// ValidateOnAcquire can't be combined with WithCoordinator, since the
+// coordinator process hands out the test databases.
+func ValidateOnAcquire(invariants ...TestDBHook) Option {
+	return optFn(func(c *config) {
+		c.hooks.validate = true
+		c.hooks.invariants = append(c.hooks.invariants, invariants...)
+	})
+}
+
+// WithLogger returns an option which logs each event in the life of a test
+// database to logger as a structured record. Each record has an "event"
+// attribute naming the event, which is one of:
+//
+//   - "create": a test database was created (Info).
+//   - "reset_start", "reset_end": a test database was reset for a test by the
+//     reset op, named by the "op" attribute (Debug, or Error if it failed).
+//   - "invalid": an idle test database failed validation, and is being
+//     replaced (Warn).
+//   - "acquire": a test acquired a test database (Debug).
+//   - "release": a test released a test database for re-use (Debug).
+//   - "hijack": a test database was kept for a failed test (Info).
+//   - "drop": a test database was dropped (Info, or Error if it failed).
+//   - "cleanup_error": the supervisor couldn't clean up after itself (Error).
+//
+// Records also have "db" and "server" attributes identifying the test
+// database, "test" naming the test using it, "duration" with how long the
+// event took (or how long the test database was held, for "release" and
+// "hijack"), and "error" for failures, where these apply.
+//
+// Test databases are created and dropped by the coordinator process if
+// WithCoordinator is specified, so those events aren't logged.
+func WithLogger(logger *slog.Logger) Option {
+	return optFn(func(c *config) {
+		c.events = (*eventLogger)(logger)
+	})
+}
+
+// WithReaper returns an option which starts a reaper process alongside the
+// supervisor. If the test binary dies without shutting down the supervisor
+// (e.g. because a test panicked), the reaper drops the test databases it had
+// created. Test databases kept by KeepDatabasesForFailed are left alone.
+//
+// The reaper can also be enabled by setting PGTEST_REAPER=true. It has no
+// effect with WithCoordinator, since the coordinator already drops the test
+// databases of test binaries which exit.
+//
+// The reaper is the test binary re-executed with PGTEST_INTERNAL_REAPER set,
+// which an init function in this package checks for before any tests run,
+// running the reaper and exiting instead. The variable is removed from the
+// environment as soon as it is read, and must not be set otherwise, since any
+// binary importing this package would run as the reaper.
+func WithReaper() Option {
+	return optFn(func(c *config) {
+		c.useReaper = true
+	})
+}
+
+// WithIdleTimeout returns an option which drops test databases once they have
+// been idle for d, so the pool shrinks again after a burst of parallel tests
+// rather than keeping every test database until the supervisor is shutdown.
+//
+// It has no effect with WithCoordinator, since the coordinator process owns
+// the test databases.
+func WithIdleTimeout(d time.Duration) Option {
+	return optFn(func(c *config) {
+		c.idle.timeout = d
+	})
+}
+
+// WithMaxIdle returns an option which keeps at most n idle test databases,
+// dropping the ones which have been idle the longest. If tests are split
+// between servers by ForEachServer, the maximum applies to each server
+// separately.
+//
+// It has no effect with WithCoordinator, since the coordinator process owns
+// the test databases.
+func WithMaxIdle(n int) Option {
+	return optFn(func(c *config) {
+		c.idle.max = n
+	})
+}
+
+// WithDBNamePrefix returns an option which starts the names of test databases
+// with prefix rather than "pg_test_". Only databases with the prefix are
+// treated as test databases, so projects sharing a server should each use
+// their own prefix, neither of which starts with the other. The prefix must be
+// at most 32 bytes, only contain letters, digits and underscores, and end with
+// an underscore. Prefixes which start with "pg_test_", or which it starts with
+// (e.g. "pg_"), aren't allowed.
+//
+// The prefix can also be set with PGTEST_DB_PREFIX.
+func WithDBNamePrefix(prefix string) Option {
+	return optFn(func(c *config) {
+		c.naming.prefix = prefix
+	})
+}
+
+// WithDBNamer returns an option which names test databases with namer, such as
+// DescriptiveDBName. The default is RandomDBName.
+//
+// It has no effect with WithCoordinator, since the coordinator process
+// creates the test databases, although it still uses the prefix.
+func WithDBNamer(namer DBNamer) Option {
+	return optFn(func(c *config) {
+		c.naming.namer = namer
+	})
+}
+
+// WithAnnotations returns an option which writes an Annotation describing the
+// test using each test database to its comment, when the test gets it and
+// again if the test fails. The annotations can be read back with
+// ReadAnnotations, such as to find out which test a kept test database was for
+// and whether it is safe to drop.
+func WithAnnotations() Option {
+	return optFn(func(c *config) {
+		c.annotate = true
+	})
+}
+
+// WithTestAffinity returns an option which prefers handing each test an idle
+// test database last used by a test with the same name, or failing that by a
+// test with the same top-level test (i.e. a subtest in the same family). This
+// makes resets cheaper with a reset op that knows what each test wrote, and
+// makes cross-test contamination reproducible, since each test gets the same
+// sequence of test databases on every run.
+//
+// It has no effect with WithCoordinator, since the coordinator process hands
+// out the test databases.
+func WithTestAffinity() Option {
+	return optFn(func(c *config) {
+		c.testAffinity = true
+	})
+}
+
 // WithKeepDatabasesForFailed returns an option which controls whether or not
 // to keep test databases if a test using them fails.
 func WithKeepDatabasesForFailed(v bool) Option {
@@ -59,3 +360,28 @@ func KeepExistingTestDBs() Option {
 	return WithKeepExistingTestDBs(true)
 }
 */
+
+// A RunOption configures RunMain.
+type RunOption interface {
+	applyRun(*runConfig)
+}
+
+type runOptFn func(*runConfig)
+
+func (fn runOptFn) applyRun(c *runConfig) { fn(c) }
+
+// WithStatsSummary returns a RunOption which prints a summary of the
+// supervisor's stats to stderr once the tests are done.
+func WithStatsSummary() RunOption {
+	return runOptFn(func(c *runConfig) {
+		c.statsSummary = true
+	})
+}
+
+// WithStatsFile returns a RunOption which writes the supervisor's stats to the
+// file at path as JSON once the tests are done.
+func WithStatsFile(path string) RunOption {
+	return runOptFn(func(c *runConfig) {
+		c.statsFile = path
+	})
+}
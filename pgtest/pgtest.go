@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,34 +18,94 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-const defaultRootDBName = "postgres"
+const (
+	defaultRootDBName = "postgres"
+
+	defaultCoordinatorIdleTimeout = 30 * time.Second
+)
 
 func newConfig(opts ...Option) (*config, error) {
 	var connParamOpts []connparams.Option
 
 	if host := os.Getenv("PGTEST_HOST"); host != "" {
-		connParamOpts = append(connParamOpts, connparams.WithHost(host))
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_HOST", connparams.WithHosts(strings.Split(host, ",")...)))
 	}
 
 	if portRaw := os.Getenv("PGTEST_PORT"); portRaw != "" {
-		port, err := strconv.Atoi(portRaw)
-		if err != nil {
-			return nil, fmt.Errorf("parse PGTEST_PORT %q: %w", portRaw, err)
+		var ports []int
+		for _, s := range strings.Split(portRaw, ",") {
+			port, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("parse PGTEST_PORT %q: %w", portRaw, err)
+			}
+			ports = append(ports, port)
 		}
 
-		connParamOpts = append(connParamOpts, connparams.WithPort(port))
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_PORT", connparams.WithPorts(ports...)))
 	}
 
 	if u := os.Getenv("PGTEST_USER"); u != "" {
-		connParamOpts = append(connParamOpts, connparams.WithUser(u))
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_USER", connparams.WithUser(u)))
 	}
 
 	if p := os.Getenv("PGTEST_PASSWORD"); p != "" {
-		connParamOpts = append(connParamOpts, connparams.WithPassword(p))
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_PASSWORD", connparams.WithPassword(p)))
+	}
+
+	if a := os.Getenv("PGTEST_TARGET_SESSION_ATTRS"); a != "" {
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_TARGET_SESSION_ATTRS", connparams.WithTargetSessionAttrs(a)))
+	}
+
+	if m := os.Getenv("PGTEST_SSLMODE"); m != "" {
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_SSLMODE", connparams.WithSSLMode(m)))
+	}
+
+	if c := os.Getenv("PGTEST_SSLCERT"); c != "" {
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_SSLCERT", connparams.WithSSLCert(c)))
+	}
+
+	if k := os.Getenv("PGTEST_SSLKEY"); k != "" {
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_SSLKEY", connparams.WithSSLKey(k)))
+	}
+
+	if c := os.Getenv("PGTEST_SSLROOTCERT"); c != "" {
+		connParamOpts = append(connParamOpts, connparams.FromEnv("PGTEST_SSLROOTCERT", connparams.WithSSLRootCert(c)))
 	}
 
-	paramFactory := func(dbName string) connparams.ConnectionParams {
-		return connparams.NewWithDefaults(dbName, connParamOpts...)
+	ready := readyConfig{
+		timeout:        defaultReadyTimeout,
+		initialBackoff: defaultReadyInitialBackoff,
+		maxBackoff:     defaultReadyMaxBackoff,
+	}
+	if o := os.Getenv("PGTEST_READY_TIMEOUT"); o != "" {
+		var err error
+		ready.timeout, err = time.ParseDuration(o)
+		if err != nil {
+			return nil, fmt.Errorf("parse PGTEST_READY_TIMEOUT %q: %w", o, err)
+		}
+	}
+
+	var useCoordinator bool
+	if o := os.Getenv("PGTEST_COORDINATOR"); o != "" {
+		var err error
+		useCoordinator, err = strconv.ParseBool(o)
+		if err != nil {
+			return nil, fmt.Errorf("parse PGTEST_COORDINATOR %q: %w", o, err)
+		}
+	}
+
+	var useReaper bool
+	if o := os.Getenv("PGTEST_REAPER"); o != "" {
+		var err error
+		useReaper, err = strconv.ParseBool(o)
+		if err != nil {
+			return nil, fmt.Errorf("parse PGTEST_REAPER %q: %w", o, err)
+		}
+	}
+
+	naming := dbNaming{
+		prefix: os.Getenv("PGTEST_DB_PREFIX"),
+		pkg:    testBinaryPackage(),
 	}
 
 	var keepDatabasesForFailed bool
@@ -68,31 +132,90 @@ func newConfig(opts ...Option) (*config, error) {
 		resetOp:                DropAllTables(),
 		keepDatabasesForFailed: keepDatabasesForFailed,
 		//keepExistingTestDBs:    keepExistingTestDBs,
-		paramFactory: paramFactory,
+		connParamOpts: connParamOpts,
+		ready:         ready,
+
+		useCoordinator:         useCoordinator,
+		coordinatorIdleTimeout: defaultCoordinatorIdleTimeout,
+		useReaper:              useReaper,
+		naming:                 naming,
 	}
 
 	for _, opt := range opts {
 		opt.apply(c)
 	}
 
+	if c.naming.prefix != "" {
+		if err := validateDBNamePrefix(c.naming.prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	// Options are applied before building the paramFactory, so that
+	// connection params specified through options take precedence over
+	// the environment.
+	c.paramFactory = func(dbName string) connparams.ConnectionParams {
+		return connparams.NewWithDefaults(dbName, c.connParamOpts...)
+	}
+
+	servers, err := newServerConfigs(c.connParamOpts, c.serverOpts)
+	if err != nil {
+		return nil, err
+	}
+	c.servers = servers
+
 	return c, nil
 }
 
-func newTestDBFactory(ctx context.Context, conf *config) (*testDBFactory, error) {
-	var (
-		paramFactory = conf.paramFactory
-		rootDBName   = defaultRootDBName
-	)
+func newTestDBFactory(ctx context.Context, server serverConfig, ready readyConfig, naming dbNaming, preflights *preflightCache, events *eventLogger) (*testDBFactory, error) {
+	rootDBParams := server.paramFactory(defaultRootDBName)
+	if err := rootDBParams.Validate(); err != nil {
+		return nil, err
+	}
+
+	rootDSN := rootDBParams.URI().String()
+	factory, err := openTestDBFactory(ctx, server.name, rootDSN, server.paramFactory, naming)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server was already ready and passed the preflight checks.
+	if report, ok := preflights.get(rootDSN); ok {
+		factory.preflight = report
+		return factory, nil
+	}
+
+	// The pool connects lazily, so the server may not be ready by the
+	// time the first test database is created.
+	if err := waitReady(ctx, server.name, factory.rootDB.ping, ready); err != nil {
+		factory.close()
+		return nil, err
+	}
+
+	factory.preflight, err = preflight(ctx, server.name, factory.rootDB.db, events)
+	if err != nil {
+		factory.close()
+		return nil, err
+	}
+
+	preflights.put(rootDSN, factory.preflight)
+	return factory, nil
+}
 
-	rootDBPool, err := pgxpool.New(ctx, paramFactory(rootDBName).URI().String())
+// openTestDBFactory opens a testDBFactory which connects to the root db
+// through rootDSN, and uses paramFactory for the TestDBs it creates.
+func openTestDBFactory(ctx context.Context, serverName, rootDSN string, paramFactory connparamsFactory, naming dbNaming) (*testDBFactory, error) {
+	rootDBPool, err := pgxpool.New(ctx, rootDSN)
 	if err != nil {
-		return nil, fmt.Errorf("open %q: %s", rootDBName, err)
+		return nil, fmt.Errorf("open %q: %s", defaultRootDBName, err)
 	}
 
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 	return &testDBFactory{
+		server:       serverName,
 		paramFactory: paramFactory,
 		rootDB:       &rootDB{db: rootDBPool},
+		naming:       naming,
 		rng:          rng,
 	}, nil
 }
@@ -103,38 +226,142 @@ type Supervisor interface {
 	GetTestDB(t testing.TB) TestDB
 
 	// Shutdown shuts down the supervisor, dropping any test databases it
-	// owns.
+	// owns. Test databases which are still in use are dropped once they
+	// are released, or once ctx is done.
 	Shutdown(ctx context.Context) error
 }
 
+// A StatsReporter reports what a Supervisor has done so far, which the
+// supervisors returned by NewSupervisor do. RunMain can only report the stats
+// of supervisors which implement it.
+type StatsReporter interface {
+	Stats() Stats
+}
+
 type testSupervisor struct {
-	inner                  *supervisor
+	inner                  testDBSource
 	keepDatabasesForFailed bool
+
+	// servers are the servers test databases are created on, which is a
+	// single server for supervisors returned by forServer.
+	servers  []serverConfig
+	versions *serverVersionCache
+
+	hooks    hooks
+	events   *eventLogger
+	annotate bool
+
+	// shutdownState makes Shutdown idempotent, since it may be called
+	// both by RunMain and when the test binary is interrupted.
+	shutdownState *shutdownState
+}
+
+type shutdownState struct {
+	once sync.Once
+	err  error
+}
+
+// forServer returns a supervisor which only creates test databases on the
+// named server.
+func (s *testSupervisor) forServer(name string) (*testSupervisor, error) {
+	inner, err := s.inner.forServer(name)
+	if err != nil {
+		return nil, err
+	}
+
+	server := *s
+	server.inner = inner
+	server.shutdownState = new(shutdownState)
+	server.servers = slices.DeleteFunc(slices.Clone(s.servers), func(c serverConfig) bool {
+		return c.name != name
+	})
+	return &server, nil
 }
 
 // GetTestDB returns a db for use in testing.
 func (s *testSupervisor) GetTestDB(t testing.TB) TestDB {
 	ctx := context.Background()
-	dbResource, err := s.inner.getTestDB(ctx)
+	start := time.Now()
+	dbResource, err := s.inner.getTestDB(ctx, t.Name())
 	if err != nil {
 		t.Fatalf("get test db: %s", err)
 	}
 
+	testDB := tagTestDB(t, dbResource.Data())
+	if err := runTestHooks(ctx, s.hooks.onAcquire, t, testDB); err != nil {
+		dbResource.Release()
+		t.Fatalf("run OnAcquire hooks on test db %s: %s", testDB.Name(), err)
+	}
+
+	acquired := time.Now()
+	s.events.acquired(testDB, t.Name(), acquired.Sub(start))
+
+	var annotation Annotation
+	if s.annotate {
+		annotation = newAnnotation(t.Name())
+		s.writeAnnotation(ctx, testDB, annotation)
+	}
+
 	t.Cleanup(func() {
+		if err := runTestHooks(ctx, s.hooks.onRelease, t, testDB); err != nil {
+			t.Errorf("run OnRelease hooks on test db %s: %s", testDB.Name(), err)
+		}
+
+		if t.Failed() && s.annotate {
+			annotation.Failed = true
+			annotation.Kept = s.keepDatabasesForFailed
+			s.writeAnnotation(ctx, testDB, annotation)
+		}
+
 		if t.Failed() && s.keepDatabasesForFailed {
 			dbResource.Hijack()
-			t.Logf("keeping test db: %s", dbResource.Data().name())
+			s.events.hijacked(testDB, t.Name(), time.Since(acquired))
+			kept := dbResource.Data()
+			t.Logf("keeping test db: %s (connect with: %s)", kept.Name(), kept.psqlCommand())
 			return
 		}
 
 		dbResource.Release()
+		s.events.released(testDB, t.Name(), time.Since(acquired))
 	})
-	return dbResource.Data()
+	return testDB
+}
+
+// writeAnnotation writes a to the comment on testDB. Failing to do so doesn't
+// fail the test, since the test database can still be used.
+func (s *testSupervisor) writeAnnotation(ctx context.Context, testDB TestDB, a Annotation) {
+	if err := annotateTestDB(ctx, testDB, a); err != nil {
+		if s.events.enabled() {
+			s.events.warning("failed to annotate test db", append(testDBAttrs(testDB), slog.Any("error", err))...)
+		} else {
+			log.Printf("WARNING: pgtest: annotate test db %s: %s", testDB.Name(), err)
+		}
+	}
+}
+
+// tagTestDB tags connections to the TestDB with the name of the test using it,
+// so they can be identified in pg_stat_activity.
+func tagTestDB(t testing.TB, db TestDB) TestDB {
+	return db.withConnParams(connparams.WithApplicationName(t.Name()))
 }
 
 // Shutdown shuts down the supervisor, dropping any test databases it owns.
+// Only the first call shuts down the supervisor, and any concurrent calls wait
+// for it to finish.
 func (s *testSupervisor) Shutdown(ctx context.Context) error {
-	return s.inner.shutdown(ctx)
+	s.shutdownState.once.Do(func() {
+		s.shutdownState.err = s.inner.shutdown(ctx)
+		if s.shutdownState.err != nil {
+			s.events.cleanupError("failed to shutdown supervisor", s.shutdownState.err)
+		}
+	})
+
+	return s.shutdownState.err
+}
+
+// Stats returns what the supervisor has done so far.
+func (s *testSupervisor) Stats() Stats {
+	return s.inner.stats()
 }
 
 // NewSupervisor returns a new supervisor, which maintains a pool of test
@@ -145,15 +372,39 @@ func NewSupervisor(ctx context.Context, opts ...Option) (Supervisor, error) {
 		return nil, err
 	}
 
-	factory, err := newTestDBFactory(ctx, conf)
-	if err != nil {
-		return nil, err
+	var inner testDBSource
+	if conf.useCoordinator {
+		inner, err = newCoordinatedSource(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		factory, err := openShardedFactory(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+
+		var reaper *reaper
+		if conf.useReaper {
+			reaper, err = newConfiguredReaper(conf)
+			if err != nil {
+				factory.close()
+				return nil, fmt.Errorf("start reaper: %w", err)
+			}
+		}
+
+		inner = newSupervisor(conf, factory, reaper)
 	}
 
-	inner := newSupervisor(conf, factory)
 	s := &testSupervisor{
 		inner:                  inner,
 		keepDatabasesForFailed: conf.keepDatabasesForFailed,
+		servers:                conf.servers,
+		versions:               new(serverVersionCache),
+		hooks:                  conf.hooks,
+		events:                 conf.events,
+		annotate:               conf.annotate,
+		shutdownState:          new(shutdownState),
 	}
 
 	/*
@@ -167,7 +418,24 @@ func NewSupervisor(ctx context.Context, opts ...Option) (Supervisor, error) {
 	return s, nil
 }
 
-func RunMain(ctx context.Context, m *testing.M, supervisor Supervisor) (code int) {
+// RunMain runs the tests, then shuts down the supervisor.
+//
+// If the tests are interrupted (by SIGINT or SIGTERM), or are about to hit the
+// deadline set by the -test.timeout flag, the supervisor is shutdown early so
+// its test databases aren't left behind when the test binary exits. Any test
+// databases which couldn't be dropped are logged.
+//
+// Once the supervisor is shutdown, its stats are reported as specified by
+// opts (see WithStatsSummary and WithStatsFile).
+func RunMain(ctx context.Context, m *testing.M, supervisor Supervisor, opts ...RunOption) (code int) {
+	conf := new(runConfig)
+	for _, opt := range opts {
+		opt.applyRun(conf)
+	}
+
+	stop := watchForEmergencies(supervisor)
+	defer stop()
+
 	defer func() {
 		if err := supervisor.Shutdown(ctx); err != nil {
 			log.Printf("ERROR: pgtest: shutdown pgtest supervisor: %s", err)
@@ -175,11 +443,20 @@ func RunMain(ctx context.Context, m *testing.M, supervisor Supervisor) (code int
 				code = 11
 			}
 		}
+
+		if err := reportStats(conf, supervisor); err != nil {
+			log.Printf("ERROR: pgtest: report stats: %s", err)
+		}
 	}()
 
 	return m.Run()
 }
 
+// newTestDBPreflights caches the preflight checks run by NewTestDB, so that
+// only the first call for each server waits for it to be ready and logs any
+// warnings.
+var newTestDBPreflights preflightCache
+
 // NewTestDB returns a brand new TestDB that is dropped at the end of the test.
 func NewTestDB(t testing.TB) TestDB {
 	ctx := context.Background()
@@ -189,12 +466,12 @@ func NewTestDB(t testing.TB) TestDB {
 		t.Fatalf("load config: %s", err)
 	}
 
-	state, err := newTestDBFactory(ctx, conf)
+	state, err := newTestDBFactory(ctx, serverConfig{name: defaultServerName, paramFactory: conf.paramFactory}, conf.ready, conf.naming, &newTestDBPreflights, conf.events)
 	if err != nil {
 		t.Fatalf("create supervisor state: %s", err)
 	}
 
-	testDB, err := state.createTestDB(ctx)
+	testDB, err := state.createTestDB(withTestName(ctx, t.Name()))
 	if err != nil {
 		t.Fatalf("create test database: %v", err)
 	}
@@ -206,5 +483,5 @@ func NewTestDB(t testing.TB) TestDB {
 		state.close()
 	})
 
-	return testDB
+	return tagTestDB(t, testDB)
 }
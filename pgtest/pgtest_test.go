@@ -0,0 +1,90 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+)
+
+func TestNewSupervisorInvalidConnParams(t *testing.T) {
+	t.Setenv("PGTEST_SSLMODE", "verify_full")
+
+	ctx := context.Background()
+	s, err := NewSupervisor(ctx, WithConnParams(connparams.WithPort(0)))
+	if err == nil {
+		_ = s.Shutdown(ctx)
+		t.Fatalf("NewSupervisor(...) = nil error; want error")
+	}
+
+	var validationErr connparams.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("unexpectedly not errors.As(%q, connparams.ValidationError); err = %#v", err, err)
+	}
+
+	sources := make(map[string]string)
+	for _, fieldErr := range validationErr {
+		sources[fieldErr.Field] = fieldErr.Source
+	}
+
+	expectedSources := map[string]string{
+		"sslmode": "env var PGTEST_SSLMODE",
+		"port":    "option",
+	}
+
+	for field, expected := range expectedSources {
+		if actual := sources[field]; actual != expected {
+			t.Errorf("source of %s error = %q; want %q (err = %s)", field, actual, expected, err)
+		}
+	}
+}
+
+// A countingSource is a testDBSource which counts how many times it is
+// shutdown.
+type countingSource struct {
+	shutdowns atomic.Int32
+}
+
+func (s *countingSource) getTestDB(context.Context, string) (testDBLease, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *countingSource) stats() Stats {
+	return Stats{}
+}
+
+func (s *countingSource) forServer(string) (testDBSource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *countingSource) shutdown(context.Context) error {
+	s.shutdowns.Add(1)
+	return errors.New("shutdown failed")
+}
+
+func TestSupervisorShutdownIdempotent(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		source = new(countingSource)
+		s      = &testSupervisor{inner: source, shutdownState: new(shutdownState)}
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err == nil {
+				t.Errorf("s.Shutdown(ctx) = nil; want error from first shutdown")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := source.shutdowns.Load(); n != 1 {
+		t.Errorf("source shutdown %d times; want 1", n)
+	}
+}
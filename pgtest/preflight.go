@@ -0,0 +1,249 @@
+package pgtest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultTemplateDBName is the database copied by CREATE DATABASE.
+const defaultTemplateDBName = "template1"
+
+// dropForceMinServerVersionNum is the first server version supporting
+// DROP DATABASE ... WITH (FORCE).
+const dropForceMinServerVersionNum = 130000
+
+// A PreflightError is returned by NewSupervisor if a server is set up in a way
+// which prevents test databases from being created, such as the user not
+// having the CREATEDB privilege.
+type PreflightError struct {
+	// Server is the name of the server.
+	Server string
+
+	// Problems describes each problem with the server, along with how to
+	// fix it.
+	Problems []string
+}
+
+func (e *PreflightError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "preflight checks failed for server %s:", e.Server)
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+
+	return b.String()
+}
+
+// preflightState is the state of a server which is checked before using it.
+type preflightState struct {
+	user               string
+	superuser          bool
+	createDB           bool
+	roleConnLimit      int
+	serverVersionNum   int
+	maxConnections     int
+	reservedConns      int
+	conns              int
+	userConns          int
+	templateExists     bool
+	templateIsTemplate bool
+}
+
+func getPreflightState(ctx context.Context, q querier) (preflightState, error) {
+	rows, err := q.Query(ctx, `SELECT
+	r.rolname,
+	r.rolsuper,
+	r.rolcreatedb,
+	r.rolconnlimit,
+	current_setting('server_version_num')::int,
+	current_setting('max_connections')::int,
+	current_setting('superuser_reserved_connections')::int,
+	(SELECT count(*) FROM pg_stat_activity WHERE datname IS NOT NULL)::int,
+	(SELECT count(*) FROM pg_stat_activity WHERE usename = r.rolname)::int,
+	EXISTS (SELECT 1 FROM pg_database WHERE datname = $1),
+	EXISTS (SELECT 1 FROM pg_database WHERE datname = $1 AND datistemplate)
+FROM pg_roles r WHERE r.rolname = current_user;`, defaultTemplateDBName)
+	if err != nil {
+		return preflightState{}, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, func(row pgx.CollectableRow) (preflightState, error) {
+		var s preflightState
+		err := row.Scan(
+			&s.user,
+			&s.superuser,
+			&s.createDB,
+			&s.roleConnLimit,
+			&s.serverVersionNum,
+			&s.maxConnections,
+			&s.reservedConns,
+			&s.conns,
+			&s.userConns,
+			&s.templateExists,
+			&s.templateIsTemplate,
+		)
+		return s, err
+	})
+}
+
+// A preflightReport is the result of checking a server before using it.
+type preflightReport struct {
+	// problems prevent test databases from being created on the server.
+	problems []string
+
+	// warnings may cause tests to fail, but don't prevent test databases
+	// from being created.
+	warnings []string
+
+	// dropForce is whether the server supports DROP DATABASE ... WITH
+	// (FORCE).
+	dropForce bool
+}
+
+// checkPreflightState checks that test databases can be created on a server
+// in the specified state. Up to wantConns connections are expected to be used
+// at the same time.
+func checkPreflightState(s preflightState, wantConns int) *preflightReport {
+	report := &preflightReport{
+		dropForce: s.serverVersionNum >= dropForceMinServerVersionNum,
+	}
+
+	if !s.superuser && !s.createDB {
+		report.problems = append(report.problems, fmt.Sprintf(
+			"role %q can't create databases; grant it with: ALTER ROLE %q CREATEDB;",
+			s.user, s.user,
+		))
+	}
+
+	// Superusers aren't subject to their role's connection limit.
+	if !s.superuser {
+		if s.roleConnLimit == 0 {
+			report.problems = append(report.problems, fmt.Sprintf(
+				"role %q isn't allowed any connections; remove the limit with: ALTER ROLE %q CONNECTION LIMIT -1;",
+				s.user, s.user,
+			))
+		} else if s.roleConnLimit > 0 && s.roleConnLimit-s.userConns < wantConns {
+			report.warnings = append(report.warnings, fmt.Sprintf(
+				"role %q only has %d of its %d connections available, but tests may use up to %d at once; raise the limit with: ALTER ROLE %q CONNECTION LIMIT -1;",
+				s.user, s.roleConnLimit-s.userConns, s.roleConnLimit, wantConns, s.user,
+			))
+		}
+	}
+
+	available := s.maxConnections - s.conns
+	if !s.superuser {
+		available -= s.reservedConns
+	}
+	if available < wantConns {
+		report.warnings = append(report.warnings, fmt.Sprintf(
+			"server only has %d connections available (max_connections = %d), but tests may use up to %d at once; raise max_connections or lower -parallel",
+			available, s.maxConnections, wantConns,
+		))
+	}
+
+	if !s.templateExists {
+		report.problems = append(report.problems, fmt.Sprintf(
+			"template database %q doesn't exist, but test databases are created by copying it; re-create it from template0 with: CREATE DATABASE %s TEMPLATE template0 IS_TEMPLATE true;",
+			defaultTemplateDBName, defaultTemplateDBName,
+		))
+	} else if !s.templateIsTemplate && !s.superuser {
+		report.problems = append(report.problems, fmt.Sprintf(
+			"database %q isn't marked as a template, so only superusers and its owner can copy it; mark it with: ALTER DATABASE %s IS_TEMPLATE true;",
+			defaultTemplateDBName, defaultTemplateDBName,
+		))
+	}
+
+	return report
+}
+
+// runPreflight checks that test databases can be created on the server.
+func runPreflight(ctx context.Context, q querier) (*preflightReport, error) {
+	s, err := getPreflightState(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each test running in parallel uses a connection, plus one for the
+	// root db.
+	return checkPreflightState(s, maxParallelTests()+1), nil
+}
+
+// maxParallelTests returns how many tests may run at once, which is set by
+// -test.parallel. This defaults to GOMAXPROCS, which is also used if the flag
+// isn't registered (e.g. outside of a test binary).
+func maxParallelTests() int {
+	if f := flag.Lookup("test.parallel"); f != nil {
+		if n, err := strconv.Atoi(f.Value.String()); err == nil && n > 0 {
+			return n
+		}
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// A preflightCache caches the preflight report for each root DSN, so that the
+// server is only checked once. The nil cache caches nothing.
+type preflightCache struct {
+	mut     sync.Mutex
+	reports map[string]*preflightReport
+}
+
+func (c *preflightCache) get(rootDSN string) (*preflightReport, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	report, ok := c.reports[rootDSN]
+	return report, ok
+}
+
+func (c *preflightCache) put(rootDSN string, report *preflightReport) {
+	if c == nil {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.reports == nil {
+		c.reports = make(map[string]*preflightReport)
+	}
+	c.reports[rootDSN] = report
+}
+
+// preflight runs the preflight checks for the named server, logging any
+// warnings (to events if enabled) and returning a PreflightError for any
+// problems.
+func preflight(ctx context.Context, server string, q querier, events *eventLogger) (*preflightReport, error) {
+	report, err := runPreflight(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("run preflight checks for server %s: %w", server, err)
+	}
+
+	for _, warning := range report.warnings {
+		if events.enabled() {
+			events.warning("server failed a preflight check", slog.String("server", server), slog.String("error", warning))
+		} else {
+			log.Printf("WARNING: pgtest: server %s: %s", server, warning)
+		}
+	}
+
+	if len(report.problems) != 0 {
+		return nil, &PreflightError{Server: server, Problems: report.problems}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,282 @@
+package pgtest
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+// healthyPreflightState returns the state of a server which passes the
+// preflight checks.
+func healthyPreflightState() preflightState {
+	return preflightState{
+		user:               "foo",
+		createDB:           true,
+		roleConnLimit:      -1,
+		serverVersionNum:   160002,
+		maxConnections:     100,
+		reservedConns:      3,
+		conns:              5,
+		userConns:          1,
+		templateExists:     true,
+		templateIsTemplate: true,
+	}
+}
+
+// expectPreflightState expects the query for the preflight state, returning s.
+func expectPreflightState(mockPool pgxmock.PgxPoolIface, s preflightState) {
+	mockPool.
+		ExpectQuery(`SELECT .* FROM pg_roles r WHERE r.rolname = current_user;`).
+		WithArgs("template1").
+		WillReturnRows(pgxmock.NewRows([]string{
+			"rolname", "rolsuper", "rolcreatedb", "rolconnlimit",
+			"server_version_num", "max_connections", "superuser_reserved_connections",
+			"conns", "user_conns", "template_exists", "template_is_template",
+		}).AddRow(
+			s.user, s.superuser, s.createDB, s.roleConnLimit,
+			s.serverVersionNum, s.maxConnections, s.reservedConns,
+			s.conns, s.userConns, s.templateExists, s.templateIsTemplate,
+		))
+}
+
+func TestCheckPreflightState(t *testing.T) {
+	testCases := map[string]struct {
+		modify            func(s *preflightState)
+		expectedProblems  []string
+		expectedWarnings  []string
+		expectedDropForce bool
+	}{
+		"healthy": {
+			modify:            func(s *preflightState) {},
+			expectedDropForce: true,
+		},
+		"no_createdb": {
+			modify: func(s *preflightState) {
+				s.createDB = false
+			},
+			expectedProblems:  []string{`ALTER ROLE "foo" CREATEDB;`},
+			expectedDropForce: true,
+		},
+		"superuser_without_createdb": {
+			modify: func(s *preflightState) {
+				s.superuser = true
+				s.createDB = false
+			},
+			expectedDropForce: true,
+		},
+		"no_connections_allowed": {
+			modify: func(s *preflightState) {
+				s.roleConnLimit = 0
+			},
+			expectedProblems:  []string{`ALTER ROLE "foo" CONNECTION LIMIT -1;`},
+			expectedDropForce: true,
+		},
+		"low_role_connection_limit": {
+			modify: func(s *preflightState) {
+				s.roleConnLimit = 4
+			},
+			expectedWarnings:  []string{`role "foo" only has 3 of its 4 connections available`},
+			expectedDropForce: true,
+		},
+		"superuser_with_role_connection_limit": {
+			modify: func(s *preflightState) {
+				s.superuser = true
+				s.roleConnLimit = 0
+			},
+			expectedDropForce: true,
+		},
+		"superuser_with_low_role_connection_limit": {
+			modify: func(s *preflightState) {
+				s.superuser = true
+				s.roleConnLimit = 4
+			},
+			expectedDropForce: true,
+		},
+		"low_server_connections": {
+			modify: func(s *preflightState) {
+				s.maxConnections = 10
+			},
+			expectedWarnings:  []string{"server only has 2 connections available (max_connections = 10)"},
+			expectedDropForce: true,
+		},
+		"missing_template": {
+			modify: func(s *preflightState) {
+				s.templateExists = false
+				s.templateIsTemplate = false
+			},
+			expectedProblems:  []string{`template database "template1" doesn't exist`},
+			expectedDropForce: true,
+		},
+		"template_not_marked_as_template": {
+			modify: func(s *preflightState) {
+				s.templateIsTemplate = false
+			},
+			expectedProblems:  []string{"ALTER DATABASE template1 IS_TEMPLATE true;"},
+			expectedDropForce: true,
+		},
+		"multiple_problems": {
+			modify: func(s *preflightState) {
+				s.createDB = false
+				s.templateExists = false
+			},
+			expectedProblems: []string{
+				`ALTER ROLE "foo" CREATEDB;`,
+				`template database "template1" doesn't exist`,
+			},
+			expectedDropForce: true,
+		},
+		"no_drop_force": {
+			modify: func(s *preflightState) {
+				s.serverVersionNum = 120018
+			},
+			expectedDropForce: false,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			s := healthyPreflightState()
+			testCase.modify(&s)
+
+			report := checkPreflightState(s, 8)
+
+			checkMessages(t, "problems", report.problems, testCase.expectedProblems)
+			checkMessages(t, "warnings", report.warnings, testCase.expectedWarnings)
+
+			if report.dropForce != testCase.expectedDropForce {
+				t.Errorf("report.dropForce = %t; want %t", report.dropForce, testCase.expectedDropForce)
+			}
+		})
+	}
+}
+
+func TestMaxParallelTests(t *testing.T) {
+	f := flag.Lookup("test.parallel")
+	if f == nil {
+		t.Fatalf("test.parallel flag isn't registered")
+	}
+
+	orig := f.Value.String()
+	t.Cleanup(func() { _ = f.Value.Set(orig) })
+
+	if err := f.Value.Set("3"); err != nil {
+		t.Fatalf("unexpected error setting test.parallel: %s", err)
+	}
+
+	if n := maxParallelTests(); n != 3 {
+		t.Errorf("maxParallelTests() = %d with -test.parallel=3; want 3", n)
+	}
+}
+
+// checkMessages checks that each message contains the corresponding expected
+// substring.
+func checkMessages(t *testing.T, kind string, messages, expected []string) {
+	t.Helper()
+
+	if len(messages) != len(expected) {
+		t.Errorf("got %d %s; want %d (%s = %q)", len(messages), kind, len(expected), kind, messages)
+		return
+	}
+
+	for i, msg := range messages {
+		if !strings.Contains(msg, expected[i]) {
+			t.Errorf("%s[%d] = %q; want to contain %q", kind, i, msg, expected[i])
+		}
+	}
+}
+
+func TestGetPreflightState(t *testing.T) {
+	ctx := context.Background()
+
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+	defer mockPool.Close()
+
+	expected := healthyPreflightState()
+	expectPreflightState(mockPool, expected)
+
+	s, err := getPreflightState(ctx, mockPool)
+	if err != nil {
+		t.Fatalf("unexpected error from getPreflightState: %s", err)
+	}
+
+	if s != expected {
+		t.Errorf("getPreflightState(...) = %+v; want %+v", s, expected)
+	}
+
+	if err := mockPool.ExpectationsWereMet(); err != nil {
+		t.Errorf("mock pool has unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBFactoryDestroyTestDBForce(t *testing.T) {
+	ctx := context.Background()
+
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+	defer mockPool.Close()
+
+	factory := &testDBFactory{
+		paramFactory: func(dbName string) connparams.ConnectionParams {
+			return connparams.New(dbName)
+		},
+		rootDB:    &rootDB{db: mockPool},
+		rng:       rand.New(new(sequentialRandSource)),
+		preflight: &preflightReport{dropForce: true},
+	}
+
+	mockPool.
+		ExpectExec(regexp.QuoteMeta(`DROP DATABASE "pg_test_1234" WITH (FORCE);`)).
+		WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+
+	toDrop := &testDB{connparams: connparams.New("pg_test_1234")}
+	if err := factory.destroyTestDB(ctx, toDrop); err != nil {
+		t.Fatalf("factory.destroyTestDB(ctx, toDrop) = %s; want nil", err)
+	}
+
+	if err := mockPool.ExpectationsWereMet(); err != nil {
+		t.Errorf("mock pool has unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNewTestDBFactoryPreflightCache(t *testing.T) {
+	ctx := context.Background()
+
+	// Nothing listens on this port, so the server is never ready.
+	server := serverConfig{
+		name: defaultServerName,
+		paramFactory: func(dbName string) connparams.ConnectionParams {
+			return connparams.New(dbName, connparams.WithHost("127.0.0.1"), connparams.WithPort(1))
+		},
+	}
+
+	var preflights preflightCache
+	if _, err := newTestDBFactory(ctx, server, readyConfig{}, dbNaming{}, &preflights, nil); err == nil {
+		t.Fatalf("newTestDBFactory(...) = nil error; want error for unreachable server")
+	}
+
+	// Only reports for servers which passed the preflight checks are
+	// cached, and the server isn't checked again once they are.
+	report := &preflightReport{dropForce: true}
+	preflights.put(server.paramFactory(defaultRootDBName).URI().String(), report)
+
+	factory, err := newTestDBFactory(ctx, server, readyConfig{}, dbNaming{}, &preflights, nil)
+	if err != nil {
+		t.Fatalf("newTestDBFactory(...) with cached preflight = %s; want nil", err)
+	}
+	defer factory.close()
+
+	if factory.preflight != report {
+		t.Errorf("factory.preflight = %+v; want the cached report", factory.preflight)
+	}
+}
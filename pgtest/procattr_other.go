@@ -0,0 +1,12 @@
+//go:build !unix
+
+package pgtest
+
+import "syscall"
+
+// detachedProcAttr returns the attributes for a helper process which should
+// outlive the test binary. Helper processes can't be detached on this
+// platform.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build unix
+
+package pgtest
+
+import "syscall"
+
+// detachedProcAttr returns the attributes for a helper process which should
+// outlive the test binary, and not receive the signals sent to it by the
+// terminal (e.g. on Ctrl-C).
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,152 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultReadyTimeout        = 30 * time.Second
+	defaultReadyInitialBackoff = 50 * time.Millisecond
+	defaultReadyMaxBackoff     = 2 * time.Second
+)
+
+// readyConfig describes how to wait for a server to be ready.
+type readyConfig struct {
+	// timeout is how long to wait for the server to be ready. If zero, the
+	// server is only checked once.
+	timeout time.Duration
+
+	// initialBackoff is how long to wait after the first failed attempt,
+	// which doubles after each attempt up to maxBackoff.
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// A ServerNotReadyError is returned by NewSupervisor if a server isn't ready
+// to accept connections, either because it didn't become ready before the
+// timeout or because it failed in a way that won't recover.
+type ServerNotReadyError struct {
+	// Server is the name of the server.
+	Server string
+
+	// Reason is a short description of why the server isn't ready, e.g.
+	// "connection refused" or "authentication failed".
+	Reason string
+
+	// Attempts is the number of attempts to connect to the server.
+	Attempts int
+
+	// Err is the error from the last attempt.
+	Err error
+}
+
+func (e *ServerNotReadyError) Error() string {
+	return fmt.Sprintf("server %s is not ready (%s after %d attempts): %s", e.Server, e.Reason, e.Attempts, e.Err)
+}
+
+func (e *ServerNotReadyError) Unwrap() error {
+	return e.Err
+}
+
+// waitReady calls ping until it succeeds, backing off between attempts. Errors
+// which won't recover are returned immediately.
+func waitReady(ctx context.Context, server string, ping func(context.Context) error, conf readyConfig) error {
+	if conf.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.timeout)
+		defer cancel()
+	}
+
+	var (
+		backoff = conf.initialBackoff
+		lastErr *ServerNotReadyError
+	)
+
+	for attempts := 1; ; attempts++ {
+		err := ping(ctx)
+		if err == nil {
+			return nil
+		}
+
+		// The error from an attempt cut short by the timeout isn't
+		// useful, so the previous one is reported instead.
+		if ctx.Err() != nil && lastErr != nil {
+			return lastErr
+		}
+
+		reason, retry := classifyConnectError(err)
+		lastErr = &ServerNotReadyError{
+			Server:   server,
+			Reason:   reason,
+			Attempts: attempts,
+			Err:      err,
+		}
+
+		if !retry || conf.timeout <= 0 {
+			return lastErr
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return lastErr
+		case <-timer.C:
+		}
+
+		backoff = min(2*backoff, conf.maxBackoff)
+	}
+}
+
+// classifyConnectError returns a short description of an error connecting to
+// a server, and whether it may succeed if retried (e.g. if the server is still
+// starting up).
+func classifyConnectError(err error) (reason string, retry bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgerrcode.CannotConnectNow:
+			return "server is starting up", true
+		case pgerrcode.TooManyConnections:
+			return "too many connections", true
+		case pgerrcode.InvalidPassword, pgerrcode.InvalidAuthorizationSpecification:
+			return "authentication failed", false
+		case pgerrcode.InvalidCatalogName:
+			return "database does not exist", false
+		default:
+			return "server error " + pgErr.Code, false
+		}
+	}
+
+	var (
+		netErr net.Error
+		dnsErr *net.DNSError
+	)
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused", true
+	case errors.Is(err, os.ErrNotExist):
+		// The server hasn't created its unix domain socket yet.
+		return "socket does not exist", true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection reset", true
+	case errors.As(err, &dnsErr):
+		// The host may not be resolvable until its container starts.
+		return "host not found", true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "connection timed out", true
+	default:
+		return "connection failed", false
+	}
+}
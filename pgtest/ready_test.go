@@ -0,0 +1,110 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	errConnRefused = fmt.Errorf("failed to connect: %w", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)})
+	errStartingUp  = fmt.Errorf("failed to connect: %w", &pgconn.PgError{Severity: "FATAL", Code: pgerrcode.CannotConnectNow})
+	errAuth        = fmt.Errorf("failed to connect: %w", &pgconn.PgError{Severity: "FATAL", Code: pgerrcode.InvalidPassword})
+	errNoDatabase  = fmt.Errorf("failed to connect: %w", &pgconn.PgError{Severity: "FATAL", Code: pgerrcode.InvalidCatalogName})
+)
+
+func TestWaitReady(t *testing.T) {
+	testCases := map[string]struct {
+		timeout          time.Duration
+		pingErrs         []error
+		expectedAttempts int
+		expectedReason   string
+	}{
+		"immediately_ready": {
+			timeout:          time.Second,
+			pingErrs:         []error{nil},
+			expectedAttempts: 1,
+		},
+		"ready_after_starting_up": {
+			timeout:          time.Second,
+			pingErrs:         []error{errConnRefused, errStartingUp, errStartingUp, nil},
+			expectedAttempts: 4,
+		},
+		"auth_failure_fails_fast": {
+			timeout:          time.Second,
+			pingErrs:         []error{errStartingUp, errAuth},
+			expectedAttempts: 2,
+			expectedReason:   "authentication failed",
+		},
+		"missing_database_fails_fast": {
+			timeout:          time.Second,
+			pingErrs:         []error{errNoDatabase},
+			expectedAttempts: 1,
+			expectedReason:   "database does not exist",
+		},
+		"no_timeout_checks_once": {
+			timeout:          0,
+			pingErrs:         []error{errConnRefused, nil},
+			expectedAttempts: 1,
+			expectedReason:   "connection refused",
+		},
+		"times_out": {
+			timeout:        50 * time.Millisecond,
+			pingErrs:       nil, // always refused
+			expectedReason: "connection refused",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			var attempts int
+			ping := func(context.Context) error {
+				attempts++
+				if attempts > len(testCase.pingErrs) {
+					return errConnRefused
+				}
+				return testCase.pingErrs[attempts-1]
+			}
+
+			conf := readyConfig{
+				timeout:        testCase.timeout,
+				initialBackoff: time.Millisecond,
+				maxBackoff:     5 * time.Millisecond,
+			}
+
+			err := waitReady(context.Background(), "default", ping, conf)
+
+			if testCase.expectedAttempts != 0 && attempts != testCase.expectedAttempts {
+				t.Errorf("ping called %d times; want %d", attempts, testCase.expectedAttempts)
+			}
+
+			if testCase.expectedReason == "" {
+				if err != nil {
+					t.Fatalf("waitReady(...) = %s; want nil", err)
+				}
+				return
+			}
+
+			var notReadyErr *ServerNotReadyError
+			if !errors.As(err, &notReadyErr) {
+				t.Fatalf("unexpectedly not errors.As(%v, *ServerNotReadyError)", err)
+			}
+
+			if notReadyErr.Reason != testCase.expectedReason {
+				t.Errorf("err.Reason = %q; want %q (err = %s)", notReadyErr.Reason, testCase.expectedReason, err)
+			}
+
+			if testCase.expectedAttempts != 0 && notReadyErr.Attempts != testCase.expectedAttempts {
+				t.Errorf("err.Attempts = %d; want %d", notReadyErr.Attempts, testCase.expectedAttempts)
+			}
+		})
+	}
+}
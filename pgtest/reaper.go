@@ -0,0 +1,320 @@
+package pgtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// reaperEnv is set when a test binary is re-executed as the reaper process.
+const reaperEnv = "PGTEST_INTERNAL_REAPER"
+
+// internalEnvPrefix is the prefix of the environment variables set when a
+// test binary is re-executed as the reaper or the coordinator, which aren't
+// passed on to any other processes.
+const internalEnvPrefix = "PGTEST_INTERNAL_"
+
+// reaperDropTimeout bounds how long the reaper spends dropping test databases.
+const reaperDropTimeout = time.Minute
+
+func init() {
+	// This runs before any tests or TestMain, so the test binary can be
+	// re-executed as the reaper. The variable is removed as soon as it is
+	// read, so it isn't inherited by any processes started later.
+	value, ok := os.LookupEnv(reaperEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(reaperEnv)
+
+	if value != "" {
+		os.Exit(runReaper())
+	}
+}
+
+// reexecEnv returns the environment for re-executing the test binary with the
+// internal variable name set to value, without any other internal variables
+// inherited from this process.
+func reexecEnv(name, value string) []string {
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, internalEnvPrefix)
+	})
+	return append(env, name+"="+value)
+}
+
+// reaperConfig is the configuration passed to the reaper process over stdin,
+// before any messages.
+type reaperConfig struct {
+	Servers []serverDSN `json:"servers"`
+}
+
+type reaperOp string
+
+const (
+	// reaperOpRegister registers a test database to be dropped if the
+	// test binary dies.
+	reaperOpRegister reaperOp = "register"
+
+	// reaperOpUnregister unregisters a test database, since it was either
+	// dropped or is being kept.
+	reaperOpUnregister reaperOp = "unregister"
+
+	// reaperOpDone tells the reaper that the test binary shutdown
+	// normally, so it should exit without dropping anything.
+	reaperOpDone reaperOp = "done"
+)
+
+// A reaperMessage is sent from the test binary to the reaper over its stdin.
+type reaperMessage struct {
+	Op     reaperOp `json:"op"`
+	Server string   `json:"server,omitempty"`
+	DB     string   `json:"db,omitempty"`
+}
+
+type reapedDB struct {
+	server string
+	name   string
+}
+
+// reap reads messages from the test binary until it is done, returning the
+// test databases which are still registered if the test binary died first.
+func reap(r io.Reader) []reapedDB {
+	var (
+		dec        = json.NewDecoder(r)
+		registered = make(map[reapedDB]bool)
+		order      []reapedDB
+	)
+
+	for {
+		var msg reaperMessage
+		if err := dec.Decode(&msg); err != nil {
+			// The test binary died, which closed the pipe.
+			break
+		}
+
+		db := reapedDB{server: msg.Server, name: msg.DB}
+		switch msg.Op {
+		case reaperOpRegister:
+			registered[db] = true
+			order = append(order, db)
+		case reaperOpUnregister:
+			delete(registered, db)
+		case reaperOpDone:
+			return nil
+		}
+	}
+
+	var toDrop []reapedDB
+	for _, db := range order {
+		if registered[db] {
+			toDrop = append(toDrop, db)
+			delete(registered, db)
+		}
+	}
+
+	return toDrop
+}
+
+func runReaper() int {
+	dec := json.NewDecoder(os.Stdin)
+
+	var conf reaperConfig
+	if err := dec.Decode(&conf); err != nil {
+		log.Printf("ERROR: pgtest: reaper: read config: %s", err)
+		return 1
+	}
+
+	// The decoder may have buffered messages after the config.
+	toDrop := reap(io.MultiReader(dec.Buffered(), os.Stdin))
+	if len(toDrop) == 0 {
+		return 0
+	}
+
+	log.Printf("pgtest: reaper: test binary exited without shutting down, dropping %d test databases", len(toDrop))
+
+	ctx, cancel := context.WithTimeout(context.Background(), reaperDropTimeout)
+	defer cancel()
+
+	code := 0
+	for _, server := range conf.Servers {
+		var names []string
+		for _, db := range toDrop {
+			if db.server == server.Name {
+				names = append(names, db.name)
+			}
+		}
+
+		if len(names) == 0 {
+			continue
+		}
+
+		if err := reapServer(ctx, server, names); err != nil {
+			log.Printf("ERROR: pgtest: reaper: server %s: %s", server.Name, err)
+			code = 1
+		}
+	}
+
+	return code
+}
+
+func reapServer(ctx context.Context, server serverDSN, names []string) error {
+	conn, err := pgx.Connect(ctx, server.RootDSN)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	v, err := getServerVersion(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("get server version: %w", err)
+	}
+
+	var errs []error
+	for _, name := range names {
+		if err := dropDatabase(ctx, conn, name, v.num >= dropForceMinServerVersionNum); err != nil {
+			errs = append(errs, fmt.Errorf("drop %s: %w", name, err))
+			continue
+		}
+		log.Printf("pgtest: reaper: dropped %s", name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// A reaper is the test binary's side of the reaper process, which drops the
+// registered test databases if the test binary dies without shutting down the
+// supervisor (e.g. if a test panics).
+//
+// A nil reaper does nothing, for supervisors which weren't started with one.
+type reaper struct {
+	mut    sync.Mutex
+	w      io.WriteCloser
+	enc    *json.Encoder
+	broken bool
+	wait   func() error
+
+	// events logs the error if a message can't be sent, if enabled.
+	events *eventLogger
+}
+
+// startReaper starts the reaper process by re-executing the test binary. The
+// reaper notices that the test binary died when its stdin is closed.
+func startReaper(servers []serverDSN, events *eventLogger) (*reaper, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(exe)
+	cmd.Env = reexecEnv(reaperEnv, "1")
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = detachedProcAttr()
+
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	rp := newReaper(w, cmd.Wait)
+	rp.events = events
+	if err := rp.enc.Encode(reaperConfig{Servers: servers}); err != nil {
+		w.Close()
+		return nil, errors.Join(fmt.Errorf("write config: %w", err), cmd.Process.Kill())
+	}
+
+	return rp, nil
+}
+
+// newConfiguredReaper starts a reaper for the configured servers.
+func newConfiguredReaper(conf *config) (*reaper, error) {
+	servers, err := newServerDSNs(conf.servers)
+	if err != nil {
+		return nil, err
+	}
+
+	return startReaper(servers, conf.events)
+}
+
+func newReaper(w io.WriteCloser, wait func() error) *reaper {
+	return &reaper{
+		w:    w,
+		enc:  json.NewEncoder(w),
+		wait: wait,
+	}
+}
+
+func (r *reaper) send(msg reaperMessage) {
+	if r == nil {
+		return
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.broken {
+		return
+	}
+
+	if err := r.enc.Encode(msg); err != nil {
+		// The reaper can't do anything useful after missing a
+		// message, so the error is only logged once.
+		r.broken = true
+		if r.events.enabled() {
+			r.events.cleanupError("failed to send message to reaper", err,
+				slog.String("op", string(msg.Op)),
+				slog.String("db", msg.DB),
+				slog.String("server", msg.Server),
+			)
+		} else {
+			log.Printf("ERROR: pgtest: send %s to reaper: %s", msg.Op, err)
+		}
+	}
+}
+
+func (r *reaper) register(db TestDB) {
+	r.send(reaperMessage{Op: reaperOpRegister, Server: db.Server(), DB: db.Name()})
+}
+
+func (r *reaper) unregister(db TestDB) {
+	r.send(reaperMessage{Op: reaperOpUnregister, Server: db.Server(), DB: db.Name()})
+}
+
+// stop tells the reaper that the supervisor was shutdown, and waits for it to
+// exit.
+func (r *reaper) stop() error {
+	if r == nil {
+		return nil
+	}
+
+	r.send(reaperMessage{Op: reaperOpDone})
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.broken = true
+	if err := r.w.Close(); err != nil {
+		return err
+	}
+
+	return r.wait()
+}
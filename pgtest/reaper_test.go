@@ -0,0 +1,163 @@
+package pgtest
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReap(t *testing.T) {
+	testCases := map[string]struct {
+		messages       []string
+		expectedToDrop []reapedDB
+	}{
+		"died_with_registered": {
+			messages: []string{
+				`{"op":"register","server":"default","db":"pg_test_1"}`,
+				`{"op":"register","server":"default","db":"pg_test_2"}`,
+				`{"op":"register","server":"other","db":"pg_test_3"}`,
+				`{"op":"unregister","server":"default","db":"pg_test_1"}`,
+			},
+			expectedToDrop: []reapedDB{
+				{server: "default", name: "pg_test_2"},
+				{server: "other", name: "pg_test_3"},
+			},
+		},
+		"died_with_none_registered": {
+			messages: []string{
+				`{"op":"register","server":"default","db":"pg_test_1"}`,
+				`{"op":"unregister","server":"default","db":"pg_test_1"}`,
+			},
+		},
+		"died_mid_message": {
+			messages: []string{
+				`{"op":"register","server":"default","db":"pg_test_1"}`,
+				`{"op":"unregister","server":"def`,
+			},
+			expectedToDrop: []reapedDB{
+				{server: "default", name: "pg_test_1"},
+			},
+		},
+		"done": {
+			messages: []string{
+				`{"op":"register","server":"default","db":"pg_test_1"}`,
+				`{"op":"done"}`,
+			},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			toDrop := reap(strings.NewReader(strings.Join(testCase.messages, "\n")))
+
+			if diff := cmp.Diff(testCase.expectedToDrop, toDrop, cmp.AllowUnexported(reapedDB{})); diff != "" {
+				t.Errorf("reap(...) returned unexpected test dbs to drop (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReaperMessages(t *testing.T) {
+	r, w := io.Pipe()
+	rp := newReaper(w, func() error { return nil })
+
+	result := make(chan []reapedDB)
+	go func() {
+		toDrop := reap(r)
+		// Drain anything sent after done, so stop doesn't block.
+		_, _ = io.Copy(io.Discard, r)
+		result <- toDrop
+	}()
+
+	kept := &testDB{server: "default", connparams: connparams.New("pg_test_1")}
+	dropped := &testDB{server: "default", connparams: connparams.New("pg_test_2")}
+
+	rp.register(kept)
+	rp.register(dropped)
+	rp.unregister(kept)
+
+	// Closing the pipe without stopping the reaper is how it sees the test
+	// binary die.
+	w.Close()
+
+	expected := []reapedDB{{server: "default", name: "pg_test_2"}}
+	if diff := cmp.Diff(expected, <-result, cmp.AllowUnexported(reapedDB{})); diff != "" {
+		t.Errorf("reaper would drop unexpected test dbs (-want +got):\n%s", diff)
+	}
+}
+
+func TestReaperProcess(t *testing.T) {
+	rp, err := startReaper([]serverDSN{{Name: "default", RootDSN: "postgres://localhost:1/postgres"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error starting reaper: %s", err)
+	}
+
+	rp.register(&testDB{server: "default", connparams: connparams.New("pg_test_1")})
+
+	// The reaper exits cleanly without connecting to the server, since
+	// the supervisor was shutdown.
+	if err := rp.stop(); err != nil {
+		t.Errorf("rp.stop() = %s; want nil", err)
+	}
+}
+
+func TestReaperProcessParentDied(t *testing.T) {
+	rp, err := startReaper([]serverDSN{{Name: "default", RootDSN: "postgres://localhost:1/postgres?connect_timeout=5"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error starting reaper: %s", err)
+	}
+
+	rp.register(&testDB{server: "default", connparams: connparams.New("pg_test_1")})
+
+	// Closing the pipe without sending done is what happens when the test
+	// binary dies, so the reaper tries (and fails) to drop pg_test_1.
+	if err := rp.w.Close(); err != nil {
+		t.Fatalf("unexpected error closing pipe to reaper: %s", err)
+	}
+
+	var exitErr *exec.ExitError
+	if err := rp.wait(); !errors.As(err, &exitErr) {
+		t.Errorf("reaper exited with %v; want exit error from failing to connect", err)
+	}
+}
+
+func TestNilReaper(t *testing.T) {
+	var rp *reaper
+
+	rp.register(&testDB{server: "default", connparams: connparams.New("pg_test_1")})
+	if err := rp.stop(); err != nil {
+		t.Errorf("rp.stop() = %s; want nil", err)
+	}
+}
+
+func TestReexecEnv(t *testing.T) {
+	// Internal variables inherited from this process aren't passed on,
+	// such as if it is the coordinator and starts a reaper.
+	t.Setenv(reaperEnv, "1")
+	t.Setenv("PGTEST_INTERNAL_COORDINATOR_SOCKET", "/inherited/socket")
+	t.Setenv("PGTEST_HOST", "localhost")
+
+	env := reexecEnv(reaperEnv, "1")
+
+	var internal []string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, internalEnvPrefix) {
+			internal = append(internal, kv)
+		}
+	}
+
+	expected := []string{reaperEnv + "=1"}
+	if !slices.Equal(internal, expected) {
+		t.Errorf("reexecEnv(...) sets %v; want %v", internal, expected)
+	}
+
+	if !slices.Contains(env, "PGTEST_HOST=localhost") {
+		t.Errorf("reexecEnv(...) = %v; want the rest of the environment", env)
+	}
+}
@@ -3,6 +3,7 @@ package pgtest
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 )
@@ -10,6 +11,9 @@ import (
 type ResetTestDBOp interface {
 	run(ctx context.Context, q querier) error
 	isResetTestDBOP()
+
+	// name identifies the op in Stats.
+	name() string
 }
 
 func runResetTestDBOp(ctx context.Context, op ResetTestDBOp, testDB TestDB) error {
@@ -38,11 +42,29 @@ func runResetTestDBOp(ctx context.Context, op ResetTestDBOp, testDB TestDB) erro
 	return nil
 }
 
+// resetTestDB runs op on testDB for the named test, recording how long it took.
+func resetTestDB(ctx context.Context, op ResetTestDBOp, testDB TestDB, testName string, recorder *statsRecorder, events *eventLogger) error {
+	events.resetStart(testDB, testName, op)
+
+	start := time.Now()
+	err := runResetTestDBOp(ctx, op, testDB)
+	d := time.Since(start)
+
+	events.resetEnd(testDB, testName, op, d, err)
+	if err != nil {
+		return err
+	}
+
+	recorder.recordReset(op.name(), d)
+	return nil
+}
+
 type resetTestDBDropAllTables struct {
 	exclude []string
 }
 
 func (op *resetTestDBDropAllTables) isResetTestDBOP() {}
+func (op *resetTestDBDropAllTables) name() string     { return "DropAllTables" }
 func (op *resetTestDBDropAllTables) run(ctx context.Context, q querier) error {
 	return dropAllTables(ctx, q, &dropAllTablesArgs{
 		exclude: op.exclude,
@@ -64,6 +86,7 @@ type resetTestDBTruncateAllTables struct {
 }
 
 func (op *resetTestDBTruncateAllTables) isResetTestDBOP() {}
+func (op *resetTestDBTruncateAllTables) name() string     { return "TruncateAllTables" }
 func (op *resetTestDBTruncateAllTables) run(ctx context.Context, q querier) error {
 	return truncateAllTables(ctx, q, &truncateAllTablesArgs{
 		exclude: op.exclude,
@@ -16,6 +16,7 @@ type pgPool interface {
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
 	Close()
 	Config() *pgxpool.Config
+	Ping(context.Context) error
 }
 
 type rootDB struct {
@@ -45,8 +46,12 @@ func (db *rootDB) createDatabase(ctx context.Context, name string) error {
 	return nil
 }
 
-func (db *rootDB) dropDatabase(ctx context.Context, name string) error {
-	return dropDatabase(ctx, db.db, name)
+func (db *rootDB) dropDatabase(ctx context.Context, name string, force bool) error {
+	return dropDatabase(ctx, db.db, name, force)
+}
+
+func (db *rootDB) ping(ctx context.Context) error {
+	return db.db.Ping(ctx)
 }
 
 func (db *rootDB) getAllDatabases(ctx context.Context) ([]string, error) {
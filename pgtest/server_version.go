@@ -0,0 +1,156 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A serverVersion is the version of a postgres server.
+type serverVersion struct {
+	// version is the server_version setting, e.g. "16.2 (Debian 16.2-1)".
+	version string
+
+	// num is the server_version_num setting, e.g. 160002.
+	num int
+}
+
+// major returns the major version of the server, e.g. 16.
+func (v serverVersion) major() int {
+	return v.num / 10000
+}
+
+// short returns the version without any details about the build, e.g. "16.2".
+func (v serverVersion) short() string {
+	short, _, _ := strings.Cut(v.version, " ")
+	return short
+}
+
+func getServerVersion(ctx context.Context, q querier) (serverVersion, error) {
+	rows, err := q.Query(ctx, `SELECT current_setting('server_version'), current_setting('server_version_num')::int;`)
+	if err != nil {
+		return serverVersion{}, err
+	}
+
+	v, err := pgx.CollectExactlyOneRow(rows, func(row pgx.CollectableRow) (serverVersion, error) {
+		var v serverVersion
+		err := row.Scan(&v.version, &v.num)
+		return v, err
+	})
+	if err != nil {
+		return serverVersion{}, err
+	}
+
+	return v, nil
+}
+
+// serverVersionCache caches the version of each server, so each is only
+// queried once per supervisor.
+type serverVersionCache struct {
+	mut      sync.Mutex
+	versions map[string]serverVersion
+}
+
+func (c *serverVersionCache) get(ctx context.Context, server serverConfig) (serverVersion, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if v, ok := c.versions[server.name]; ok {
+		return v, nil
+	}
+
+	conn, err := pgx.Connect(ctx, server.paramFactory(defaultRootDBName).URI().String())
+	if err != nil {
+		return serverVersion{}, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	v, err := getServerVersion(ctx, conn)
+	if err != nil {
+		return serverVersion{}, err
+	}
+
+	if c.versions == nil {
+		c.versions = make(map[string]serverVersion)
+	}
+	c.versions[server.name] = v
+
+	return v, nil
+}
+
+func asTestSupervisor(t testing.TB, s Supervisor) *testSupervisor {
+	t.Helper()
+
+	ts, ok := s.(*testSupervisor)
+	if !ok {
+		t.Fatalf("supervisor %T was not returned by pgtest.NewSupervisor", s)
+	}
+
+	return ts
+}
+
+// ForEachServer runs fn as a subtest for each of the servers the supervisor
+// creates test databases on (see WithServers), named by the server's version.
+// The supervisor passed to fn only creates test databases on that server, and
+// is shutdown along with s.
+//
+// This is intended for testing against multiple versions of postgres, e.g.
+//
+//	pgtest.ForEachServer(t, pgtestSupervisor, func(t *testing.T, s pgtest.Supervisor) {
+//		db := s.GetTestDB(t)
+//		...
+//	})
+func ForEachServer(t *testing.T, s Supervisor, fn func(t *testing.T, s Supervisor)) {
+	t.Helper()
+
+	var (
+		ctx = context.Background()
+		ts  = asTestSupervisor(t, s)
+	)
+
+	for _, server := range ts.servers {
+		v, err := ts.versions.get(ctx, server)
+		if err != nil {
+			t.Fatalf("get version of server %s: %s", server.name, err)
+		}
+
+		serverSupervisor, err := ts.forServer(server.name)
+		if err != nil {
+			t.Fatalf("get supervisor for server %s: %s", server.name, err)
+		}
+
+		t.Run(v.short(), func(t *testing.T) {
+			fn(t, serverSupervisor)
+		})
+	}
+}
+
+// SkipUnlessServerVersionAtLeast skips the test unless the major version of
+// the servers the supervisor creates test databases on is at least major. If
+// the supervisor creates test databases on multiple servers, all of them must
+// be at least that version.
+//
+// The version of each server is only queried once per supervisor.
+func SkipUnlessServerVersionAtLeast(t testing.TB, s Supervisor, major int) {
+	t.Helper()
+
+	var (
+		ctx = context.Background()
+		ts  = asTestSupervisor(t, s)
+	)
+
+	for _, server := range ts.servers {
+		v, err := ts.versions.get(ctx, server)
+		if err != nil {
+			t.Fatalf("get version of server %s: %s", server.name, err)
+		}
+
+		if v.major() < major {
+			t.Skipf("server %s has version %s; need at least %d", server.name, v.short(), major)
+		}
+	}
+}
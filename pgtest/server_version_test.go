@@ -0,0 +1,69 @@
+package pgtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestGetServerVersion(t *testing.T) {
+	testCases := map[string]struct {
+		version       string
+		num           int
+		expectedMajor int
+		expectedShort string
+	}{
+		"release": {
+			version:       "16.2",
+			num:           160002,
+			expectedMajor: 16,
+			expectedShort: "16.2",
+		},
+		"with_build_details": {
+			version:       "13.14 (Debian 13.14-1.pgdg120+2)",
+			num:           130014,
+			expectedMajor: 13,
+			expectedShort: "13.14",
+		},
+		"before_10": {
+			version:       "9.6.24",
+			num:           90624,
+			expectedMajor: 9,
+			expectedShort: "9.6.24",
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+
+			mockPool, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+			}
+			defer mockPool.Close()
+
+			mockPool.
+				ExpectQuery(`SELECT current_setting\('server_version'\), current_setting\('server_version_num'\)::int;`).
+				WillReturnRows(pgxmock.NewRows([]string{"server_version", "server_version_num"}).AddRow(testCase.version, testCase.num))
+
+			v, err := getServerVersion(ctx, mockPool)
+			if err != nil {
+				t.Fatalf("unexpected error from getServerVersion: %s", err)
+			}
+
+			if major := v.major(); major != testCase.expectedMajor {
+				t.Errorf("v.major() = %d; want %d", major, testCase.expectedMajor)
+			}
+
+			if short := v.short(); short != testCase.expectedShort {
+				t.Errorf("v.short() = %q; want %q", short, testCase.expectedShort)
+			}
+
+			if err := mockPool.ExpectationsWereMet(); err != nil {
+				t.Errorf("mock pool has unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
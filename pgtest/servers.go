@@ -0,0 +1,337 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// defaultServerName is the name of the server test databases are created on
+// if WithServers isn't specified.
+const defaultServerName = "default"
+
+// serverRetryInterval is how long a server is taken out of rotation after it
+// fails to create a test database.
+const serverRetryInterval = 10 * time.Second
+
+// A Server is a postgres server which test databases can be created on.
+type Server struct {
+	// Name identifies the server, and is reported by TestDB.Server.
+	Name string
+
+	// ConnParams are the parameters for connecting to the server. These
+	// take precedence over the connection params shared by all servers.
+	ConnParams []connparams.Option
+}
+
+// A Placement decides which server a new test database is created on.
+type Placement int
+
+const (
+	// PlaceLeastLoaded creates test databases on the server with the
+	// fewest test databases.
+	PlaceLeastLoaded Placement = iota
+
+	// PlaceRoundRobin creates test databases on each server in turn.
+	PlaceRoundRobin
+)
+
+// serverConfig describes a server test databases can be created on.
+type serverConfig struct {
+	name         string
+	paramFactory connparamsFactory
+}
+
+// newServerConfigs returns the configuration for each server specified through
+// WithServers, or for a single default server if none were specified.
+func newServerConfigs(connParamOpts []connparams.Option, servers []Server) ([]serverConfig, error) {
+	if len(servers) == 0 {
+		return []serverConfig{{
+			name: defaultServerName,
+			paramFactory: func(dbName string) connparams.ConnectionParams {
+				return connparams.NewWithDefaults(dbName, connParamOpts...)
+			},
+		}}, nil
+	}
+
+	var (
+		configs = make([]serverConfig, 0, len(servers))
+		seen    = make(map[string]bool, len(servers))
+	)
+
+	for _, server := range servers {
+		if server.Name == "" {
+			return nil, errors.New("server name must not be empty")
+		}
+
+		if seen[server.Name] {
+			return nil, fmt.Errorf("duplicate server name %q", server.Name)
+		}
+		seen[server.Name] = true
+
+		opts := append(append([]connparams.Option(nil), connParamOpts...), server.ConnParams...)
+		configs = append(configs, serverConfig{
+			name: server.Name,
+			paramFactory: func(dbName string) connparams.ConnectionParams {
+				return connparams.NewWithDefaults(dbName, opts...)
+			},
+		})
+	}
+
+	return configs, nil
+}
+
+// A serverDSN is the DSN for connecting to a server's root db.
+type serverDSN struct {
+	Name    string `json:"name"`
+	RootDSN string `json:"root_dsn"`
+}
+
+// newServerDSNs validates the connection params for each server, and returns
+// the DSNs for connecting to their root dbs. These are used by helper
+// processes, which can't be passed the connection params directly.
+func newServerDSNs(servers []serverConfig) ([]serverDSN, error) {
+	dsns := make([]serverDSN, 0, len(servers))
+	for _, server := range servers {
+		rootDBParams := server.paramFactory(defaultRootDBName)
+		if err := rootDBParams.Validate(); err != nil {
+			if len(servers) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("server %s: %w", server.name, err)
+		}
+
+		dsns = append(dsns, serverDSN{
+			Name:    server.name,
+			RootDSN: rootDBParams.URI().String(),
+		})
+	}
+
+	return dsns, nil
+}
+
+// A serverShard is the state of a single server within a shardedFactory.
+type serverShard struct {
+	factory *testDBFactory
+
+	// load is the number of test databases on the server.
+	load int
+
+	// unhealthyUntil is when the server is put back into rotation after
+	// failing to create a test database.
+	unhealthyUntil time.Time
+}
+
+func (shard *serverShard) name() string {
+	return shard.factory.server
+}
+
+func (shard *serverShard) inRotation(now time.Time) bool {
+	return !now.Before(shard.unhealthyUntil)
+}
+
+// A shardedFactory spreads test databases across multiple servers, each with
+// its own testDBFactory.
+type shardedFactory struct {
+	placement Placement
+	now       func() time.Time
+
+	mut    sync.Mutex
+	shards []*serverShard
+	next   int
+}
+
+func newShardedFactory(placement Placement, factories []*testDBFactory) *shardedFactory {
+	shards := make([]*serverShard, len(factories))
+	for i, factory := range factories {
+		shards[i] = &serverShard{factory: factory}
+	}
+
+	return &shardedFactory{
+		placement: placement,
+		now:       time.Now,
+		shards:    shards,
+	}
+}
+
+// openShardedFactory opens a testDBFactory for each of the servers.
+func openShardedFactory(ctx context.Context, conf *config) (*shardedFactory, error) {
+	servers := conf.servers
+
+	factories := make([]*testDBFactory, 0, len(servers))
+	for _, server := range servers {
+		factory, err := newTestDBFactory(ctx, server, conf.ready, conf.naming, nil, conf.events)
+		if err != nil {
+			for _, opened := range factories {
+				opened.close()
+			}
+
+			if len(servers) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("server %s: %w", server.name, err)
+		}
+
+		factories = append(factories, factory)
+	}
+
+	return newShardedFactory(conf.placement, factories), nil
+}
+
+// createTestDB creates a test database on the server chosen by the placement.
+// If the server can't be reached it is taken out of rotation, and the test
+// database is created on another server instead.
+func (f *shardedFactory) createTestDB(ctx context.Context) (TestDB, error) {
+	var (
+		tried = make(map[*serverShard]bool, len(f.shards))
+		errs  []error
+	)
+
+	for {
+		shard := f.choose(tried)
+		if shard == nil {
+			return nil, errors.Join(errs...)
+		}
+		tried[shard] = true
+
+		db, err := shard.factory.createTestDB(ctx)
+		if err == nil {
+			f.mut.Lock()
+			shard.load++
+			shard.unhealthyUntil = time.Time{}
+			f.mut.Unlock()
+
+			return db, nil
+		}
+
+		err = f.shardError(shard, err)
+		if ctx.Err() != nil || !isServerUnavailable(err) {
+			return nil, err
+		}
+
+		f.mut.Lock()
+		shard.unhealthyUntil = f.now().Add(serverRetryInterval)
+		f.mut.Unlock()
+
+		errs = append(errs, err)
+	}
+}
+
+// choose returns the server to create the next test database on, out of those
+// which haven't been tried yet. Servers which are out of rotation are only
+// chosen if no others are available.
+func (f *shardedFactory) choose(tried map[*serverShard]bool) *serverShard {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	now := f.now()
+	for _, inRotation := range []bool{true, false} {
+		var (
+			chosen      *serverShard
+			chosenIndex int
+		)
+
+		for i := range f.shards {
+			// Round-robin placement starts from the server after
+			// the one last chosen.
+			index := i
+			if f.placement == PlaceRoundRobin {
+				index = (f.next + i) % len(f.shards)
+			}
+
+			shard := f.shards[index]
+			if tried[shard] || shard.inRotation(now) != inRotation {
+				continue
+			}
+
+			if chosen == nil || (f.placement == PlaceLeastLoaded && shard.load < chosen.load) {
+				chosen, chosenIndex = shard, index
+			}
+		}
+
+		if chosen != nil {
+			f.next = chosenIndex + 1
+			return chosen
+		}
+	}
+
+	return nil
+}
+
+func (f *shardedFactory) shardError(shard *serverShard, err error) error {
+	if len(f.shards) == 1 {
+		return err
+	}
+
+	return fmt.Errorf("server %s: %w", shard.name(), err)
+}
+
+func (f *shardedFactory) destroyTestDB(ctx context.Context, testDB TestDB) error {
+	shard := f.shard(testDB.Server())
+	if shard == nil {
+		return fmt.Errorf("unknown server %q", testDB.Server())
+	}
+
+	err := shard.factory.destroyTestDB(ctx, testDB)
+
+	// The test database is no longer owned by the pool either way, so
+	// it no longer counts towards the server's load.
+	f.mut.Lock()
+	shard.load--
+	f.mut.Unlock()
+
+	if err != nil {
+		return f.shardError(shard, err)
+	}
+
+	return nil
+}
+
+func (f *shardedFactory) shard(name string) *serverShard {
+	for _, shard := range f.shards {
+		if shard.name() == name {
+			return shard
+		}
+	}
+
+	return nil
+}
+
+func (f *shardedFactory) close() {
+	for _, shard := range f.shards {
+		shard.factory.close()
+	}
+}
+
+// isServerUnavailable returns whether err indicates that the server couldn't
+// be used at all, rather than a problem with the specific request. Errors which
+// didn't come from connecting to the server (e.g. naming the test database, or
+// the context being cancelled) leave it in rotation.
+func isServerUnavailable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsOperatorIntervention(pgErr.Code) || pgerrcode.IsInsufficientResources(pgErr.Code)
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var (
+		connectErr *pgconn.ConnectError
+		netErr     net.Error
+	)
+	if errors.As(err, &connectErr) || errors.As(err, &netErr) {
+		return true
+	}
+
+	_, retry := classifyConnectError(err)
+	return retry
+}
@@ -0,0 +1,440 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+// newMockShardedFactory returns a shardedFactory for servers with the
+// specified names, along with the mock pool for each server's root db.
+func newMockShardedFactory(t *testing.T, placement Placement, names ...string) (*shardedFactory, map[string]pgxmock.PgxPoolIface) {
+	t.Helper()
+
+	var (
+		factories = make([]*testDBFactory, 0, len(names))
+		mockPools = make(map[string]pgxmock.PgxPoolIface, len(names))
+	)
+
+	for _, name := range names {
+		mockPool, err := pgxmock.NewPool()
+		if err != nil {
+			t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+		}
+
+		factories = append(factories, &testDBFactory{
+			server: name,
+			paramFactory: func(dbName string) connparams.ConnectionParams {
+				return connparams.New(dbName, connparams.WithHost(name))
+			},
+			rootDB: &rootDB{db: mockPool},
+			rng:    rand.New(new(sequentialRandSource)),
+		})
+		mockPools[name] = mockPool
+	}
+
+	factory := newShardedFactory(placement, factories)
+	t.Cleanup(func() {
+		factory.close()
+
+		for name, mockPool := range mockPools {
+			if err := mockPool.ExpectationsWereMet(); err != nil {
+				t.Errorf("mock pool for server %s has unfulfilled expectations: %s", name, err)
+			}
+		}
+	})
+
+	return factory, mockPools
+}
+
+// errConnectionRefused is the error from failing to connect to a server.
+var errConnectionRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+func expectCreateDatabase(mockPool pgxmock.PgxPoolIface) {
+	mockPool.
+		ExpectExec(`CREATE DATABASE "pg_test_\d+"`).
+		WillReturnResult(pgxmock.NewResult("CREATE DATABASE", 1))
+}
+
+func TestShardedFactoryPlacement(t *testing.T) {
+	testCases := map[string]struct {
+		placement       Placement
+		servers         []string
+		ops             []string
+		expectedServers []string
+	}{
+		"round_robin": {
+			placement:       PlaceRoundRobin,
+			servers:         []string{"a", "b", "c"},
+			ops:             []string{"create", "create", "create", "create"},
+			expectedServers: []string{"a", "b", "c", "a"},
+		},
+		"round_robin_ignores_load": {
+			placement:       PlaceRoundRobin,
+			servers:         []string{"a", "b"},
+			ops:             []string{"create", "create", "destroy", "create", "create"},
+			expectedServers: []string{"a", "b", "a", "b"},
+		},
+		"least_loaded": {
+			placement:       PlaceLeastLoaded,
+			servers:         []string{"a", "b", "c"},
+			ops:             []string{"create", "create", "create", "create"},
+			expectedServers: []string{"a", "b", "c", "a"},
+		},
+		"least_loaded_after_destroy": {
+			placement:       PlaceLeastLoaded,
+			servers:         []string{"a", "b"},
+			ops:             []string{"create", "create", "destroy", "create", "create"},
+			expectedServers: []string{"a", "b", "a", "a"},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			factory, mockPools := newMockShardedFactory(t, testCase.placement, testCase.servers...)
+
+			var (
+				created []TestDB
+				servers []string
+			)
+
+			for _, op := range testCase.ops {
+				switch op {
+				case "create":
+					// Only the server the test db should be
+					// placed on expects it to be created.
+					expected := testCase.expectedServers[len(servers)]
+					expectCreateDatabase(mockPools[expected])
+
+					db, err := factory.createTestDB(ctx)
+					if err != nil {
+						t.Fatalf("unexpected error from factory.createTestDB: %s", err)
+					}
+
+					created = append(created, db)
+					servers = append(servers, db.Server())
+				case "destroy":
+					// Always destroy the first test db.
+					toDestroy := created[0]
+					created = created[1:]
+
+					mockPools[toDestroy.Server()].
+						ExpectExec(`DROP DATABASE "` + toDestroy.Name() + `"`).
+						WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+
+					if err := factory.destroyTestDB(ctx, toDestroy); err != nil {
+						t.Fatalf("unexpected error from factory.destroyTestDB: %s", err)
+					}
+				}
+			}
+
+			for i, expected := range testCase.expectedServers {
+				if servers[i] != expected {
+					t.Errorf("test db %d created on server %q; want %q (all servers = %q)", i, servers[i], expected, servers)
+				}
+			}
+		})
+	}
+}
+
+func TestShardedFactoryUnavailableServer(t *testing.T) {
+	var (
+		ctx = context.Background()
+		now = time.Now()
+	)
+
+	factory, mockPools := newMockShardedFactory(t, PlaceRoundRobin, "a", "b")
+	factory.now = func() time.Time { return now }
+
+	// Server a can't be reached, so the test db is created on server b
+	// instead.
+	mockPools["a"].
+		ExpectExec(`CREATE DATABASE "pg_test_\d+"`).
+		WillReturnError(errConnectionRefused)
+	expectCreateDatabase(mockPools["b"])
+
+	db, err := factory.createTestDB(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error from factory.createTestDB: %s", err)
+	}
+
+	if db.Server() != "b" {
+		t.Errorf("test db created on server %q; want %q", db.Server(), "b")
+	}
+
+	// Server a is out of rotation, so it isn't tried again.
+	expectCreateDatabase(mockPools["b"])
+
+	if db, err := factory.createTestDB(ctx); err != nil {
+		t.Fatalf("unexpected error from factory.createTestDB: %s", err)
+	} else if db.Server() != "b" {
+		t.Errorf("test db created on server %q while a is out of rotation; want %q", db.Server(), "b")
+	}
+
+	// Server a is put back into rotation after the retry interval.
+	now = now.Add(serverRetryInterval)
+	expectCreateDatabase(mockPools["a"])
+
+	if db, err := factory.createTestDB(ctx); err != nil {
+		t.Fatalf("unexpected error from factory.createTestDB: %s", err)
+	} else if db.Server() != "a" {
+		t.Errorf("test db created on server %q after retry interval; want %q", db.Server(), "a")
+	}
+}
+
+func TestShardedFactoryAllServersUnavailable(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPools := newMockShardedFactory(t, PlaceRoundRobin, "a", "b")
+
+	for _, name := range []string{"a", "b"} {
+		mockPools[name].
+			ExpectExec(`CREATE DATABASE "pg_test_\d+"`).
+			WillReturnError(errConnectionRefused)
+	}
+
+	if _, err := factory.createTestDB(ctx); err == nil {
+		t.Fatalf("factory.createTestDB(ctx) = nil error; want error")
+	}
+
+	// Servers which are out of rotation are still tried if there are no
+	// others.
+	expectCreateDatabase(mockPools["a"])
+
+	if db, err := factory.createTestDB(ctx); err != nil {
+		t.Fatalf("unexpected error from factory.createTestDB: %s", err)
+	} else if db.Server() != "a" {
+		t.Errorf("test db created on server %q; want %q", db.Server(), "a")
+	}
+}
+
+func TestShardedFactoryRequestError(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPools := newMockShardedFactory(t, PlaceRoundRobin, "a", "b")
+
+	// Server a could be reached, so the error is returned without trying
+	// server b.
+	mockPools["a"].
+		ExpectExec(`CREATE DATABASE "pg_test_\d+"`).
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.InsufficientPrivilege})
+
+	_, err := factory.createTestDB(ctx)
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.InsufficientPrivilege {
+		t.Fatalf("factory.createTestDB(ctx) = %v; want insufficient privilege error", err)
+	}
+}
+
+func TestShardedFactoryLocalError(t *testing.T) {
+	testCases := map[string]struct {
+		namer  DBNamer
+		cancel bool
+	}{
+		"naming_error": {
+			namer: func(DBNameHint) string { return "not-a-test-db" },
+		},
+		"cancelled": {
+			cancel: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			factory, mockPools := newMockShardedFactory(t, PlaceRoundRobin, "a", "b")
+			factory.shards[0].factory.naming.namer = testCase.namer
+
+			if testCase.cancel {
+				cancel()
+				mockPools["a"].
+					ExpectExec(`CREATE DATABASE "pg_test_\d+"`).
+					WillReturnError(fmt.Errorf("create database: %w", context.Canceled))
+			}
+
+			// The error didn't come from connecting to server a, so
+			// server b isn't tried.
+			if _, err := factory.createTestDB(ctx); err == nil {
+				t.Fatalf("factory.createTestDB(ctx) = nil error; want error")
+			}
+
+			if !factory.shards[0].inRotation(factory.now()) {
+				t.Errorf("server a taken out of rotation after %s", testName)
+			}
+		})
+	}
+}
+
+func TestIsServerUnavailable(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"connection_refused": {
+			err:      fmt.Errorf("create database: %w", errConnectionRefused),
+			expected: true,
+		},
+		"connect_error": {
+			err:      &pgconn.ConnectError{Config: &pgconn.Config{}},
+			expected: true,
+		},
+		"too_many_connections": {
+			err:      &pgconn.PgError{Code: pgerrcode.TooManyConnections},
+			expected: true,
+		},
+		"request_error": {
+			err: &pgconn.PgError{Code: pgerrcode.InsufficientPrivilege},
+		},
+		"local_error": {
+			err: errors.New(`test db name "x" doesn't start with the prefix "pg_test_"`),
+		},
+		"already_exists": {
+			err: &databaseAlreadyExistsWithName{name: "pg_test_1"},
+		},
+		"cancelled": {
+			err: fmt.Errorf("create database: %w", context.Canceled),
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if actual := isServerUnavailable(testCase.err); actual != testCase.expected {
+				t.Errorf("isServerUnavailable(%v) = %t; want %t", testCase.err, actual, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestNewServerConfigs(t *testing.T) {
+	testCases := map[string]struct {
+		servers          []Server
+		expectErr        bool
+		expectedRootURIs map[string]string
+	}{
+		"default": {
+			expectedRootURIs: map[string]string{
+				"default": "postgres://u@shared:5432/postgres?sslmode=disable",
+			},
+		},
+		"multiple_servers": {
+			servers: []Server{
+				{Name: "pg13", ConnParams: []connparams.Option{connparams.WithPort(5413)}},
+				{Name: "pg17", ConnParams: []connparams.Option{connparams.WithHost("other"), connparams.WithPort(5417)}},
+			},
+			expectedRootURIs: map[string]string{
+				"pg13": "postgres://u@shared:5413/postgres?sslmode=disable",
+				"pg17": "postgres://u@other:5417/postgres?sslmode=disable",
+			},
+		},
+		"empty_name": {
+			servers:   []Server{{Name: ""}},
+			expectErr: true,
+		},
+		"duplicate_name": {
+			servers:   []Server{{Name: "a"}, {Name: "a"}},
+			expectErr: true,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			shared := []connparams.Option{
+				connparams.WithHost("shared"),
+				connparams.WithPort(5432),
+				connparams.WithUser("u"),
+				connparams.WithSSLMode(connparams.SSLModeDisable),
+			}
+
+			configs, err := newServerConfigs(shared, testCase.servers)
+			if testCase.expectErr {
+				if err == nil {
+					t.Fatalf("newServerConfigs(...) = nil error; want error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error from newServerConfigs: %s", err)
+			}
+
+			if len(configs) != len(testCase.expectedRootURIs) {
+				t.Fatalf("len(newServerConfigs(...)) = %d; want %d", len(configs), len(testCase.expectedRootURIs))
+			}
+
+			for _, conf := range configs {
+				uri := conf.paramFactory(defaultRootDBName).URI().String()
+				if expected := testCase.expectedRootURIs[conf.name]; uri != expected {
+					t.Errorf("root db URI for server %q = %q; want %q", conf.name, uri, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestSupervisorForServer(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPools := newMockShardedFactory(t, PlaceRoundRobin, "a", "b")
+	s := newSupervisor(&config{}, factory, nil)
+
+	serverSupervisor, err := s.forServer("b")
+	if err != nil {
+		t.Fatalf("unexpected error from s.forServer: %s", err)
+	}
+
+	// Round-robin placement would create the first test db on server a,
+	// but only server b is used.
+	var (
+		leases []testDBLease
+		names  []string
+	)
+	for i := 0; i < 2; i++ {
+		expectCreateDatabase(mockPools["b"])
+
+		lease, err := serverSupervisor.getTestDB(ctx, t.Name())
+		if err != nil {
+			t.Fatalf("unexpected error from serverSupervisor.getTestDB: %s", err)
+		}
+
+		if server := lease.Data().Server(); server != "b" {
+			t.Errorf("test db %d created on server %q; want %q", i, server, "b")
+		}
+		leases = append(leases, lease)
+		names = append(names, lease.Data().Name())
+	}
+
+	for _, lease := range leases {
+		lease.Release()
+	}
+
+	// The supervisor for server b is owned by s, so it is only shutdown
+	// along with s.
+	if err := serverSupervisor.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from serverSupervisor.shutdown: %s", err)
+	}
+
+	for _, name := range names {
+		mockPools["b"].
+			ExpectExec(`DROP DATABASE "` + name + `"`).
+			WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+	}
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+
+	if _, err := s.forServer("c"); err == nil {
+		t.Errorf(`s.forServer("c") = nil error; want error for unknown server`)
+	}
+}
@@ -0,0 +1,237 @@
+package pgtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/internal/pool"
+)
+
+// Stats describes what a Supervisor has done, which is useful for tuning
+// '-parallel' and the reset op.
+//
+// Supervisors using a coordinator don't own their test databases, so they
+// don't count the test databases created and destroyed by the coordinator.
+type Stats struct {
+	// Created is the number of test databases created.
+	Created int `json:"created"`
+
+	// Destroyed is the number of test databases dropped.
+	Destroyed int `json:"destroyed"`
+
+	// Hijacked is the number of test databases kept for failed tests.
+	Hijacked int `json:"hijacked"`
+
+	// Invalidated is the number of idle test databases which failed
+	// validation and were replaced (see ValidateOnAcquire).
+	Invalidated int `json:"invalidated"`
+
+	// Evicted is the number of idle test databases dropped due to
+	// WithIdleTimeout or WithMaxIdle.
+	Evicted int `json:"evicted"`
+
+	// Acquires is the number of test databases handed out to tests.
+	Acquires int `json:"acquires"`
+
+	// AcquireWait is the total time tests spent waiting for a test
+	// database, including creating it but not resetting it.
+	AcquireWait time.Duration `json:"acquire_wait_ns"`
+
+	// MaxAcquireWait is the longest time a test spent waiting for a test
+	// database.
+	MaxAcquireWait time.Duration `json:"max_acquire_wait_ns"`
+
+	// AffinityHits is the number of test databases handed to a test which
+	// were last used by the same test or top-level test (see
+	// WithTestAffinity).
+	AffinityHits int `json:"affinity_hits"`
+
+	// PeakInUse is the most test databases in use by tests at once. If
+	// tests are split between servers by ForEachServer, the peak is
+	// tracked for each server separately and summed, so this is an upper
+	// bound.
+	PeakInUse int `json:"peak_in_use"`
+
+	// Resets are the stats for each reset op, by name (e.g.
+	// "DropAllTables").
+	Resets map[string]ResetStats `json:"resets,omitempty"`
+}
+
+// ResetStats describes the runs of a single reset op.
+type ResetStats struct {
+	// Count is the number of successful runs.
+	Count int `json:"count"`
+
+	// Total is the total duration of the successful runs.
+	Total time.Duration `json:"total_ns"`
+
+	// Max is the duration of the longest successful run.
+	Max time.Duration `json:"max_ns"`
+}
+
+func (s *Stats) addPoolStats(p pool.Stats) {
+	s.Created += p.Created
+	s.Destroyed += p.Destroyed
+	s.Hijacked += p.Hijacked
+	s.Invalidated += p.Invalidated
+	s.Evicted += p.Evicted
+	s.Acquires += p.Acquires
+	s.AcquireWait += p.AcquireWait
+	s.MaxAcquireWait = max(s.MaxAcquireWait, p.MaxAcquireWait)
+	s.PeakInUse += p.PeakAcquired
+	s.AffinityHits += p.AffinityHits
+}
+
+func (s *Stats) add(other Stats) {
+	s.Created += other.Created
+	s.Destroyed += other.Destroyed
+	s.Hijacked += other.Hijacked
+	s.Invalidated += other.Invalidated
+	s.Evicted += other.Evicted
+	s.Acquires += other.Acquires
+	s.AcquireWait += other.AcquireWait
+	s.MaxAcquireWait = max(s.MaxAcquireWait, other.MaxAcquireWait)
+	s.PeakInUse += other.PeakInUse
+	s.AffinityHits += other.AffinityHits
+
+	for op, reset := range other.Resets {
+		s.addReset(op, reset)
+	}
+}
+
+func (s *Stats) addReset(op string, reset ResetStats) {
+	if s.Resets == nil {
+		s.Resets = make(map[string]ResetStats)
+	}
+
+	total := s.Resets[op]
+	total.Count += reset.Count
+	total.Total += reset.Total
+	total.Max = max(total.Max, reset.Max)
+	s.Resets[op] = total
+}
+
+// writeSummary writes a human readable summary of the stats.
+func (s Stats) writeSummary(w io.Writer) error {
+	var avgWait time.Duration
+	if s.Acquires != 0 {
+		avgWait = s.AcquireWait / time.Duration(s.Acquires)
+	}
+
+	_, err := fmt.Fprintf(w, `pgtest: stats:
+  test dbs: %d created, %d dropped, %d kept for failed tests, %d failed validation, %d evicted while idle
+  acquires: %d (peak in use %d, %d by test affinity), wait avg %s max %s
+`, s.Created, s.Destroyed, s.Hijacked, s.Invalidated, s.Evicted, s.Acquires, s.PeakInUse, s.AffinityHits, avgWait, s.MaxAcquireWait)
+	if err != nil {
+		return err
+	}
+
+	ops := make([]string, 0, len(s.Resets))
+	for op := range s.Resets {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		reset := s.Resets[op]
+
+		var avg time.Duration
+		if reset.Count != 0 {
+			avg = reset.Total / time.Duration(reset.Count)
+		}
+
+		if _, err := fmt.Fprintf(w, "  reset %s: %d runs, avg %s max %s\n", op, reset.Count, avg, reset.Max); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeStatsFile writes the stats as JSON to the file at path.
+func writeStatsFile(path string, s Stats) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// reportStats reports the supervisor's stats as configured by the RunOptions.
+func reportStats(conf *runConfig, supervisor Supervisor) error {
+	if !conf.statsSummary && conf.statsFile == "" {
+		return nil
+	}
+
+	reporter, ok := supervisor.(StatsReporter)
+	if !ok {
+		return fmt.Errorf("supervisor %T doesn't report stats", supervisor)
+	}
+
+	s := reporter.Stats()
+
+	if conf.statsSummary {
+		if err := s.writeSummary(os.Stderr); err != nil {
+			return fmt.Errorf("write stats summary: %w", err)
+		}
+	}
+
+	if conf.statsFile != "" {
+		if err := writeStatsFile(conf.statsFile, s); err != nil {
+			return fmt.Errorf("write stats file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// A statsRecorder records the stats which aren't tracked by a pool, which is
+// all of them for supervisors using a coordinator.
+type statsRecorder struct {
+	mut   sync.Mutex
+	stats Stats
+	inUse int
+}
+
+func (r *statsRecorder) recordReset(op string, d time.Duration) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.stats.addReset(op, ResetStats{Count: 1, Total: d, Max: d})
+}
+
+func (r *statsRecorder) recordAcquire(wait time.Duration) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.inUse++
+	r.stats.Acquires++
+	r.stats.AcquireWait += wait
+	r.stats.MaxAcquireWait = max(r.stats.MaxAcquireWait, wait)
+	r.stats.PeakInUse = max(r.stats.PeakInUse, r.inUse)
+}
+
+func (r *statsRecorder) recordDone(hijacked bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.inUse--
+	if hijacked {
+		r.stats.Hijacked++
+	}
+}
+
+func (r *statsRecorder) snapshot() Stats {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var s Stats
+	s.add(r.stats)
+	return s
+}
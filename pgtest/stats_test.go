@@ -0,0 +1,222 @@
+package pgtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestSupervisorStats(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPools := newMockShardedFactory(t, PlaceLeastLoaded, "a")
+	s := newSupervisor(&config{}, factory, nil)
+
+	acquire := func(source testDBSource, create bool) testDBLease {
+		t.Helper()
+
+		if create {
+			expectCreateDatabase(mockPools["a"])
+		}
+
+		lease, err := source.getTestDB(ctx, t.Name())
+		if err != nil {
+			t.Fatalf("unexpected error from getTestDB: %s", err)
+		}
+		return lease
+	}
+
+	first := acquire(s, true)
+	second := acquire(s, true)
+	first.Release()
+	second.Hijack()
+
+	// Re-uses the first test db.
+	acquire(s, false).Release()
+
+	serverSupervisor, err := s.forServer("a")
+	if err != nil {
+		t.Fatalf("unexpected error from s.forServer: %s", err)
+	}
+	acquire(serverSupervisor, true).Release()
+
+	for i := 0; i < 2; i++ {
+		mockPools["a"].
+			ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+			WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+	}
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+
+	stats := s.stats()
+	stats.AcquireWait, stats.MaxAcquireWait = 0, 0
+
+	expected := Stats{
+		Created:   3,
+		Destroyed: 2,
+		Hijacked:  1,
+		Acquires:  4,
+		PeakInUse: 3,
+	}
+	if diff := cmp.Diff(expected, stats); diff != "" {
+		t.Errorf("s.stats() returned unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func TestSupervisorIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPools := newMockShardedFactory(t, PlaceLeastLoaded, "a")
+	s := newSupervisor(&config{idle: idleConfig{timeout: 20 * time.Millisecond}}, factory, nil)
+
+	expectCreateDatabase(mockPools["a"])
+	mockPools["a"].
+		ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+		WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+
+	lease, err := s.getTestDB(ctx, t.Name())
+	if err != nil {
+		t.Fatalf("unexpected error from getTestDB: %s", err)
+	}
+	lease.Release()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.stats().Evicted == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle test db not dropped after the idle timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+
+	stats := s.stats()
+	if stats.Created != 1 || stats.Destroyed != 1 || stats.Evicted != 1 {
+		t.Errorf("s.stats() = %+v; want 1 created, 1 destroyed and 1 evicted", stats)
+	}
+}
+
+func TestStatsRecorder(t *testing.T) {
+	recorder := new(statsRecorder)
+
+	recorder.recordAcquire(2 * time.Second)
+	recorder.recordAcquire(time.Second)
+	recorder.recordReset("DropAllTables", 3*time.Millisecond)
+	recorder.recordReset("DropAllTables", time.Millisecond)
+	recorder.recordDone(true)
+	recorder.recordDone(false)
+	recorder.recordAcquire(time.Second)
+
+	expected := Stats{
+		Hijacked:       1,
+		Acquires:       3,
+		AcquireWait:    4 * time.Second,
+		MaxAcquireWait: 2 * time.Second,
+		PeakInUse:      2,
+		Resets: map[string]ResetStats{
+			"DropAllTables": {Count: 2, Total: 4 * time.Millisecond, Max: 3 * time.Millisecond},
+		},
+	}
+
+	stats := recorder.snapshot()
+	if diff := cmp.Diff(expected, stats); diff != "" {
+		t.Errorf("recorder.snapshot() returned unexpected stats (-want +got):\n%s", diff)
+	}
+
+	// The snapshot doesn't share the recorder's map.
+	stats.Resets["TruncateAllTables"] = ResetStats{Count: 1}
+	if _, ok := recorder.snapshot().Resets["TruncateAllTables"]; ok {
+		t.Errorf("modifying snapshot modified recorder's resets")
+	}
+}
+
+func TestStatsSummary(t *testing.T) {
+	stats := Stats{
+		Created:        4,
+		Destroyed:      3,
+		Hijacked:       1,
+		Invalidated:    2,
+		Evicted:        1,
+		Acquires:       10,
+		AcquireWait:    time.Second,
+		MaxAcquireWait: 500 * time.Millisecond,
+		PeakInUse:      4,
+		AffinityHits:   3,
+		Resets: map[string]ResetStats{
+			"TruncateAllTables": {Count: 2, Total: 6 * time.Millisecond, Max: 5 * time.Millisecond},
+			"DropAllTables":     {Count: 4, Total: 8 * time.Millisecond, Max: 4 * time.Millisecond},
+		},
+	}
+
+	var b bytes.Buffer
+	if err := stats.writeSummary(&b); err != nil {
+		t.Fatalf("unexpected error writing summary: %s", err)
+	}
+
+	expected := `pgtest: stats:
+  test dbs: 4 created, 3 dropped, 1 kept for failed tests, 2 failed validation, 1 evicted while idle
+  acquires: 10 (peak in use 4, 3 by test affinity), wait avg 100ms max 500ms
+  reset DropAllTables: 4 runs, avg 2ms max 4ms
+  reset TruncateAllTables: 2 runs, avg 3ms max 5ms
+`
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("unexpected summary (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	supervisor := &fakeSupervisor{stats: Stats{
+		Created:  2,
+		Acquires: 5,
+		Resets: map[string]ResetStats{
+			"DropAllTables": {Count: 5, Total: time.Second, Max: 300 * time.Millisecond},
+		},
+	}}
+
+	conf := new(runConfig)
+	WithStatsFile(path).applyRun(conf)
+
+	if err := reportStats(conf, supervisor); err != nil {
+		t.Fatalf("unexpected error reporting stats: %s", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading stats file: %s", err)
+	}
+
+	if !strings.Contains(string(b), `"acquire_wait_ns": 0`) {
+		t.Errorf("stats file doesn't name durations in ns:\n%s", b)
+	}
+
+	var stats Stats
+	if err := json.Unmarshal(b, &stats); err != nil {
+		t.Fatalf("unexpected error decoding stats file: %s", err)
+	}
+
+	if diff := cmp.Diff(supervisor.stats, stats); diff != "" {
+		t.Errorf("stats file has unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportStatsNotReporter(t *testing.T) {
+	conf := new(runConfig)
+	WithStatsSummary().applyRun(conf)
+
+	var supervisor struct{ Supervisor }
+	if err := reportStats(conf, supervisor); err == nil {
+		t.Errorf("reportStats(...) = nil; want error for supervisor which doesn't report stats")
+	}
+}
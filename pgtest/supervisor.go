@@ -2,56 +2,283 @@ package pgtest
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ShawnROGrady/go-pgtest/pgtest/internal/pool"
 )
 
+// A testDBLease is a TestDB acquired for use in a single test.
+type testDBLease interface {
+	Data() TestDB
+
+	// Release releases the TestDB so it can be re-used by other tests.
+	Release()
+
+	// Hijack prevents the TestDB from being re-used or dropped.
+	Hijack()
+}
+
+// A testDBSource is where a testSupervisor gets TestDBs from, which is either
+// a pool owned by the test binary or a coordinator shared between test
+// binaries.
+type testDBSource interface {
+	getTestDB(ctx context.Context, testName string) (testDBLease, error)
+
+	// stats returns what the testDBSource has done so far.
+	stats() Stats
+
+	// forServer returns a testDBSource which only gets TestDBs on the
+	// named server. It is owned by the original testDBSource, so shutting
+	// it down is a no-op.
+	forServer(name string) (testDBSource, error)
+
+	shutdown(ctx context.Context) error
+}
+
 type supervisor struct {
-	factory *testDBFactory
-	pool    *pool.Pool[TestDB]
-	resetOp ResetTestDBOp
+	factory        *shardedFactory
+	pool           *pool.Pool[TestDB]
+	resetOp        ResetTestDBOp
+	managedServers []ManagedServer
+
+	// recorder records the stats which aren't tracked by the pool.
+	recorder *statsRecorder
+
+	hooks  hooks
+	events *eventLogger
+	idle   idleConfig
+
+	// testAffinity acquires test databases by the test affinity keys.
+	testAffinity bool
+
+	// reaper drops the test databases if the test binary dies, and is
+	// shared with the supervisors for each server.
+	reaper *reaper
+
+	// servers are the supervisors for each server, which are created by
+	// forServer as needed.
+	mut     sync.Mutex
+	servers map[string]*supervisor
 }
 
-func newSupervisor(conf *config, factory *testDBFactory) *supervisor {
+func newSupervisor(conf *config, factory *shardedFactory, reaper *reaper) *supervisor {
 	resourceConf := &pool.ResourceConf[TestDB]{
 		Create: func(ctx context.Context) (TestDB, error) {
-			return factory.createTestDB(ctx)
+			start := time.Now()
+			testDB, err := factory.createTestDB(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := runTestDBHooks(ctx, conf.hooks.onCreate, testDB); err != nil {
+				err = fmt.Errorf("run OnCreate hooks: %w", err)
+				if destroyErr := factory.destroyTestDB(ctx, testDB); destroyErr != nil {
+					err = errors.Join(err, fmt.Errorf("destroy test db: %w", destroyErr))
+				}
+				return nil, err
+			}
+
+			conf.events.created(testDB, time.Since(start))
+			reaper.register(testDB)
+			return testDB, nil
 		},
-		Destroy: func(testDB TestDB) error {
-			return factory.destroyTestDB(context.Background(), testDB)
+		Destroy: func(ctx context.Context, testDB TestDB) error {
+			start := time.Now()
+
+			// The test database is dropped even if the hooks fail,
+			// since it can't be re-used either way, so the error is
+			// only logged.
+			if err := runTestDBHooks(ctx, conf.hooks.onDestroy, testDB); err != nil {
+				if conf.events.enabled() {
+					conf.events.cleanupError("failed to run OnDestroy hooks", err, testDBAttrs(testDB)...)
+				} else {
+					log.Printf("ERROR: pgtest: run OnDestroy hooks on test db %s: %s", testDB.Name(), err)
+				}
+			}
+
+			err := factory.destroyTestDB(ctx, testDB)
+			conf.events.dropped(testDB, time.Since(start), err)
+			if err != nil {
+				return err
+			}
+
+			reaper.unregister(testDB)
+			return nil
 		},
 	}
+	if conf.hooks.validate {
+		resourceConf.Validate = func(ctx context.Context, testDB TestDB) error {
+			start := time.Now()
+			err := validateTestDB(ctx, testDB, conf.hooks.invariants)
+			if err != nil && ctx.Err() == nil {
+				if conf.events.enabled() {
+					conf.events.invalid(testDB, time.Since(start), err)
+				} else {
+					log.Printf("WARNING: pgtest: test db %s failed validation, replacing it: %s", testDB.Name(), err)
+				}
+			}
+			return err
+		}
+	}
+	resourceConf.IdleTimeout = conf.idle.timeout
+	resourceConf.MaxIdle = conf.idle.max
 	pool := pool.New[TestDB](resourceConf)
 
 	return &supervisor{
-		factory: factory,
-		pool:    pool,
-		resetOp: conf.resetOp,
+		factory:  factory,
+		pool:     pool,
+		resetOp:  conf.resetOp,
+		recorder: new(statsRecorder),
+		hooks:    conf.hooks,
+		events:   conf.events,
+		idle:     conf.idle,
+		reaper:   reaper,
+
+		testAffinity:   conf.testAffinity,
+		managedServers: conf.managedServers,
 	}
 }
 
 func (s *supervisor) shutdown(ctx context.Context) error {
-	if err := s.pool.Close(ctx); err != nil {
-		s.factory.close()
-		return err
+	// The supervisors for each server are kept after they're shutdown, so
+	// their stats are still reported.
+	s.mut.Lock()
+	servers := maps.Clone(s.servers)
+	s.mut.Unlock()
+
+	// The supervisors for each server share their testDBFactory with s, so
+	// only their pools are closed.
+	var err error
+	for name, server := range servers {
+		if closeErr := server.pool.Close(ctx); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close pool for server %s: %w", name, closeErr))
+		}
 	}
 
+	err = errors.Join(err, s.pool.Close(ctx))
 	s.factory.close()
-	return nil
+
+	if stopErr := s.reaper.stop(); stopErr != nil {
+		err = errors.Join(err, fmt.Errorf("stop reaper: %w", stopErr))
+	}
+
+	// The servers are stopped even if the pool couldn't be closed
+	// cleanly, since any remaining test databases are removed with them.
+	for _, server := range s.managedServers {
+		if stopErr := server.Stop(ctx); stopErr != nil {
+			err = errors.Join(err, fmt.Errorf("stop managed server: %w", stopErr))
+		}
+	}
+
+	return err
 }
 
-func (s *supervisor) getTestDB(ctx context.Context) (*pool.Resource[TestDB], error) {
-	db, err := s.pool.Acquire(ctx)
+func (s *supervisor) getTestDB(ctx context.Context, testName string) (testDBLease, error) {
+	var affinity []string
+	if s.testAffinity {
+		affinity = testAffinityKeys(testName)
+	}
+
+	db, err := s.pool.AcquireWithAffinity(withTestName(ctx, testName), affinity)
 	if err != nil {
 		return nil, fmt.Errorf("acquire: %w", err)
 	}
 
 	if s.resetOp != nil {
-		if err := runResetTestDBOp(ctx, s.resetOp, db.Data()); err != nil {
+		if err := resetTestDB(ctx, s.resetOp, db.Data(), testName, s.recorder, s.events); err != nil {
+			// The test db is released rather than leaked, which would
+			// prevent the pool from being closed. It is reset again
+			// before being re-used.
+			db.Release()
 			return nil, fmt.Errorf("reset test db: %w", err)
 		}
 	}
 
+	if s.reaper != nil {
+		return reapedLease{testDBLease: db, reaper: s.reaper}, nil
+	}
+
 	return db, nil
 }
+
+// testAffinityKeys are the keys to acquire a test database for the named test
+// with, which prefer the test database last used by the same test, then by
+// the same top-level test.
+func testAffinityKeys(testName string) []string {
+	family, _, _ := strings.Cut(testName, "/")
+	return []string{"test:" + testName, "family:" + family}
+}
+
+// stats returns the stats for s, including the supervisors for each server.
+func (s *supervisor) stats() Stats {
+	stats := s.recorder.snapshot()
+	stats.addPoolStats(s.pool.Stats())
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for _, server := range s.servers {
+		stats.add(server.stats())
+	}
+
+	return stats
+}
+
+// A reapedLease is a testDBLease whose TestDB is registered with the reaper.
+type reapedLease struct {
+	testDBLease
+	reaper *reaper
+}
+
+// Hijack unregisters the TestDB, since the reaper shouldn't drop test
+// databases which are being kept.
+func (l reapedLease) Hijack() {
+	l.reaper.unregister(l.Data())
+	l.testDBLease.Hijack()
+}
+
+func (s *supervisor) forServer(name string) (testDBSource, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if server, ok := s.servers[name]; ok {
+		return serverSupervisor{server}, nil
+	}
+
+	shard := s.factory.shard(name)
+	if shard == nil {
+		return nil, fmt.Errorf("unknown server %q", name)
+	}
+
+	factory := newShardedFactory(s.factory.placement, []*testDBFactory{shard.factory})
+	server := newSupervisor(&config{
+		resetOp:      s.resetOp,
+		hooks:        s.hooks,
+		events:       s.events,
+		idle:         s.idle,
+		testAffinity: s.testAffinity,
+	}, factory, s.reaper)
+
+	if s.servers == nil {
+		s.servers = make(map[string]*supervisor)
+	}
+	s.servers[name] = server
+
+	return serverSupervisor{server}, nil
+}
+
+// A serverSupervisor is the supervisor for a single server, which is owned by
+// the supervisor for all servers.
+type serverSupervisor struct {
+	*supervisor
+}
+
+func (serverSupervisor) shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package pgtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pashagolub/pgxmock/v3"
+)
+
+func TestTestAffinityKeys(t *testing.T) {
+	testCases := map[string]struct {
+		testName string
+		expected []string
+	}{
+		"top_level": {
+			testName: "TestFoo",
+			expected: []string{"test:TestFoo", "family:TestFoo"},
+		},
+		"subtest": {
+			testName: "TestFoo/bar",
+			expected: []string{"test:TestFoo/bar", "family:TestFoo"},
+		},
+		"nested_subtest": {
+			testName: "TestFoo/bar/baz",
+			expected: []string{"test:TestFoo/bar/baz", "family:TestFoo"},
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if diff := cmp.Diff(testCase.expected, testAffinityKeys(testCase.testName)); diff != "" {
+				t.Errorf("testAffinityKeys(%q) returned unexpected keys (-want +got):\n%s", testCase.testName, diff)
+			}
+		})
+	}
+}
+
+func TestSupervisorTestAffinity(t *testing.T) {
+	ctx := context.Background()
+	factory, mockPools := newMockShardedFactory(t, PlaceLeastLoaded, "a")
+	s := newSupervisor(&config{testAffinity: true}, factory, nil)
+
+	acquire := func(testName string) testDBLease {
+		t.Helper()
+
+		lease, err := s.getTestDB(ctx, testName)
+		if err != nil {
+			t.Fatalf("unexpected error from getTestDB: %s", err)
+		}
+		return lease
+	}
+
+	expectCreateDatabase(mockPools["a"])
+	expectCreateDatabase(mockPools["a"])
+
+	first := acquire("TestFoo/bar")
+	second := acquire("TestBaz")
+	fooDB, bazDB := first.Data().Name(), second.Data().Name()
+	first.Release()
+	second.Release()
+
+	// The most recently released test db is bazDB, but the test db last
+	// used by the same family is preferred.
+	lease := acquire("TestFoo/qux")
+	if name := lease.Data().Name(); name != fooDB {
+		t.Errorf("TestFoo/qux got test db %s; want %s, which was used by TestFoo/bar", name, fooDB)
+	}
+	lease.Release()
+
+	lease = acquire("TestBaz")
+	if name := lease.Data().Name(); name != bazDB {
+		t.Errorf("TestBaz got test db %s; want %s, which it used before", name, bazDB)
+	}
+	lease.Release()
+
+	for i := 0; i < 2; i++ {
+		mockPools["a"].
+			ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+			WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+	}
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+
+	if hits := s.stats().AffinityHits; hits != 2 {
+		t.Errorf("s.stats().AffinityHits = %d; want 2", hits)
+	}
+}
+
+func TestSupervisorResetFailure(t *testing.T) {
+	factory, mockPools := newMockShardedFactory(t, PlaceLeastLoaded, "a")
+
+	// Nothing listens on this port, so resetting the test db fails.
+	factory.shard("a").factory.paramFactory = func(dbName string) connparams.ConnectionParams {
+		return connparams.New(dbName, connparams.WithHost("127.0.0.1"), connparams.WithPort(1))
+	}
+	s := newSupervisor(&config{resetOp: DropAllTables()}, factory, nil)
+
+	expectCreateDatabase(mockPools["a"])
+	if _, err := s.getTestDB(context.Background(), t.Name()); err == nil {
+		t.Fatalf("getTestDB(...) = nil error; want error resetting test db")
+	}
+
+	mockPools["a"].
+		ExpectExec(`DROP DATABASE "pg_test_\d+"`).
+		WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+
+	// The test db which failed to reset is released, so shutdown doesn't
+	// wait for it.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error from s.shutdown: %s", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("s.shutdown waited for the test db which failed to reset")
+	}
+}
@@ -1,28 +1,64 @@
 package pgtest
 
 import (
+	"log/slog"
+	"strconv"
+
 	"github.com/ShawnROGrady/go-pgtest/pgtest/connparams"
 )
 
 type TestDB interface {
 	isTestDB()
 
-	name() string
+	// Name returns the name of the TestDB's database.
 	Name() string
 
+	// Server returns the name of the server the TestDB lives on.
+	Server() string
+
+	psqlCommand() string
+
+	// withConnParams returns a copy of the TestDB with the additional
+	// connection params applied.
+	withConnParams(opts ...connparams.Option) TestDB
+
 	DataSourceName() string
 }
 
 type testDB struct {
+	server     string
 	connparams connparams.ConnectionParams
 }
 
 func (db *testDB) isTestDB() {}
-func (db *testDB) name() string {
+func (db *testDB) Name() string {
 	return db.connparams.DBName()
 }
-func (db *testDB) Name() string { return db.name() }
+func (db *testDB) Server() string { return db.server }
+func (db *testDB) withConnParams(opts ...connparams.Option) TestDB {
+	return &testDB{server: db.server, connparams: db.connparams.With(opts...)}
+}
 
 func (db *testDB) DataSourceName() string {
 	return db.connparams.URI().String()
 }
+
+// String returns the connection params of the TestDB with the password
+// redacted, so TestDBs can be safely logged.
+func (db *testDB) String() string {
+	return db.connparams.String()
+}
+
+func (db *testDB) GoString() string {
+	return "&pgtest.testDB{server: " + strconv.Quote(db.server) + ", connparams: " + db.connparams.GoString() + "}"
+}
+
+func (db *testDB) LogValue() slog.Value {
+	return db.connparams.LogValue()
+}
+
+// psqlCommand returns a psql command to connect to the TestDB, which leaves out
+// the password.
+func (db *testDB) psqlCommand() string {
+	return db.connparams.PsqlCommand()
+}
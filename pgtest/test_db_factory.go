@@ -6,25 +6,40 @@ import (
 	"fmt"
 	"math/rand"
 	"slices"
-	"strings"
 	"sync"
 )
 
 const testDBNamePrefix = "pg_test_"
 
 type testDBFactory struct {
+	// server is the name of the server the test databases are created on.
+	server       string
 	paramFactory connparamsFactory
 
 	rootDB *rootDB
+	naming dbNaming
 	mut    sync.Mutex
 	rng    *rand.Rand
+
+	// preflight is the result of the preflight checks for the server, if
+	// they were run.
+	preflight *preflightReport
+}
+
+// canDropForce returns whether the server supports DROP DATABASE ... WITH
+// (FORCE).
+func (s *testDBFactory) canDropForce() bool {
+	return s.preflight != nil && s.preflight.dropForce
 }
 
-func (s *testDBFactory) randomDBName() string {
+// newDBName returns the name of a new test database, which includes the name of
+// the test it is being created for if ctx carries one.
+func (s *testDBFactory) newDBName(ctx context.Context) (string, error) {
 	s.mut.Lock()
-	defer s.mut.Unlock()
+	random := s.rng.Int()
+	s.mut.Unlock()
 
-	return fmt.Sprintf("%s%d", testDBNamePrefix, s.rng.Int())
+	return s.naming.name(ctx, random)
 }
 
 func (s *testDBFactory) createTestDB(ctx context.Context) (TestDB, error) {
@@ -34,12 +49,17 @@ func (s *testDBFactory) createTestDB(ctx context.Context) (TestDB, error) {
 	)
 
 	for retryCount > 0 {
-		dbName := s.randomDBName()
+		var dbName string
+		dbName, err = s.newDBName(ctx)
+		if err != nil {
+			return nil, err
+		}
 
 		err = s.rootDB.createDatabase(ctx, dbName)
 		if err == nil {
 			ps := s.paramFactory(dbName)
 			return &testDB{
+				server:     s.server,
 				connparams: ps,
 			}, nil
 		}
@@ -55,7 +75,7 @@ func (s *testDBFactory) createTestDB(ctx context.Context) (TestDB, error) {
 }
 
 func (s *testDBFactory) destroyTestDB(ctx context.Context, testDB TestDB) error {
-	return s.rootDB.dropDatabase(ctx, testDB.name())
+	return s.rootDB.dropDatabase(ctx, testDB.Name(), s.canDropForce())
 }
 
 func (s *testDBFactory) close() {
@@ -69,11 +89,11 @@ func (s *testDBFactory) destroyAllTestDBs(ctx context.Context) error {
 	}
 
 	toDrop := slices.DeleteFunc(dbNames, func(name string) bool {
-		return !strings.HasPrefix(name, testDBNamePrefix)
+		return !s.naming.isTestDBName(name)
 	})
 
 	for _, dbName := range toDrop {
-		if err := s.rootDB.dropDatabase(ctx, dbName); err != nil {
+		if err := s.rootDB.dropDatabase(ctx, dbName, s.canDropForce()); err != nil {
 			return fmt.Errorf("drop database %q: %w", dbName, err)
 		}
 	}
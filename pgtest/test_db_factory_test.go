@@ -396,3 +396,93 @@ func TestDBFactoryDestroyAllTestDBsSuccess(t *testing.T) {
 		t.Errorf("mock pool has unfulfilled expectations: %s", err)
 	}
 }
+
+func TestDBFactoryDestroyAllTestDBsCustomPrefix(t *testing.T) {
+	var (
+		ctx = context.Background()
+
+		existingDBNames = []string{
+			"pg_test_1",
+			"proj_a_1",
+			"proj_b_1",
+			"proj_a_pkg_testfoo_261018150405_1ly7vk",
+		}
+
+		expectedDropped = []string{
+			"proj_a_1",
+			"proj_a_pkg_testfoo_261018150405_1ly7vk",
+		}
+	)
+
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+	mockPool.MatchExpectationsInOrder(false)
+
+	factory := &testDBFactory{
+		rootDB: &rootDB{db: mockPool},
+		naming: dbNaming{prefix: "proj_a_"},
+		rng:    rand.New(new(sequentialRandSource)),
+	}
+	defer factory.close()
+
+	getCurrentDBsRows := pgxmock.NewRows([]string{"datname"})
+	for _, name := range existingDBNames {
+		getCurrentDBsRows.AddRow(name)
+	}
+
+	mockPool.
+		ExpectQuery(regexp.QuoteMeta(`SELECT datname FROM pg_database;`)).
+		WillReturnRows(getCurrentDBsRows).
+		RowsWillBeClosed()
+
+	for _, name := range expectedDropped {
+		mockPool.
+			ExpectExec(regexp.QuoteMeta(fmt.Sprintf("DROP DATABASE %q;", name))).
+			WillReturnResult(pgxmock.NewResult("DROP DATABASE", 1))
+	}
+
+	if err := factory.destroyAllTestDBs(ctx); err != nil {
+		t.Fatalf("factory.destroyAllTestDBs(ctx) = %s; want nil", err)
+	}
+
+	if err := mockPool.ExpectationsWereMet(); err != nil {
+		t.Errorf("mock pool has unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBFactoryCreateTestDBDescriptiveName(t *testing.T) {
+	mockPool, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("unexpected error creating mock pgx pool: %s", err)
+	}
+
+	factory := &testDBFactory{
+		paramFactory: func(dbName string) connparams.ConnectionParams {
+			return connparams.New(dbName)
+		},
+		rootDB: &rootDB{db: mockPool},
+		naming: dbNaming{prefix: "proj_", namer: DescriptiveDBName, pkg: "store"},
+		rng:    rand.New(new(sequentialRandSource)),
+	}
+	defer factory.close()
+
+	mockPool.
+		ExpectExec(`CREATE DATABASE "proj_store_testfoo_bar_\d{12}_[0-9a-z]+"`).
+		WillReturnResult(pgxmock.NewResult("CREATE DATABASE", 1))
+
+	ctx := withTestName(context.Background(), "TestFoo/bar")
+	created, err := factory.createTestDB(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error from factory.createTestDB: %s", err)
+	}
+
+	if name := created.Name(); !strings.HasPrefix(name, "proj_store_testfoo_bar_") {
+		t.Errorf("created.Name() = %q; want the prefix, package and test name", name)
+	}
+
+	if err := mockPool.ExpectationsWereMet(); err != nil {
+		t.Errorf("mock pool has unfulfilled expectations: %s", err)
+	}
+}